@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"httpfromtcp/internal/client"
+	"httpfromtcp/internal/histogram"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// result is what a single connection worker measured
+type result struct {
+	latencies   *histogram.Histogram
+	requests    int64
+	bytesRead   int64
+	statuses    map[int]int64
+	errors      map[string]int64
+	connections int64
+}
+
+func newResult() *result {
+	latencies, _ := histogram.New(3)
+	return &result{latencies: latencies, statuses: map[int]int64{}, errors: map[string]int64{}}
+}
+
+func (r *result) merge(other *result) error {
+	if err := r.latencies.Merge(other.latencies); err != nil {
+		return err
+	}
+	r.requests += other.requests
+	r.bytesRead += other.bytesRead
+	r.connections += other.connections
+	for status, count := range other.statuses {
+		r.statuses[status] += count
+	}
+	for kind, count := range other.errors {
+		r.errors[kind] += count
+	}
+	return nil
+}
+
+// unanswered counts the requests of a batch sent after the one at i, which
+// get no response once the connection is closed.
+func (r *result) unanswered(due []time.Time, i int) {
+	if left := len(due) - i - 1; left > 0 {
+		r.errors["unanswered"] += int64(left)
+	}
+}
+
+type config struct {
+	address   string
+	method    string
+	raw       []byte
+	keepAlive bool
+	pipeline  int
+	timeout   time.Duration
+	deadline  time.Time
+	// tokens paces the requests in fixed rate mode, each carries the time
+	// the request was due so queueing delay counts towards the latency
+	tokens <-chan time.Time
+}
+
+func main() {
+	connections := flag.Int("c", 10, "number of concurrent connections")
+	duration := flag.Duration("d", 10*time.Second, "how long to run the benchmark")
+	rate := flag.Int("rate", 0, "requests per second across all connections, 0 sends as fast as possible")
+	keepAlive := flag.Bool("keepalive", true, "reuse connections, otherwise every request opens a new one")
+	pipeline := flag.Int("pipeline", 1, "requests sent on a connection before reading the responses, needs keep-alive")
+	method := flag.String("X", "GET", "request method")
+	body := flag.String("body", "", "request body")
+	timeout := flag.Duration("timeout", 5*time.Second, "time allowed for each batch of requests")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] http://host[:port]/path\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *connections < 1 || *pipeline < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !*keepAlive && *pipeline > 1 {
+		log.Fatalf("pipelining needs keep-alive\n")
+	}
+
+	var requestBody []byte
+	if *body != "" {
+		requestBody = []byte(*body)
+	}
+	req, err := client.NewRequest(*method, flag.Arg(0), requestBody)
+	if err != nil {
+		log.Fatalf("failed to build request, reason: %v\n", err)
+	}
+	address := req.Authority()
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "80")
+	}
+	req.Headers.Set("Host", req.Authority())
+	req.Headers.Set("User-Agent", "httpbench")
+	if !*keepAlive {
+		req.Headers.Set("Connection", "close")
+	}
+	req.RequestLine.RequestTarget = req.OriginForm()
+	var raw bytes.Buffer
+	if err := req.Write(&raw); err != nil {
+		log.Fatalf("failed to serialise request, reason: %v\n", err)
+	}
+
+	start := time.Now()
+	cfg := config{
+		address:   address,
+		method:    *method,
+		raw:       raw.Bytes(),
+		keepAlive: *keepAlive,
+		pipeline:  *pipeline,
+		timeout:   *timeout,
+		deadline:  start.Add(*duration),
+	}
+	var sched *schedule
+	if *rate > 0 {
+		sched = &schedule{}
+		cfg.tokens = pace(*rate, cfg.deadline, sched)
+	}
+
+	mode := "as fast as possible"
+	if *rate > 0 {
+		mode = fmt.Sprintf("%d requests/s", *rate)
+	}
+	fmt.Printf("Running %s benchmark @ %s\n", *duration, flag.Arg(0))
+	fmt.Printf("  %d connections, keep-alive %t, pipeline %d, %s\n", *connections, *keepAlive, *pipeline, mode)
+
+	results := make(chan *result, *connections)
+	var wg sync.WaitGroup
+	for range *connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- run(cfg)
+		}()
+	}
+	wg.Wait()
+	close(results)
+	elapsed := time.Since(start)
+	if sched != nil {
+		// tokens still queued when the workers stopped were never sent
+		for range cfg.tokens {
+			sched.dropped.Add(1)
+		}
+	}
+
+	total := newResult()
+	for r := range results {
+		if err := total.merge(r); err != nil {
+			log.Fatalf("failed to merge results, reason: %v\n", err)
+		}
+	}
+	report(total, elapsed, sched)
+}
+
+// schedule counts the requests due in fixed rate mode and those dropped
+// because every connection was busy, which mean the rate was not reached.
+type schedule struct {
+	due     atomic.Int64
+	dropped atomic.Int64
+}
+
+// pace issues rate tokens per second until deadline, counting them in
+// sched. Tokens are buffered so a stalled worker does not hold back the
+// schedule.
+func pace(rate int, deadline time.Time, sched *schedule) <-chan time.Time {
+	tokens := make(chan time.Time, rate)
+	interval := time.Second / time.Duration(rate)
+	go func() {
+		defer close(tokens)
+		next := time.Now()
+		for next.Before(deadline) {
+			time.Sleep(time.Until(next))
+			sched.due.Add(1)
+			select {
+			case tokens <- next:
+			default:
+				// every worker is busy, the request is dropped
+				sched.dropped.Add(1)
+			}
+			next = next.Add(interval)
+		}
+	}()
+	return tokens
+}
+
+func run(cfg config) *result {
+	r := newResult()
+	var conn net.Conn
+	var reader *bufio.Reader
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+
+	for time.Now().Before(cfg.deadline) {
+		due := make([]time.Time, 0, cfg.pipeline)
+		for range cfg.pipeline {
+			if cfg.tokens == nil {
+				due = append(due, time.Now())
+				continue
+			}
+			token, ok := <-cfg.tokens
+			if !ok {
+				break
+			}
+			due = append(due, token)
+		}
+		if len(due) == 0 {
+			break
+		}
+
+		if conn == nil {
+			var err error
+			conn, err = net.DialTimeout("tcp", cfg.address, cfg.timeout)
+			if err != nil {
+				r.errors["connect"]++
+				conn = nil
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			r.connections++
+			reader = bufio.NewReader(conn)
+		}
+		conn.SetDeadline(time.Now().Add(cfg.timeout))
+
+		if _, err := conn.Write(bytes.Repeat(cfg.raw, len(due))); err != nil {
+			r.errors["write"]++
+			closeConn()
+			continue
+		}
+		for i, dueAt := range due {
+			resp, err := response.ResponseFromReader(reader, cfg.method)
+			if err != nil {
+				r.errors[errorKind(err)]++
+				r.unanswered(due, i)
+				closeConn()
+				break
+			}
+			n, err := io.Copy(io.Discard, resp.Body)
+			r.bytesRead += n
+			if err != nil {
+				r.errors[errorKind(err)]++
+				r.unanswered(due, i)
+				closeConn()
+				break
+			}
+			r.latencies.Record(time.Since(dueAt).Microseconds())
+			r.requests++
+			r.statuses[resp.StatusLine.StatusCode]++
+			if resp.Close || !cfg.keepAlive {
+				r.unanswered(due, i)
+				closeConn()
+				break
+			}
+		}
+	}
+	return r
+}
+
+func errorKind(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "read"
+}
+
+// report prints the totals, sched is nil unless running at a fixed rate.
+func report(r *result, elapsed time.Duration, sched *schedule) {
+	seconds := elapsed.Seconds()
+	fmt.Printf("Requests:     %d in %.2fs, %.1f requests/s\n", r.requests, seconds, float64(r.requests)/seconds)
+	if sched != nil {
+		due, dropped := sched.due.Load(), sched.dropped.Load()
+		fmt.Printf("Schedule:     %d due, %d dropped with every connection busy", due, dropped)
+		if dropped > 0 {
+			fmt.Print(", the target rate was missed")
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Transferred:  %d body bytes, %.1f KB/s\n", r.bytesRead, float64(r.bytesRead)/1024/seconds)
+	fmt.Printf("Connections:  %d opened\n", r.connections)
+
+	statuses := []string{}
+	for _, status := range slices.Sorted(maps.Keys(r.statuses)) {
+		statuses = append(statuses, fmt.Sprintf("%d=%d", status, r.statuses[status]))
+	}
+	fmt.Printf("Status codes: %s\n", strings.Join(statuses, " "))
+
+	errorCounts := []string{}
+	for _, kind := range slices.Sorted(maps.Keys(r.errors)) {
+		errorCounts = append(errorCounts, kind+"="+strconv.FormatInt(r.errors[kind], 10))
+	}
+	if len(errorCounts) == 0 {
+		errorCounts = append(errorCounts, "none")
+	}
+	fmt.Printf("Errors:       %s\n", strings.Join(errorCounts, " "))
+
+	h := r.latencies
+	fmt.Println("Latency:")
+	fmt.Printf("  mean %s\n", microseconds(int64(h.Mean())))
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Printf("  p%-3v %s\n", p, microseconds(h.ValueAtPercentile(p)))
+	}
+	fmt.Printf("  max  %s\n", microseconds(h.Max()))
+}
+
+func microseconds(us int64) time.Duration {
+	return time.Duration(us) * time.Microsecond
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"httpfromtcp/internal/client"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerFlags collects every -H given on the command line
+type headerFlags []string
+
+func (hf *headerFlags) String() string {
+	return strings.Join(*hf, ", ")
+}
+
+func (hf *headerFlags) Set(value string) error {
+	*hf = append(*hf, value)
+	return nil
+}
+
+func main() {
+	method := flag.String("X", "", "request method, defaults to GET or POST when data is sent")
+	var headerLines headerFlags
+	flag.Var(&headerLines, "H", "extra header 'Name: value', can be repeated")
+	data := flag.String("d", "", "send data as an application/x-www-form-urlencoded body")
+	dataBinary := flag.String("data-binary", "", "send data as is, '@file' reads it from a file")
+	include := flag.Bool("i", false, "include the response status line and headers in the output")
+	verbose := flag.Bool("v", false, "print the raw request and response bytes to stderr, not available with -udp")
+	timeout := flag.Duration("timeout", client.DefaultTimeout, "time allowed for the whole exchange")
+	udp := flag.Bool("udp", false, "send the request over the experimental HTTP over UDP transport, see httplistener -udp")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] http://host[:port]/path\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// the UDP transport does not dial through c.Dial, where the dump is made
+	if *verbose && *udp {
+		fmt.Fprintf(flag.CommandLine.Output(), "-v can not be combined with -udp\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	body, contentType, err := readBody(*data, *dataBinary)
+	if err != nil {
+		log.Fatalf("failed to read data, reason: %v\n", err)
+	}
+	if *method == "" {
+		*method = "GET"
+		if body != nil {
+			*method = "POST"
+		}
+	}
+
+	req, err := client.NewRequest(*method, flag.Arg(0), body)
+	if err != nil {
+		log.Fatalf("failed to build request, reason: %v\n", err)
+	}
+	if body != nil {
+		req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+		if contentType != "" {
+			req.Headers.Set("Content-Type", contentType)
+		}
+	}
+	req.Headers.Set("User-Agent", "httpclient")
+	req.Headers.Set("Accept", "*/*")
+	for _, line := range headerLines {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			log.Fatalf("invalid header '%s', expected 'Name: value'\n", line)
+		}
+		req.Headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	c := client.NewClient()
+	c.Timeout = *timeout
+	c.DisableKeepAlives = true
+	if *verbose {
+		c.Dial = func(network string, address string) (net.Conn, error) {
+			conn, err := net.DialTimeout(network, address, c.DialTimeout)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "* connected to %s\n", conn.RemoteAddr())
+			return &wireConn{Conn: conn, out: os.Stderr}, nil
+		}
+	}
+
+	start := time.Now()
+	do := c.Do
+	if *udp {
+		do = c.DoUDP
+	}
+	resp, err := do(req)
+	if err != nil {
+		log.Fatalf("request failed, reason: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	if *include {
+		fmt.Printf("HTTP/%s %d %s\r\n", resp.StatusLine.HttpVersion, resp.StatusLine.StatusCode, resp.StatusLine.ReasonPhrase)
+		resp.Headers.Write(os.Stdout)
+		fmt.Print("\r\n")
+	}
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		log.Fatalf("failed to read body, reason: %v\n", err)
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "\n* completed in %s\n", time.Since(start))
+	}
+}
+
+// readBody returns the body given by -d or --data-binary, nil when neither is
+// set, along with the Content-Type to send it with.
+func readBody(data string, dataBinary string) ([]byte, string, error) {
+	if dataBinary != "" {
+		if path, ok := strings.CutPrefix(dataBinary, "@"); ok {
+			body, err := os.ReadFile(path)
+			return body, "application/octet-stream", err
+		}
+		return []byte(dataBinary), "application/octet-stream", nil
+	}
+	if data != "" {
+		return []byte(data), "application/x-www-form-urlencoded", nil
+	}
+	return nil, "", nil
+}
+
+// wireConn copies everything sent and received to out, prefixing each line
+// with '>' for outgoing and '<' for incoming bytes like curl does.
+type wireConn struct {
+	net.Conn
+	out       io.Writer
+	mu        sync.Mutex
+	lastSent  bool
+	midLine   bool
+	anyOutput bool
+}
+
+func (wc *wireConn) Write(p []byte) (int, error) {
+	n, err := wc.Conn.Write(p)
+	wc.dump("> ", true, p[:n])
+	return n, err
+}
+
+func (wc *wireConn) Read(p []byte) (int, error) {
+	n, err := wc.Conn.Read(p)
+	wc.dump("< ", false, p[:n])
+	return n, err
+}
+
+func (wc *wireConn) dump(prefix string, sent bool, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	// a change of direction always starts a new line
+	if wc.anyOutput && sent != wc.lastSent && wc.midLine {
+		fmt.Fprint(wc.out, "\n")
+		wc.midLine = false
+	}
+	wc.lastSent = sent
+	wc.anyOutput = true
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		if !wc.midLine {
+			fmt.Fprint(wc.out, prefix)
+		}
+		fmt.Fprint(wc.out, line)
+		wc.midLine = !strings.HasSuffix(line, "\n")
+	}
+}
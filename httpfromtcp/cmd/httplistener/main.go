@@ -1,29 +1,152 @@
 package main
 
 import (
+	"flag"
+	"httpfromtcp/internal/accesslog"
+	"httpfromtcp/internal/compression"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/proxy"
+	"httpfromtcp/internal/proxyprotocol"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
 	"httpfromtcp/internal/server"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 8080
 
+func handler(w *response.Writer, req *request.Request) {
+	w.WriteText(response.StatusOK, "Hello World!\r\n")
+}
+
 func main() {
-	server, err := server.Serve(port)
-	if err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	accessLogPath := flag.String("access-log", "", "also write a combined format access log to this file")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 10<<20, "rotate the access log file once it reaches this many bytes")
+	metricsPath := flag.String("metrics-path", "/metrics", "path serving Prometheus metrics, empty to disable")
+	proxyUpstream := flag.String("proxy-upstream", "", "forward every request to this upstream address instead of answering locally, a comma separated list load balances")
+	proxyStrategy := flag.String("proxy-strategy", "round-robin", "load balancing strategy: round-robin, least-connections or consistent-hash")
+	proxyHashHeader := flag.String("proxy-hash-header", "", "request header hashed by the consistent-hash strategy")
+	forwardProxy := flag.Bool("forward-proxy", false, "act as an HTTP proxy for clients, fetching absolute-form targets and tunnelling CONNECT")
+	connectPorts := flag.String("connect-ports", "443", "comma separated destination ports CONNECT may tunnel to")
+	healthCheckPath := flag.String("health-check-path", "", "path probed on every upstream to check its health, empty to disable")
+	healthCheckInterval := flag.Duration("health-check-interval", 10*time.Second, "time between health checks")
+	udp := flag.Bool("udp", false, "also serve requests over the experimental HTTP over UDP transport on the same port")
+	unixSocket := flag.String("unix", "", "listen on this Unix domain socket path instead of the TCP port")
+	unixMode := flag.String("unix-mode", "0660", "octal permissions of the Unix domain socket")
+	proxyProtocol := flag.String("proxy-protocol", "", "comma separated addresses or CIDR prefixes of load balancers sending a PROXY protocol header, \"unix\" for peers of the -unix socket, empty to disable")
+	flag.Parse()
+
+	lineWriters := []*accesslog.LineWriter{}
+	if *accessLogPath != "" {
+		file, err := accesslog.NewRotatingFile(*accessLogPath, *accessLogMaxSize, 5)
+		if err != nil {
+			log.Fatalf("Error opening access log: %v", err)
+		}
+		defer file.Close()
+		lineWriters = append(lineWriters, accesslog.NewLineWriter(file, accesslog.FormatCombined))
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	requestLogger := server.WithRequestLogger(accesslog.NewRequestLogger(logger, lineWriters...))
+	options := []server.Option{requestLogger}
+	if *metricsPath != "" {
+		options = append(options, server.WithMetrics(metrics.NewRegistry(), *metricsPath))
+	}
+	if *proxyProtocol != "" {
+		trusted, err := proxyprotocol.ParseTrusted(*proxyProtocol)
+		if err != nil {
+			log.Fatalf("Error configuring PROXY protocol: %v", err)
+		}
+		options = append(options, server.WithProxyProtocol(trusted))
+	}
+
+	var h server.Handler = handler
+	if *forwardProxy {
+		ports := []int{}
+		for _, portRaw := range strings.Split(*connectPorts, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(portRaw))
+			if err != nil {
+				log.Fatalf("Error parsing CONNECT port '%s': %v", portRaw, err)
+			}
+			ports = append(ports, port)
+		}
+		h = proxy.NewForwardProxy(ports...).Handle
+	} else if upstreams := strings.Split(*proxyUpstream, ","); len(upstreams) > 1 {
+		strategy, err := proxy.ParseStrategy(*proxyStrategy)
+		if err != nil {
+			log.Fatalf("Error configuring proxy: %v", err)
+		}
+		lb := proxy.NewLoadBalancer(upstreams, strategy)
+		lb.HashHeader = *proxyHashHeader
+		if *healthCheckPath != "" {
+			lb.StartHealthChecks(*healthCheckPath, *healthCheckInterval)
+		}
+		defer lb.Close()
+		h = lb.Handle
+	} else if *proxyUpstream != "" {
+		h = proxy.NewReverseProxy(*proxyUpstream).Handle
+	}
+
+	h = server.Chain(h,
+		compression.NewRequestDecoder(compression.DefaultMaxDecodedSize),
+		compression.Middleware,
+	)
+	if *udp {
+		udpServer, err := server.ServeUDP(port, h, requestLogger)
+		if err != nil {
+			log.Fatalf("Error starting udp server: %v", err)
+		}
+		defer udpServer.Close()
+		log.Println("Serving HTTP over UDP on port", port)
 	}
-	defer server.Close()
 
-	go func() {
-		if err := <-server.Err(); err != nil {
-			log.Printf("server error: %v", err)
+	// sockets passed by systemd take precedence over the other listeners
+	listeners, err := server.SystemdListeners()
+	if err != nil {
+		log.Fatalf("Error adopting systemd sockets: %v", err)
+	}
+	servers := []*server.Server{}
+	switch {
+	case len(listeners) > 0:
+		for _, listener := range listeners {
+			servers = append(servers, server.ServeListener(listener, h, options...))
+			log.Println("Server started on systemd socket", listener.Addr())
+		}
+	case *unixSocket != "":
+		mode, err := strconv.ParseUint(*unixMode, 8, 32)
+		if err != nil {
+			log.Fatalf("Error parsing unix socket mode '%s': %v", *unixMode, err)
+		}
+		listener, err := server.ListenUnix(*unixSocket, os.FileMode(mode))
+		if err != nil {
+			log.Fatalf("Error starting server: %v", err)
 		}
-	}()
+		servers = append(servers, server.ServeListener(listener, h, options...))
+		log.Println("Server started on unix socket", *unixSocket)
+	default:
+		s, err := server.Serve(port, h, options...)
+		if err != nil {
+			log.Fatalf("Error starting server: %v", err)
+		}
+		servers = append(servers, s)
+		log.Println("Server started on port", port)
+	}
 
-	log.Println("Server started on port", port)
+	for _, s := range servers {
+		defer s.Close()
+		go func() {
+			if err := <-s.Err(); err != nil {
+				log.Printf("server error: %v", err)
+			}
+		}()
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
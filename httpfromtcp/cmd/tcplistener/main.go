@@ -1,14 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"httpfromtcp/internal/request"
+	"io"
 	"log"
 	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 func main() {
-	listener, err := net.Listen("tcp4", ":42069")
+	address := flag.String("addr", ":42069", "address to listen on")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp4", *address)
 
 	if err != nil {
 		log.Fatalf("failed to create listener, reason: %v\n", err)
@@ -18,38 +29,156 @@ func main() {
 
 	defer listener.Close()
 
+	// connections are inspected concurrently, out keeps their reports whole
+	var out sync.Mutex
+
+	// backoff spaces out retries while accepting keeps failing, for example
+	// when the process runs out of file descriptors
+	var backoff time.Duration
 	for {
 		conn, err := listener.Accept()
 
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			log.Fatalf("failed to accept connection, reason: %v\n", err)
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			log.Printf("failed to accept connection, retrying in %s, reason: %v\n", backoff, err)
+			time.Sleep(backoff)
+			continue
 		}
+		backoff = 0
 
 		go func(conn net.Conn) {
 			defer conn.Close()
+			inspect(conn, os.Stdout, &out)
+		}(conn)
+	}
+}
 
-			fmt.Printf("connection has been accepted on %s\n", conn.LocalAddr())
+// inspect parses every request sent on conn and prints a report for each,
+// answering 200 to valid requests and 400 to malformed ones.
+func inspect(conn net.Conn, out io.Writer, outMu *sync.Mutex) {
+	// the bytes read for the current request are kept to be dumped
+	// afterwards, along with any read ahead of the next one
+	var received bytes.Buffer
+	reader := request.NewReader(io.TeeReader(conn, &received))
 
-			req, err := request.RequestFromReader(conn)
+	for {
+		req, err := reader.ReadRequest()
+		end := received.Len() - len(reader.Buffered())
+		if errors.Is(err, io.EOF) {
+			return
+		}
 
-			if err != nil {
-				log.Fatalf("failed to parse request, reason: %v", err)
-			}
+		var report bytes.Buffer
+		fmt.Fprintf(&report, "=== request from %s ===\n", conn.RemoteAddr())
 
-			fmt.Println("Request line:")
-			fmt.Printf("- Methd: %s\n", req.RequestLine.Method)
-			fmt.Printf("- Target: %s\n", req.RequestLine.RequestTarget)
-			fmt.Printf("- Version: %s\n", req.RequestLine.HttpVersion)
+		if err != nil {
+			raw := received.Bytes()
+			writeDump(&report, raw)
+			writeParseError(&report, raw, err)
+			printReport(out, outMu, &report)
+			fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%v\n", len(err.Error())+1, err)
+			return
+		}
+
+		raw := received.Bytes()[:end]
+		writeDump(&report, raw)
+		writeSections(&report, raw, req)
+		printReport(out, outMu, &report)
+		// forget the request so kept alive connections do not grow the buffer
+		received.Next(end)
+
+		if req.Headers.HasToken("Connection", "close") {
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\nConnection: close\r\n\r\nOK")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nOK")
+	}
+}
+
+func printReport(out io.Writer, outMu *sync.Mutex, report *bytes.Buffer) {
+	outMu.Lock()
+	defer outMu.Unlock()
+	report.WriteTo(out)
+}
 
-			fmt.Println("Headers")
-			for k, v := range req.Headers {
-				fmt.Printf("- %s - %v\n", k, v)
+// writeDump prints raw the way hexdump -C does, offsets are relative to the
+// start of the request.
+func writeDump(w io.Writer, raw []byte) {
+	fmt.Fprintf(w, "--- raw bytes (%d) ---\n", len(raw))
+	for offset := 0; offset < len(raw); offset += 16 {
+		line := raw[offset:min(offset+16, len(raw))]
+		fmt.Fprintf(w, "%08x  ", offset)
+		for i := range 16 {
+			if i < len(line) {
+				fmt.Fprintf(w, "%02x ", line[i])
+			} else {
+				fmt.Fprint(w, "   ")
 			}
-			fmt.Printf("%s\n", string(req.Body))
-			response := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nOK"
-			conn.Write([]byte(response))
+			if i == 7 {
+				fmt.Fprint(w, " ")
+			}
+		}
+		fmt.Fprintf(w, " |%s|\n", printable(line))
+	}
+}
 
-			fmt.Printf("connection has been closed on %s\n", conn.LocalAddr())
-		}(conn)
+func printable(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		if b >= 0x20 && b < 0x7f {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteByte('.')
+		}
+	}
+	return sb.String()
+}
+
+// writeSections annotates the request line, each header line and the body
+// with the byte range they occupy.
+func writeSections(w io.Writer, raw []byte, req *request.Request) {
+	requestLineEnd := bytes.Index(raw, []byte("\r\n")) + 2
+	fmt.Fprintf(w, "--- request line [%d, %d) ---\n", 0, requestLineEnd)
+	fmt.Fprintf(w, "  method:  %s\n", req.RequestLine.Method)
+	fmt.Fprintf(w, "  target:  %s\n", req.RequestLine.RequestTarget)
+	fmt.Fprintf(w, "  version: %s\n", req.RequestLine.HttpVersion)
+
+	headersEnd := requestLineEnd + bytes.Index(raw[requestLineEnd:], []byte("\r\n\r\n")) + 4
+	if bytes.HasPrefix(raw[requestLineEnd:], []byte("\r\n")) {
+		headersEnd = requestLineEnd + 2
+	}
+	fmt.Fprintf(w, "--- headers [%d, %d) ---\n", requestLineEnd, headersEnd)
+	offset := requestLineEnd
+	for offset < headersEnd-2 {
+		lineEnd := offset + bytes.Index(raw[offset:], []byte("\r\n"))
+		fmt.Fprintf(w, "  @%-5d %q\n", offset, raw[offset:lineEnd])
+		offset = lineEnd + 2
+	}
+
+	fmt.Fprintf(w, "--- body [%d, %d) ---\n", headersEnd, len(raw))
+	if len(req.Body) > 0 {
+		fmt.Fprintf(w, "  %q\n", req.Body)
+	}
+}
+
+// writeParseError shows where parsing stopped along with the line found
+// there.
+func writeParseError(w io.Writer, raw []byte, err error) {
+	var parseErr *request.ParseError
+	if !errors.As(err, &parseErr) {
+		fmt.Fprintf(w, "--- read error ---\n  %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "--- parse error in %s at byte %d ---\n", parseErr.Kind, parseErr.Offset)
+	fmt.Fprintf(w, "  %v\n", parseErr.Err)
+	if parseErr.Offset < len(raw) {
+		rest := raw[parseErr.Offset:]
+		if lineEnd := bytes.Index(rest, []byte("\r\n")); lineEnd != -1 {
+			rest = rest[:lineEnd+2]
+		}
+		fmt.Fprintf(w, "  offending bytes: %q\n", rest)
 	}
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"httpfromtcp/internal/datagram"
+	"httpfromtcp/internal/reliable"
+	"log"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+func main() {
+	address := flag.String("addr", ":42069", "address to listen on")
+	drop := flag.Float64("drop", 0, "probability between 0 and 1 of ignoring a datagram, to simulate loss")
+	reliableMode := flag.Bool("reliable", false, "deliver the messages of reliable senders in order and without duplicates")
+	idleTimeout := flag.Duration("idle", 5*time.Minute, "forget the statistics of senders silent for this long")
+	flag.Parse()
+
+	localUDPAddr, err := net.ResolveUDPAddr("udp4", *address)
+
+	if err != nil {
+		log.Fatalf("failed to resolve udp address, reason: %v\n", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", localUDPAddr)
+
+	if err != nil {
+		log.Fatalf("failed to listen on %s, reason: %v\n", localUDPAddr, err)
+	}
+
+	defer conn.Close()
+
+	fmt.Printf("started listener on %s\n", conn.LocalAddr())
+
+	if *reliableMode {
+		listenReliable(&lossyConn{PacketConn: conn, drop: *drop})
+		return
+	}
+
+	// every sender numbers its datagrams on its own
+	senders := newSenders(*idleTimeout)
+	buffer := make([]byte, datagram.HeaderSize+datagram.MaxPayloadSize)
+
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+
+		if err != nil {
+			log.Printf("failed to read datagram, reason: %v\n", err)
+			continue
+		}
+
+		if rand.Float64() < *drop {
+			fmt.Printf("[%s] dropped %d bytes\n", remoteAddr, n)
+			continue
+		}
+
+		packet, err := datagram.Unmarshal(buffer[:n])
+
+		if err != nil {
+			fmt.Printf("[%s] invalid datagram: %v\n", remoteAddr, err)
+			continue
+		}
+
+		if packet.Type != datagram.TypeData {
+			fmt.Printf("[%s] unexpected %s\n", remoteAddr, packet.Type)
+			continue
+		}
+
+		tracker := senders.tracker(remoteAddr.String(), time.Now())
+		arrival := tracker.Observe(packet.Sequence)
+
+		fmt.Printf("[%s] seq=%d %s: %q\n", remoteAddr, packet.Sequence, arrival, packet.Payload)
+		stats := tracker.Stats()
+		fmt.Printf("[%s] received=%d missing=%d lost=%d reordered=%d duplicates=%d\n", remoteAddr, stats.Received, stats.Missing, stats.Lost, stats.Reordered, stats.Duplicates)
+
+		ack, _ := datagram.Ack(packet).MarshalBinary()
+		if _, err := conn.WriteToUDP(ack, remoteAddr); err != nil {
+			log.Printf("failed to send ack to %s, reason: %v\n", remoteAddr, err)
+		}
+	}
+}
+
+// maxSenders bounds the trackers kept at once, as any datagram with a
+// spoofed source address adds one
+const maxSenders = 10000
+
+type sender struct {
+	tracker  *datagram.Tracker
+	lastSeen time.Time
+}
+
+// senders holds a tracker per sender address, forgetting senders that stay
+// silent for idleTimeout or the least recently seen one when full.
+type senders struct {
+	idleTimeout time.Duration
+	byAddr      map[string]*sender
+	lastSweep   time.Time
+}
+
+func newSenders(idleTimeout time.Duration) *senders {
+	return &senders{idleTimeout: idleTimeout, byAddr: map[string]*sender{}, lastSweep: time.Now()}
+}
+
+func (s *senders) tracker(addr string, now time.Time) *datagram.Tracker {
+	if now.Sub(s.lastSweep) >= s.idleTimeout {
+		s.lastSweep = now
+		for key, sd := range s.byAddr {
+			if now.Sub(sd.lastSeen) >= s.idleTimeout {
+				fmt.Printf("[%s] forgotten after %s of silence\n", key, now.Sub(sd.lastSeen).Round(time.Second))
+				delete(s.byAddr, key)
+			}
+		}
+	}
+
+	sd, ok := s.byAddr[addr]
+	if !ok {
+		if len(s.byAddr) >= maxSenders {
+			s.evictOldest()
+		}
+		sd = &sender{tracker: datagram.NewTracker()}
+		s.byAddr[addr] = sd
+	}
+	sd.lastSeen = now
+	return sd.tracker
+}
+
+func (s *senders) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, sd := range s.byAddr {
+		if oldestKey == "" || sd.lastSeen.Before(oldest) {
+			oldestKey, oldest = key, sd.lastSeen
+		}
+	}
+	delete(s.byAddr, oldestKey)
+}
+
+func listenReliable(packetConn net.PacketConn) {
+	listener := reliable.Listen(packetConn, reliable.DefaultConfig())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept peer, reason: %v\n", err)
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				msg, err := conn.Receive()
+				if err != nil {
+					fmt.Printf("[%s] gone: %v\n", conn.RemoteAddr(), err)
+					return
+				}
+				stats := conn.Stats()
+				fmt.Printf("[%s] message %d: %q (duplicates=%d)\n", conn.RemoteAddr(), stats.Delivered, msg, stats.Duplicates)
+			}
+		}()
+	}
+}
+
+// lossyConn ignores incoming datagrams with probability drop.
+type lossyConn struct {
+	net.PacketConn
+	drop float64
+}
+
+func (c *lossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || rand.Float64() >= c.drop {
+			return n, addr, err
+		}
+		fmt.Printf("[%s] dropped %d bytes\n", addr, n)
+	}
+}
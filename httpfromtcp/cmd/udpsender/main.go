@@ -2,20 +2,39 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"httpfromtcp/internal/datagram"
+	"httpfromtcp/internal/reliable"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"os"
+	"slices"
+	"sync"
+	"time"
 )
 
 func main() {
-	remoteUDPAddr, err := net.ResolveUDPAddr("udp4", "localhost:42069")
+	address := flag.String("addr", "localhost:42069", "destination address")
+	ackTimeout := flag.Duration("ack-timeout", time.Second, "how long to wait for outstanding acks once stdin is closed")
+	raw := flag.Bool("raw", false, "send each line as is, without the datagram header")
+	reliableMode := flag.Bool("reliable", false, "retransmit lines until acknowledged, the listener must run with -reliable too")
+	flag.Parse()
+
+	remoteUDPAddr, err := net.ResolveUDPAddr("udp4", *address)
 
 	if err != nil {
 		log.Fatalf("failed to resolve udp address, reason: %v\n", err)
 	}
 
+	if *reliableMode {
+		sendReliable(remoteUDPAddr)
+		return
+	}
+
 	conn, err := net.DialUDP("udp4", nil, remoteUDPAddr)
 
 	if err != nil {
@@ -24,7 +43,16 @@ func main() {
 
 	defer conn.Close()
 
+	// sent maps the sequence numbers still waiting for an ack to their send
+	// time, every line fits a single datagram and needs no message id
+	var mu sync.Mutex
+	sent := map[uint32]time.Time{}
+	if !*raw {
+		go readAcks(conn, &mu, sent)
+	}
+
 	reader := bufio.NewReader(os.Stdin)
+	var sequence uint32
 
 	for {
 		fmt.Printf(">")
@@ -40,10 +68,94 @@ func main() {
 			log.Fatalf("failed to read input")
 		}
 
-		_, err = conn.Write([]byte(str))
+		data := []byte(str)
+		if !*raw {
+			sequence++
+			packet := datagram.Packet{Type: datagram.TypeData, Sequence: sequence, Payload: data}
+			data, err = packet.MarshalBinary()
+			if err != nil {
+				log.Printf("failed to encode message, reason: %v\n", err)
+				continue
+			}
+			mu.Lock()
+			sent[packet.Sequence] = time.Now()
+			mu.Unlock()
+		}
+
+		_, err = conn.Write(data)
 
 		if err != nil {
 			log.Fatalf("failed to write input to connection")
 		}
 	}
+
+	if *raw {
+		return
+	}
+	time.Sleep(*ackTimeout)
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Printf("sent %d messages, %d not acknowledged\n", sequence, len(sent))
+	for _, sequence := range slices.Sorted(maps.Keys(sent)) {
+		fmt.Printf("- message %d\n", sequence)
+	}
+}
+
+func readAcks(conn *net.UDPConn, mu *sync.Mutex, sent map[uint32]time.Time) {
+	buffer := make([]byte, datagram.HeaderSize+datagram.MaxPayloadSize)
+	for {
+		n, err := conn.Read(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// nobody listening makes the next read fail with connection refused
+			continue
+		}
+		packet, err := datagram.Unmarshal(buffer[:n])
+		if err != nil || packet.Type != datagram.TypeAck {
+			continue
+		}
+		mu.Lock()
+		sentAt, ok := sent[packet.Sequence]
+		delete(sent, packet.Sequence)
+		mu.Unlock()
+		if ok {
+			fmt.Printf("\nack for message %d after %s\n>", packet.Sequence, time.Since(sentAt))
+		}
+	}
+}
+
+// sendReliable sends every line as a message of a reliable.Conn and waits for
+// all of them to be acknowledged before exiting.
+func sendReliable(remoteUDPAddr *net.UDPAddr) {
+	packetConn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		log.Fatalf("failed to open udp socket, reason: %v\n", err)
+	}
+	conn := reliable.NewConn(packetConn, remoteUDPAddr, reliable.DefaultConfig())
+	defer conn.Close()
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf(">")
+		str, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("failed to read input")
+		}
+		if err := conn.Send([]byte(str)); err != nil {
+			log.Fatalf("failed to send message, reason: %v\n", err)
+		}
+	}
+
+	fmt.Printf("stdin closed, waiting for acks...\n")
+	err = conn.Flush()
+	stats := conn.Stats()
+	fmt.Printf("sent %d datagrams, %d retransmissions\n", stats.Sent, stats.Retransmissions)
+	if err != nil {
+		log.Fatalf("failed to deliver every message, reason: %v\n", err)
+	}
 }
@@ -0,0 +1,156 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry holds everything logged about a served request.
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	LocalAddr  string
+	Method     string
+	Target     string
+	Proto      string
+	Status     int
+	Bytes      int
+	Duration   time.Duration
+	UserAgent  string
+	Referer    string
+}
+
+// NewEntry describes the request, a malformed one has an empty request line
+// and so an empty Method, Target and Proto.
+func NewEntry(req *request.Request, w *response.Writer, start time.Time) Entry {
+	userAgent, _ := req.Headers.Get("User-Agent")
+	referer, _ := req.Headers.Get("Referer")
+	proto := ""
+	if req.RequestLine.HttpVersion != "" {
+		proto = "HTTP/" + req.RequestLine.HttpVersion
+	}
+	return Entry{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		LocalAddr:  req.LocalAddr,
+		Method:     req.RequestLine.Method,
+		Target:     req.RequestLine.RequestTarget,
+		Proto:      proto,
+		Status:     int(w.StatusCode()),
+		Bytes:      w.BytesWritten(),
+		Duration:   time.Since(start),
+		UserAgent:  userAgent,
+		Referer:    referer,
+	}
+}
+
+// Attrs returns the entry as structured log attributes.
+func (e Entry) Attrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("remote_addr", e.RemoteAddr),
+		slog.String("local_addr", e.LocalAddr),
+		slog.String("method", e.Method),
+		slog.String("target", e.Target),
+		slog.String("proto", e.Proto),
+		slog.Int("status", e.Status),
+		slog.Int("bytes", e.Bytes),
+		slog.Duration("duration", e.Duration),
+		slog.String("user_agent", e.UserAgent),
+	}
+}
+
+type Format int
+
+const (
+	// FormatCommon is the NCSA Common Log Format
+	FormatCommon Format = iota
+	// FormatCombined extends FormatCommon with the referer and user agent
+	FormatCombined
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Line renders the entry in the given log format, without a trailing newline.
+func (e Entry) Line(format Format) string {
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(e.RemoteAddr); err == nil {
+		host = h
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.Itoa(e.Bytes)
+	}
+	requestLine := "-"
+	if e.Method != "" {
+		requestLine = e.Method + " " + e.Target + " " + e.Proto
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s\" %d %s",
+		orDash(host), e.Time.Format(clfTimeFormat), requestLine, e.Status, bytes)
+	if format == FormatCombined {
+		line += fmt.Sprintf(" %s %s", quote(e.Referer), quote(e.UserAgent))
+	}
+	return line
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func quote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// LineWriter writes entries one per line in a log file format.
+type LineWriter struct {
+	mu     sync.Mutex
+	writer io.Writer
+	format Format
+}
+
+func NewLineWriter(writer io.Writer, format Format) *LineWriter {
+	return &LineWriter{writer: writer, format: format}
+}
+
+func (lw *LineWriter) WriteEntry(e Entry) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	_, err := io.WriteString(lw.writer, e.Line(lw.format)+"\n")
+	if err != nil {
+		return fmt.Errorf("failed to write access log entry: %v", err)
+	}
+	return nil
+}
+
+// NewRequestLogger logs every request through logger, and to each of the
+// line writers if any are given. It is installed with
+// server.WithRequestLogger, so that requests the server answers itself and
+// hijacked connections are logged too.
+func NewRequestLogger(logger *slog.Logger, lineWriters ...*LineWriter) server.RequestLogger {
+	return func(w *response.Writer, req *request.Request, start time.Time) {
+		entry := NewEntry(req, w, start)
+		if logger != nil {
+			logger.LogAttrs(context.Background(), slog.LevelInfo, "request", entry.Attrs()...)
+		}
+		for _, lw := range lineWriters {
+			if err := lw.WriteEntry(entry); err != nil && logger != nil {
+				logger.Error("failed to write access log", slog.Any("error", err))
+			}
+		}
+	}
+}
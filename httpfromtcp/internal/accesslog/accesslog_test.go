@@ -0,0 +1,129 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogger(t *testing.T) {
+	req, err := request.RequestFromConn(strings.NewReader("GET /coffee?size=large HTTP/1.1\r\n" + "User-Agent: curl/7.81.0\r\n" + "Referer: http://localhost/menu\r\n" + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:54321"
+	req.LocalAddr = "127.0.0.1:8080"
+
+	var logOutput bytes.Buffer
+	var clfOutput bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logOutput, nil))
+	logRequest := NewRequestLogger(logger, NewLineWriter(&clfOutput, FormatCombined))
+	w := response.NewWriter(&bytes.Buffer{})
+	w.WriteText(response.StatusNotFound, "no coffee\n")
+
+	logRequest(w, req, time.Now())
+
+	t.Run("Logs structured attributes", func(t *testing.T) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(logOutput.Bytes(), &record))
+		assert.Equal(t, "request", record["msg"])
+		assert.Equal(t, "127.0.0.1:54321", record["remote_addr"])
+		assert.Equal(t, "127.0.0.1:8080", record["local_addr"])
+		assert.Equal(t, "GET", record["method"])
+		assert.Equal(t, "/coffee?size=large", record["target"])
+		assert.Equal(t, "HTTP/1.1", record["proto"])
+		assert.Equal(t, float64(404), record["status"])
+		assert.Equal(t, float64(10), record["bytes"])
+		assert.Equal(t, "curl/7.81.0", record["user_agent"])
+		assert.Contains(t, record, "duration")
+	})
+
+	t.Run("Writes combined log format", func(t *testing.T) {
+		line := clfOutput.String()
+		assert.True(t, strings.HasPrefix(line, "127.0.0.1 - - ["))
+		assert.True(t, strings.HasSuffix(line, `] "GET /coffee?size=large HTTP/1.1" 404 10 "http://localhost/menu" "curl/7.81.0"`+"\n"))
+	})
+}
+
+func TestEntryLine(t *testing.T) {
+	entry := Entry{
+		Time:       time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		RemoteAddr: "127.0.0.1:80",
+		Method:     "GET",
+		Target:     "/apache_pb.gif",
+		Proto:      "HTTP/1.0",
+		Status:     200,
+		Bytes:      2326,
+	}
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`, entry.Line(FormatCommon))
+
+	entry.Bytes = 0
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 - "-" "-"`, entry.Line(FormatCombined))
+
+	// a malformed request has no request line
+	entry.Method, entry.Target, entry.Proto, entry.Status = "", "", "", 400
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "-" 400 -`, entry.Line(FormatCommon))
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("Keeps at most maxBackups rotated files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "access.log")
+		rf, err := NewRotatingFile(path, 10, 2)
+		require.NoError(t, err)
+		defer rf.Close()
+
+		for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+			_, err := rf.Write([]byte(line))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, "fourth\n", readFile(t, path))
+		assert.Equal(t, "third\n", readFile(t, path+".1"))
+		assert.Equal(t, "second\n", readFile(t, path+".2"))
+		_, err = os.Stat(path + ".3")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Reopens the file on the next write after a failed rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "access.log")
+		rf, err := NewRotatingFile(path, 10, 1)
+		require.NoError(t, err)
+		defer rf.Close()
+
+		_, err = rf.Write([]byte("first\n"))
+		require.NoError(t, err)
+		// a directory in the way of the backup makes the rotation fail
+		require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o755))
+		_, err = rf.Write([]byte("second\n"))
+		assert.Error(t, err)
+
+		require.NoError(t, os.RemoveAll(path+".1"))
+		_, err = rf.Write([]byte("third\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "third\n", readFile(t, path))
+		assert.Equal(t, "first\n", readFile(t, path+".1"))
+	})
+
+	t.Run("Refuses writes once closed", func(t *testing.T) {
+		rf, err := NewRotatingFile(filepath.Join(t.TempDir(), "access.log"), 10, 1)
+		require.NoError(t, err)
+		require.NoError(t, rf.Close())
+		_, err = rf.Write([]byte("late\n"))
+		assert.Error(t, err)
+	})
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
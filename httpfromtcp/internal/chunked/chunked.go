@@ -0,0 +1,163 @@
+package chunked
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const CRLF = "\r\n"
+
+// Writer frames everything written to it using the chunked transfer coding
+// (RFC 9112 section 7.1). Close writes the terminating zero-sized chunk.
+type Writer struct {
+	writer io.Writer
+	closed bool
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{writer: writer}
+}
+
+func (cw *Writer) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, fmt.Errorf("failed to write chunk: writer is closed")
+	}
+	// a zero sized chunk would mark the end of the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.writer, "%x%s", len(p), CRLF); err != nil {
+		return 0, fmt.Errorf("failed to write chunk size: %v", err)
+	}
+	n, err := cw.writer.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("failed to write chunk data: %v", err)
+	}
+	if _, err := io.WriteString(cw.writer, CRLF); err != nil {
+		return n, fmt.Errorf("failed to write chunk end: %v", err)
+	}
+	return n, nil
+}
+
+// Close writes the last chunk. Trailer fields, if any, have to be written by
+// the caller followed by the final CRLF.
+func (cw *Writer) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	if _, err := io.WriteString(cw.writer, "0"+CRLF); err != nil {
+		return fmt.Errorf("failed to write last chunk: %v", err)
+	}
+	return nil
+}
+
+// maxChunkSizeLineLength bounds the chunk size line including extensions
+const maxChunkSizeLineLength = 4096
+
+// Reader decodes a chunked body, returning io.EOF after the last chunk and
+// the trailer section have been read. Trailer fields end up in Trailers.
+type Reader struct {
+	reader    *bufio.Reader
+	remaining int64
+	started   bool
+	done      bool
+	Trailers  headers.Headers
+}
+
+func NewReader(reader *bufio.Reader) *Reader {
+	return &Reader{reader: reader, Trailers: headers.NewHeaders()}
+}
+
+func (cr *Reader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+	if cr.remaining == 0 {
+		if cr.started {
+			if err := cr.readCRLF(); err != nil {
+				return 0, err
+			}
+		}
+		cr.started = true
+		size, err := cr.readChunkSize()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			if err := cr.readTrailers(); err != nil {
+				return 0, err
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.remaining = size
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.reader.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (cr *Reader) readChunkSize() (int64, error) {
+	line, err := cr.readLine()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunk size: %v", err)
+	}
+	// chunk extensions are allowed after ';' and ignored
+	sizeText, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid chunk size '%s'", line)
+	}
+	return size, nil
+}
+
+func (cr *Reader) readCRLF() error {
+	line, err := cr.readLine()
+	if err != nil {
+		return fmt.Errorf("failed to read chunk end: %v", err)
+	}
+	if line != "" {
+		return fmt.Errorf("chunk data is longer than its declared size")
+	}
+	return nil
+}
+
+func (cr *Reader) readTrailers() error {
+	for {
+		line, err := cr.readLine()
+		if err != nil {
+			return fmt.Errorf("failed to read trailers: %v", err)
+		}
+		if line == "" {
+			return nil
+		}
+		if err := cr.Trailers.ParseLine(line); err != nil {
+			return fmt.Errorf("failed to parse trailer: %v", err)
+		}
+	}
+}
+
+func (cr *Reader) readLine() (string, error) {
+	line, err := cr.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxChunkSizeLineLength {
+		return "", fmt.Errorf("line is too long")
+	}
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultDialTimeout         = 5 * time.Second
+	DefaultTimeout             = 30 * time.Second
+	DefaultMaxIdleConnsPerHost = 2
+	DefaultIdleTimeout         = 90 * time.Second
+)
+
+// Client sends requests over plain TCP using the same serialiser and parsers
+// as the server. Connections are kept alive and reused for later requests to
+// the same host.
+type Client struct {
+	DialTimeout time.Duration
+	// Timeout bounds the whole exchange, including reading the body
+	Timeout time.Duration
+	// MaxIdleConnsPerHost caps the connections kept open per host while
+	// waiting to be reused
+	MaxIdleConnsPerHost int
+	// IdleTimeout closes connections that were not reused in time
+	IdleTimeout       time.Duration
+	DisableKeepAlives bool
+	// Dial opens new connections, it defaults to net.DialTimeout with
+	// DialTimeout and can be replaced e.g. to observe the raw traffic
+	Dial func(network string, address string) (net.Conn, error)
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+func NewClient() *Client {
+	return &Client{
+		DialTimeout:         DefaultDialTimeout,
+		Timeout:             DefaultTimeout,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		IdleTimeout:         DefaultIdleTimeout,
+	}
+}
+
+// Response wraps a parsed response so that its Body can be closed, which
+// has to be done once the caller is done with it to release the connection.
+type Response struct {
+	*response.Response
+	Body io.ReadCloser
+}
+
+// NewRequest builds a request for an absolute http:// URL.
+func NewRequest(method string, url string, body []byte) (*request.Request, error) {
+	if !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("invalid url '%s', only http:// is supported", url)
+	}
+	req := request.NewRequest(method, url, body)
+	if req.Authority() == "" {
+		return nil, fmt.Errorf("invalid url '%s', missing host", url)
+	}
+	return req, nil
+}
+
+// Do sends req, whose target has to be in absolute-form, and returns once
+// the response head has been read.
+func (c *Client) Do(req *request.Request) (*Response, error) {
+	outgoing, authority, err := c.outgoingRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	address := withDefaultPort(authority)
+	for attempt := 0; ; attempt++ {
+		pc, reused, err := c.getConn(address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", authority, err)
+		}
+		resp, err := c.exchange(pc, outgoing)
+		if err != nil {
+			pc.conn.Close()
+			// the server may have closed the idle connection before it
+			// received the request, which is then safe to send again unless
+			// the server may have acted on it already
+			if reused && attempt == 0 && isClosedByPeer(err) && isIdempotent(outgoing.RequestLine.Method) {
+				continue
+			}
+			return nil, err
+		}
+		reusable := !resp.Close && !outgoing.Headers.HasToken("Connection", "close")
+		return &Response{
+			Response: resp,
+			Body:     &body{reader: resp.Body, pc: pc, client: c, reusable: reusable, empty: resp.ContentLength == 0},
+		}, nil
+	}
+}
+
+// outgoingRequest rewrites the absolute-form target of req to origin-form,
+// moving the authority to the Host header.
+func (c *Client) outgoingRequest(req *request.Request) (*request.Request, string, error) {
+	authority := req.Authority()
+	if authority == "" || !strings.HasPrefix(req.RequestLine.RequestTarget, "http://") {
+		return nil, "", fmt.Errorf("failed to send request: target '%s' is not an absolute http:// url", req.RequestLine.RequestTarget)
+	}
+
+	outgoing := *req
+	outgoing.RequestLine.RequestTarget = req.OriginForm()
+	outgoing.Headers = req.Headers.Clone()
+	if _, ok := outgoing.Headers.Get("Host"); !ok {
+		outgoing.Headers.Set("Host", authority)
+	}
+	if c.DisableKeepAlives {
+		outgoing.Headers.Set("Connection", "close")
+	}
+	return &outgoing, authority, nil
+}
+
+func (c *Client) exchange(pc *persistConn, req *request.Request) (*response.Response, error) {
+	if c.Timeout > 0 {
+		pc.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if err := req.Write(pc.conn); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	resp, err := response.ResponseFromReader(pc.reader, req.RequestLine.Method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return resp, nil
+}
+
+// isIdempotent reports whether sending a request with method twice has the
+// same effect as sending it once (RFC 9110 section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isClosedByPeer(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (c *Client) Get(url string) (*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) Post(url string, contentType string, body []byte) (*Response, error) {
+	req, err := NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers.Set("Content-Type", contentType)
+	req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	return c.Do(req)
+}
+
+// ReadBody reads the rest of the body and closes the response.
+func ReadBody(resp *Response) ([]byte, error) {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return data, fmt.Errorf("failed to read body: %w", err)
+	}
+	return data, nil
+}
+
+func withDefaultPort(authority string) string {
+	if _, _, err := net.SplitHostPort(authority); err == nil {
+		return authority
+	}
+	return net.JoinHostPort(strings.Trim(authority, "[]"), "80")
+}
@@ -0,0 +1,317 @@
+package client
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Run("Sends a GET in origin-form with a Host header", func(t *testing.T) {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			host, _ := req.Headers.Get("Host")
+			w.WriteText(response.StatusOK, req.RequestLine.RequestTarget+" "+host)
+		})
+		address := s.Addr().String()
+
+		resp, err := NewClient().Get("http://" + address + "/items?page=2")
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusOK, resp.StatusCode())
+		assert.Equal(t, int64(len("/items?page=2 "+address)), resp.ContentLength)
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		assert.Equal(t, "/items?page=2 "+address, string(body))
+	})
+
+	t.Run("Posts a body", func(t *testing.T) {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			contentType, _ := req.Headers.Get("Content-Type")
+			w.WriteText(response.StatusCreated, contentType+" "+string(req.Body))
+		})
+
+		resp, err := NewClient().Post("http://"+s.Addr().String()+"/orders", "application/json", []byte(`{"id":1}`))
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusCreated, resp.StatusCode())
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		assert.Equal(t, `application/json {"id":1}`, string(body))
+	})
+
+	t.Run("Reads chunked bodies", func(t *testing.T) {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(0)
+			h.Delete("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte("first "))
+			w.WriteBody([]byte("second"))
+		})
+
+		resp, err := NewClient().Get("http://" + s.Addr().String() + "/")
+		require.NoError(t, err)
+		assert.Equal(t, int64(-1), resp.ContentLength)
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		assert.Equal(t, "first second", string(body))
+	})
+
+	t.Run("Reads bodies delimited by the server closing", func(t *testing.T) {
+		address := startRawServer(t, "HTTP/1.1 200 OK\r\n\r\nuntil close")
+
+		resp, err := NewClient().Get("http://" + address + "/")
+		require.NoError(t, err)
+		assert.True(t, resp.Close)
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		assert.Equal(t, "until close", string(body))
+	})
+
+	t.Run("Reports a body cut short", func(t *testing.T) {
+		address := startRawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")
+
+		resp, err := NewClient().Get("http://" + address + "/")
+		require.NoError(t, err)
+		_, err = ReadBody(resp)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("Times out on a silent server", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { listener.Close() })
+
+		c := NewClient()
+		c.Timeout = 50 * time.Millisecond
+		_, err = c.Get("http://" + listener.Addr().String() + "/")
+		var netErr net.Error
+		require.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+	})
+
+	t.Run("Rejects unsupported urls", func(t *testing.T) {
+		for _, url := range []string{"https://example.com/", "/relative", "http:///path"} {
+			_, err := NewRequest("GET", url, nil)
+			assert.Error(t, err, url)
+		}
+	})
+}
+
+func TestConnectionReuse(t *testing.T) {
+	remoteAddr := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, req.RemoteAddr)
+	}
+	get := func(t *testing.T, c *Client, url string) string {
+		t.Helper()
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("Sequential requests share one connection", func(t *testing.T) {
+		s := startServer(t, remoteAddr)
+		c := NewClient()
+		url := "http://" + s.Addr().String() + "/"
+
+		first := get(t, c, url)
+		for range 20 {
+			assert.Equal(t, first, get(t, c, url))
+		}
+		assert.Len(t, c.idle[s.Addr().String()], 1)
+	})
+
+	t.Run("Does not reuse a connection whose body was not read", func(t *testing.T) {
+		s := startServer(t, remoteAddr)
+		c := NewClient()
+		url := "http://" + s.Addr().String() + "/"
+
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Empty(t, c.idle[s.Addr().String()])
+		get(t, c, url)
+		assert.Len(t, c.idle[s.Addr().String()], 1)
+	})
+
+	t.Run("Does not reuse a connection the server closes", func(t *testing.T) {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(req.RemoteAddr))
+			h.Set("Connection", "close")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(req.RemoteAddr))
+		})
+		c := NewClient()
+		url := "http://" + s.Addr().String() + "/"
+
+		assert.NotEqual(t, get(t, c, url), get(t, c, url))
+		assert.Empty(t, c.idle[s.Addr().String()])
+	})
+
+	t.Run("Keeps at most MaxIdleConnsPerHost idle connections", func(t *testing.T) {
+		s := startServer(t, remoteAddr)
+		c := NewClient()
+		c.MaxIdleConnsPerHost = 1
+		url := "http://" + s.Addr().String() + "/"
+
+		first, err := c.Get(url)
+		require.NoError(t, err)
+		second, err := c.Get(url)
+		require.NoError(t, err)
+		_, err = ReadBody(first)
+		require.NoError(t, err)
+		_, err = ReadBody(second)
+		require.NoError(t, err)
+		assert.Len(t, c.idle[s.Addr().String()], 1)
+	})
+
+	t.Run("Closes connections idle for longer than IdleTimeout", func(t *testing.T) {
+		s := startServer(t, remoteAddr)
+		c := NewClient()
+		c.IdleTimeout = 20 * time.Millisecond
+		address := s.Addr().String()
+
+		get(t, c, "http://"+address+"/")
+		assert.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return len(c.idle[address]) == 0
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Retries on a connection the server has closed while idle", func(t *testing.T) {
+		s, err := server.Serve(0, remoteAddr, server.WithIdleTimeout(20*time.Millisecond))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		c := NewClient()
+		url := "http://" + s.Addr().String() + "/"
+
+		first := get(t, c, url)
+		time.Sleep(100 * time.Millisecond)
+		assert.NotEqual(t, first, get(t, c, url))
+	})
+
+	t.Run("Does not retry a POST the server may have processed", func(t *testing.T) {
+		var posts atomic.Int32
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method == "POST" {
+				// processed, but the connection drops before the response
+				posts.Add(1)
+				conn, _, err := w.Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			remoteAddr(w, req)
+		})
+		c := NewClient()
+		url := "http://" + s.Addr().String() + "/"
+
+		get(t, c, url)
+		_, err := c.Post(url, "text/plain", []byte("order"))
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), posts.Load())
+	})
+
+	t.Run("Opens a connection per request without keep-alive", func(t *testing.T) {
+		s := startServer(t, remoteAddr)
+		c := NewClient()
+		c.DisableKeepAlives = true
+		url := "http://" + s.Addr().String() + "/"
+
+		assert.NotEqual(t, get(t, c, url), get(t, c, url))
+		assert.Empty(t, c.idle[s.Addr().String()])
+	})
+}
+
+func TestDoUDP(t *testing.T) {
+	s, err := server.ServeUDP(0, func(w *response.Writer, req *request.Request) {
+		host, _ := req.Headers.Get("Host")
+		w.WriteText(response.StatusOK, req.RequestLine.Method+" "+req.RequestLine.RequestTarget+" "+host+" "+string(req.Body))
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	address := "127.0.0.1:" + strconv.Itoa(s.Addr().(*net.UDPAddr).Port)
+
+	t.Run("Exchanges a request and its response", func(t *testing.T) {
+		req, err := NewRequest("POST", "http://"+address+"/echo", []byte("over udp"))
+		require.NoError(t, err)
+		req.Headers.Set("Content-Length", "8")
+
+		resp, err := NewClient().DoUDP(req)
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusOK, resp.StatusCode())
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		assert.Equal(t, "POST /echo "+address+" over udp", string(body))
+	})
+
+	t.Run("Carries messages larger than a datagram", func(t *testing.T) {
+		large := strings.Repeat("x", 5000)
+		req, err := NewRequest("PUT", "http://"+address+"/large", []byte(large))
+		require.NoError(t, err)
+
+		resp, err := NewClient().DoUDP(req)
+		require.NoError(t, err)
+		body, err := ReadBody(resp)
+		require.NoError(t, err)
+		assert.Equal(t, "PUT /large "+address+" "+large, string(body))
+	})
+
+	t.Run("Times out without a server", func(t *testing.T) {
+		silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer silent.Close()
+
+		c := NewClient()
+		c.Timeout = 50 * time.Millisecond
+		req, err := NewRequest("GET", "http://"+silent.LocalAddr().String()+"/", nil)
+		require.NoError(t, err)
+		_, err = c.DoUDP(req)
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+}
+
+func startServer(t *testing.T, handler server.Handler) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// startRawServer answers every connection with raw once the request is read
+func startRawServer(t *testing.T, raw string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request.RequestFromConn(conn)
+				io.WriteString(conn, raw)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
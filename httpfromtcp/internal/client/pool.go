@@ -0,0 +1,129 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// persistConn is a connection that may carry several requests, reader keeps
+// whatever was read past the end of the previous response.
+type persistConn struct {
+	address   string
+	conn      net.Conn
+	reader    *bufio.Reader
+	idleTimer *time.Timer
+}
+
+// getConn takes an idle connection to address or dials a new one.
+func (c *Client) getConn(address string) (*persistConn, bool, error) {
+	c.mu.Lock()
+	idle := c.idle[address]
+	if len(idle) > 0 {
+		// the most recently used connection is the least likely to have
+		// been closed by the server
+		pc := idle[len(idle)-1]
+		c.idle[address] = idle[:len(idle)-1]
+		c.mu.Unlock()
+		if pc.idleTimer != nil {
+			pc.idleTimer.Stop()
+		}
+		return pc, true, nil
+	}
+	c.mu.Unlock()
+
+	var conn net.Conn
+	var err error
+	if c.Dial != nil {
+		conn, err = c.Dial("tcp", address)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, c.DialTimeout)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &persistConn{address: address, conn: conn, reader: bufio.NewReader(conn)}, false, nil
+}
+
+// putConn keeps pc for reuse, or closes it when the pool for its host is
+// full or keep-alive is disabled.
+func (c *Client) putConn(pc *persistConn) {
+	pc.conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.DisableKeepAlives || len(c.idle[pc.address]) >= c.MaxIdleConnsPerHost {
+		pc.conn.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = make(map[string][]*persistConn)
+	}
+	c.idle[pc.address] = append(c.idle[pc.address], pc)
+	if c.IdleTimeout > 0 {
+		pc.idleTimer = time.AfterFunc(c.IdleTimeout, func() { c.removeIdle(pc) })
+	}
+}
+
+// removeIdle closes pc unless it has been taken out of the pool already.
+func (c *Client) removeIdle(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idle := c.idle[pc.address]
+	i := slices.Index(idle, pc)
+	if i == -1 {
+		return
+	}
+	c.idle[pc.address] = slices.Delete(idle, i, i+1)
+	pc.conn.Close()
+}
+
+// CloseIdleConnections closes every connection waiting to be reused.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for address, idle := range c.idle {
+		for _, pc := range idle {
+			if pc.idleTimer != nil {
+				pc.idleTimer.Stop()
+			}
+			pc.conn.Close()
+		}
+		delete(c.idle, address)
+	}
+}
+
+// body hands the connection back to the pool on Close when the response was
+// read to the end and neither side asked for the connection to be closed.
+type body struct {
+	reader   io.Reader
+	pc       *persistConn
+	client   *Client
+	reusable bool
+	// empty bodies are complete without being read
+	empty  bool
+	eof    bool
+	closed bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.reusable && (b.eof || b.empty) {
+		b.client.putConn(b.pc)
+		return nil
+	}
+	return b.pc.conn.Close()
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/reliable"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DoUDP sends req to a server started with server.ServeUDP, as a single
+// message over a fresh reliable.Conn, and reads the response from the reply.
+// The response body is held in memory, Body does not need to be closed.
+func (c *Client) DoUDP(req *request.Request) (*Response, error) {
+	outgoing, authority, err := c.outgoingRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	address, err := net.ResolveUDPAddr("udp4", withDefaultPort(authority))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", authority, err)
+	}
+	packetConn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open udp socket: %w", err)
+	}
+	conn := reliable.NewConn(packetConn, address, reliable.DefaultConfig())
+	defer conn.Close()
+
+	var timedOut atomic.Bool
+	if c.Timeout > 0 {
+		timer := time.AfterFunc(c.Timeout, func() {
+			timedOut.Store(true)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+	wrap := func(action string, err error) error {
+		if timedOut.Load() {
+			err = os.ErrDeadlineExceeded
+		}
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	var buffer bytes.Buffer
+	if err := outgoing.Write(&buffer); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if err := conn.Send(buffer.Bytes()); err != nil {
+		return nil, wrap("send request", err)
+	}
+	msg, err := conn.Receive()
+	if err != nil {
+		return nil, wrap("read response", err)
+	}
+	resp, err := response.ResponseFromReader(bufio.NewReader(bytes.NewReader(msg)), outgoing.RequestLine.Method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return &Response{Response: resp, Body: io.NopCloser(resp.Body)}, nil
+}
@@ -0,0 +1,188 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMinSize is the smallest body with a known length worth compressing,
+// below it the encoding overhead outweighs the savings.
+const DefaultMinSize = 1024
+
+const (
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingIdentity = "identity"
+)
+
+// supportedEncodings is in order of preference when the client rates
+// several codings equally.
+var supportedEncodings = []string{EncodingGzip, EncodingDeflate}
+
+// incompressibleContentTypes are media types whose payload is already
+// compressed, matched by prefix.
+var incompressibleContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+	"application/pdf",
+}
+
+type AcceptedEncoding struct {
+	Coding  string
+	Quality float64
+}
+
+// ParseAcceptEncoding parses an Accept-Encoding field value such as
+// "gzip;q=1.0, deflate;q=0.5, *;q=0". Elements with a malformed quality
+// value are ignored.
+func ParseAcceptEncoding(value string) []AcceptedEncoding {
+	accepted := []AcceptedEncoding{}
+	for _, element := range strings.Split(value, ",") {
+		params := strings.Split(element, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		valid := true
+		for _, param := range params[1:] {
+			name, paramValue, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(paramValue), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			quality = q
+		}
+		if valid {
+			accepted = append(accepted, AcceptedEncoding{Coding: coding, Quality: quality})
+		}
+	}
+	return accepted
+}
+
+// Negotiate picks the supported coding the client prefers, falling back to
+// identity when the header is missing or nothing supported is acceptable.
+func Negotiate(acceptEncoding string) string {
+	accepted := ParseAcceptEncoding(acceptEncoding)
+
+	best := EncodingIdentity
+	bestQuality := 0.0
+	for _, coding := range supportedEncodings {
+		quality := qualityOf(accepted, coding)
+		if quality > bestQuality {
+			best = coding
+			bestQuality = quality
+		}
+	}
+	return best
+}
+
+func qualityOf(accepted []AcceptedEncoding, coding string) float64 {
+	wildcard := 0.0
+	for _, a := range accepted {
+		if a.Coding == coding {
+			return a.Quality
+		}
+		if a.Coding == "*" {
+			wildcard = a.Quality
+		}
+	}
+	return wildcard
+}
+
+// Middleware compresses response bodies with DefaultMinSize as the threshold.
+func Middleware(next server.Handler) server.Handler {
+	return NewMiddleware(DefaultMinSize)(next)
+}
+
+// NewMiddleware compresses eligible response bodies with the coding
+// negotiated from Accept-Encoding. Compressed bodies are streamed using the
+// chunked transfer coding since their final length is not known upfront.
+// Responses to CONNECT are left alone, a tunnel carries no content to encode
+// (RFC 9110 section 9.3.6).
+func NewMiddleware(minSize int) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method == "CONNECT" {
+				next(w, req)
+				return
+			}
+			acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+			coding := Negotiate(acceptEncoding)
+
+			w.AddFilter(func(statusCode response.StatusCode, h headers.Headers, body io.Writer) io.WriteCloser {
+				if !isCompressible(statusCode, h) {
+					return nil
+				}
+				if contentLength, ok := h.Get("Content-Length"); ok {
+					if n, err := strconv.Atoi(contentLength); err == nil && n < minSize {
+						return nil
+					}
+				}
+				h.Add("Vary", "Accept-Encoding")
+				if coding == EncodingIdentity {
+					return nil
+				}
+				h.Delete("Content-Length")
+				h.Set("Content-Encoding", coding)
+				h.Set("Transfer-Encoding", "chunked")
+				return newEncoder(coding, body)
+			})
+
+			next(w, req)
+		}
+	}
+}
+
+func isCompressible(statusCode response.StatusCode, h headers.Headers) bool {
+	if !statusCode.AllowsBody() {
+		return false
+	}
+	if contentEncoding, ok := h.Get("Content-Encoding"); ok && !strings.EqualFold(contentEncoding, EncodingIdentity) {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	contentType = strings.ToLower(contentType)
+	for _, incompressible := range incompressibleContentTypes {
+		if strings.HasPrefix(contentType, incompressible) {
+			return false
+		}
+	}
+	return true
+}
+
+func newEncoder(coding string, body io.Writer) io.WriteCloser {
+	switch coding {
+	case EncodingGzip:
+		return gzip.NewWriter(body)
+	case EncodingDeflate:
+		// the HTTP "deflate" coding is the zlib format (RFC 9110 section 8.4.1.2)
+		return zlib.NewWriter(body)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/chunked"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		expected       string
+	}{
+		{name: "Missing header", acceptEncoding: "", expected: EncodingIdentity},
+		{name: "Single gzip", acceptEncoding: "gzip", expected: EncodingGzip},
+		{name: "Prefers higher quality", acceptEncoding: "gzip;q=0.5, deflate;q=0.8", expected: EncodingDeflate},
+		{name: "Equal quality uses server preference", acceptEncoding: "deflate, gzip", expected: EncodingGzip},
+		{name: "Wildcard", acceptEncoding: "br, *;q=0.1", expected: EncodingGzip},
+		{name: "Explicit refusal beats wildcard", acceptEncoding: "gzip;q=0, *", expected: EncodingDeflate},
+		{name: "Nothing supported", acceptEncoding: "br, zstd", expected: EncodingIdentity},
+		{name: "Malformed quality is ignored", acceptEncoding: "gzip;q=abc, deflate;q=0.2", expected: EncodingDeflate},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Negotiate(tc.acceptEncoding))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	largeBody := strings.Repeat("hello world! ", 200)
+
+	t.Run("Compresses large bodies with gzip", func(t *testing.T) {
+		head, body := serve(t, "gzip, deflate", "text/plain", largeBody)
+		assert.Equal(t, "gzip", head["Content-Encoding"])
+		assert.Equal(t, "Accept-Encoding", head["Vary"])
+		assert.Equal(t, "chunked", head["Transfer-Encoding"])
+		_, hasContentLength := head.Get("Content-Length")
+		assert.False(t, hasContentLength)
+
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, largeBody, string(decoded))
+	})
+
+	t.Run("Compresses with deflate", func(t *testing.T) {
+		head, body := serve(t, "deflate", "application/json", largeBody)
+		assert.Equal(t, "deflate", head["Content-Encoding"])
+
+		reader, err := zlib.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, largeBody, string(decoded))
+	})
+
+	t.Run("Leaves tiny bodies alone", func(t *testing.T) {
+		head, body := serve(t, "gzip", "text/plain", "tiny")
+		_, hasContentEncoding := head.Get("Content-Encoding")
+		assert.False(t, hasContentEncoding)
+		assert.Equal(t, "tiny", string(body))
+	})
+
+	t.Run("Skips already compressed content types", func(t *testing.T) {
+		head, body := serve(t, "gzip", "image/png", largeBody)
+		_, hasContentEncoding := head.Get("Content-Encoding")
+		assert.False(t, hasContentEncoding)
+		assert.Equal(t, largeBody, string(body))
+	})
+
+	t.Run("Flush pushes compressed output to the client", func(t *testing.T) {
+		req, err := request.RequestFromConn(strings.NewReader("GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"))
+		require.NoError(t, err)
+		output := &bytes.Buffer{}
+		w := response.NewWriter(output)
+		Middleware(func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(0)
+			h.Delete("Content-Length")
+			require.NoError(t, w.WriteStatusLine(response.StatusOK))
+			require.NoError(t, w.WriteHeaders(h))
+			_, err := w.WriteBody([]byte("first event"))
+			require.NoError(t, err)
+			require.NoError(t, w.Flush())
+		})(w, req)
+
+		_, rawBody, found := strings.Cut(output.String(), "\r\n\r\n")
+		require.True(t, found)
+		gz, err := gzip.NewReader(chunked.NewReader(bufio.NewReader(strings.NewReader(rawBody))))
+		require.NoError(t, err)
+		flushed := make([]byte, len("first event"))
+		_, err = io.ReadFull(gz, flushed)
+		require.NoError(t, err)
+		assert.Equal(t, "first event", string(flushed))
+	})
+
+	t.Run("Sends identity but still varies when client refuses", func(t *testing.T) {
+		head, body := serve(t, "", "text/plain", largeBody)
+		_, hasContentEncoding := head.Get("Content-Encoding")
+		assert.False(t, hasContentEncoding)
+		assert.Equal(t, "Accept-Encoding", head["Vary"])
+		assert.Equal(t, largeBody, string(body))
+	})
+}
+
+// serve runs the middleware over a handler returning the given body and
+// splits the raw output into headers and a de-chunked body
+func serve(t *testing.T, acceptEncoding string, contentType string, body string) (headers.Headers, []byte) {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	req, err := request.RequestFromConn(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	w := response.NewWriter(output)
+	Middleware(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", contentType)
+		require.NoError(t, w.WriteStatusLine(response.StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody([]byte(body))
+		require.NoError(t, err)
+	})(w, req)
+	require.NoError(t, w.Close())
+
+	head, rawBody, found := strings.Cut(output.String(), "\r\n\r\n")
+	require.True(t, found)
+	lines := strings.Split(head, "\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", lines[0])
+	h := headers.NewHeaders()
+	for _, line := range lines[1:] {
+		require.NoError(t, h.ParseLine(line))
+	}
+	if !h.HasToken("Transfer-Encoding", "chunked") {
+		return h, []byte(rawBody)
+	}
+
+	decoded := []byte{}
+	for {
+		sizeLine, rest, found := strings.Cut(rawBody, "\r\n")
+		require.True(t, found)
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			assert.Equal(t, "\r\n", rest)
+			return h, decoded
+		}
+		decoded = append(decoded, rest[:size]...)
+		rawBody = strings.TrimPrefix(rest[size:], "\r\n")
+	}
+}
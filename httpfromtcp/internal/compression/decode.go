@@ -0,0 +1,114 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecodedSize caps how large a compressed request body may grow
+// once decoded, guarding against decompression bombs.
+const DefaultMaxDecodedSize = 10 << 20
+
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+var ErrDecodedBodyTooLarge = errors.New("decoded body exceeds the size limit")
+
+// DecodeBody reverses the codings listed in a Content-Encoding value, which
+// were applied in the order they are listed, and fails once the decoded
+// body grows past maxDecodedSize.
+func DecodeBody(body []byte, contentEncoding string, maxDecodedSize int) ([]byte, error) {
+	codings := []string{}
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" || coding == EncodingIdentity {
+			continue
+		}
+		if !slices.Contains(supportedEncodings, coding) {
+			return nil, fmt.Errorf("%w '%s'", ErrUnsupportedEncoding, coding)
+		}
+		codings = append(codings, coding)
+	}
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err := decode(body, codings[i], maxDecodedSize)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+func decode(body []byte, coding string, maxDecodedSize int) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch coding {
+	case EncodingGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case EncodingDeflate:
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s body: %v", coding, err)
+	}
+	defer reader.Close()
+
+	// reading one byte past the limit tells a body of exactly the limit
+	// apart from one that is too large
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(maxDecodedSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s body: %v", coding, err)
+	}
+	if len(decoded) > maxDecodedSize {
+		return nil, fmt.Errorf("%w of %d bytes", ErrDecodedBodyTooLarge, maxDecodedSize)
+	}
+	return decoded, nil
+}
+
+// NewRequestDecoder transparently decompresses request bodies sent with a
+// gzip or deflate Content-Encoding before they reach the handler. Unknown
+// codings are answered with 415 and bodies decoding past maxDecodedSize
+// with 413.
+func NewRequestDecoder(maxDecodedSize int) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			contentEncoding, ok := req.Headers.Get("Content-Encoding")
+			if !ok {
+				next(w, req)
+				return
+			}
+
+			body, err := DecodeBody(req.Body, contentEncoding, maxDecodedSize)
+			switch {
+			case errors.Is(err, ErrUnsupportedEncoding):
+				h := response.GetDefaultHeaders(len(err.Error()) + 1)
+				h.Set("Accept-Encoding", strings.Join(supportedEncodings, ", "))
+				w.WriteStatusLine(response.StatusUnsupportedMediaType)
+				w.WriteHeaders(h)
+				w.WriteBody([]byte(err.Error() + "\n"))
+				return
+			case errors.Is(err, ErrDecodedBodyTooLarge):
+				w.WriteText(response.StatusContentTooLarge, err.Error()+"\n")
+				return
+			case err != nil:
+				w.WriteText(response.StatusBadRequest, err.Error()+"\n")
+				return
+			}
+
+			req.Body = body
+			req.Headers.Delete("Content-Encoding")
+			req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+			next(w, req)
+		}
+	}
+}
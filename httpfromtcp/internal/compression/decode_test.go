@@ -0,0 +1,110 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBody(t *testing.T) {
+	payload := `{"message":"hello world!"}`
+
+	t.Run("Decodes gzip", func(t *testing.T) {
+		decoded, err := DecodeBody(gzipped(t, []byte(payload)), "gzip", DefaultMaxDecodedSize)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(decoded))
+	})
+
+	t.Run("Decodes stacked codings in reverse order", func(t *testing.T) {
+		var buffer bytes.Buffer
+		zw := zlib.NewWriter(&buffer)
+		_, err := zw.Write(gzipped(t, []byte(payload)))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		decoded, err := DecodeBody(buffer.Bytes(), "gzip, deflate", DefaultMaxDecodedSize)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(decoded))
+	})
+
+	t.Run("Identity is a no-op", func(t *testing.T) {
+		decoded, err := DecodeBody([]byte(payload), "identity", DefaultMaxDecodedSize)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(decoded))
+	})
+
+	t.Run("Rejects unknown codings", func(t *testing.T) {
+		_, err := DecodeBody([]byte(payload), "br", DefaultMaxDecodedSize)
+		require.ErrorIs(t, err, ErrUnsupportedEncoding)
+	})
+
+	t.Run("Stops decompression bombs", func(t *testing.T) {
+		bomb := gzipped(t, bytes.Repeat([]byte{0}, 1<<20))
+		_, err := DecodeBody(bomb, "gzip", 1024)
+		require.ErrorIs(t, err, ErrDecodedBodyTooLarge)
+	})
+
+	t.Run("Accepts a body of exactly the limit", func(t *testing.T) {
+		decoded, err := DecodeBody(gzipped(t, []byte(payload)), "gzip", len(payload))
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(decoded))
+	})
+
+	t.Run("Reports corrupt data", func(t *testing.T) {
+		_, err := DecodeBody([]byte("not gzip at all"), "gzip", DefaultMaxDecodedSize)
+		require.Error(t, err)
+	})
+}
+
+func TestRequestDecoder(t *testing.T) {
+	payload := `{"message":"hello world!"}`
+	echo := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, string(req.Body))
+	}
+
+	send := func(t *testing.T, contentEncoding string, body []byte) string {
+		raw := "POST /upload HTTP/1.1\r\nContent-Encoding: " + contentEncoding + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+		req, err := request.RequestFromConn(strings.NewReader(raw))
+		require.NoError(t, err)
+		output := &bytes.Buffer{}
+		w := response.NewWriter(output)
+		NewRequestDecoder(64)(echo)(w, req)
+		require.NoError(t, w.Close())
+		return output.String()
+	}
+
+	t.Run("Hands the decoded body to the handler", func(t *testing.T) {
+		raw := send(t, "gzip", gzipped(t, []byte(payload)))
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"+payload))
+	})
+
+	t.Run("Answers unknown codings with 415", func(t *testing.T) {
+		raw := send(t, "br", []byte(payload))
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 415 Unsupported Media Type\r\n"))
+		assert.Contains(t, raw, "Accept-Encoding: gzip, deflate\r\n")
+	})
+
+	t.Run("Answers oversized bodies with 413", func(t *testing.T) {
+		raw := send(t, "gzip", gzipped(t, bytes.Repeat([]byte("a"), 65)))
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 413 Content Too Large\r\n"))
+	})
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	gw := gzip.NewWriter(&buffer)
+	_, err := gw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buffer.Bytes()
+}
@@ -0,0 +1,189 @@
+package cookie
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format Expires has to use (RFC 9110 section 5.6.7).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out so the browser default applies
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+type Cookie struct {
+	Name   string
+	Value  string
+	Path   string
+	Domain string
+	// Expires is left out of Set-Cookie when zero
+	Expires time.Time
+	// MaxAge is left out when zero, a negative value deletes the cookie
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Validate checks the cookie can be serialised into a Set-Cookie line as
+// described by RFC 6265 section 4.1.1.
+func (c *Cookie) Validate() error {
+	if err := validateName(c.Name); err != nil {
+		return err
+	}
+	if err := validateValue(c.Value); err != nil {
+		return fmt.Errorf("invalid value for cookie '%s': %v", c.Name, err)
+	}
+	if err := validateAttributeValue(c.Path); err != nil {
+		return fmt.Errorf("invalid path for cookie '%s': %v", c.Name, err)
+	}
+	if err := validateAttributeValue(c.Domain); err != nil {
+		return fmt.Errorf("invalid domain for cookie '%s': %v", c.Name, err)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie '%s' uses SameSite=None so it has to be Secure", c.Name)
+	}
+	return nil
+}
+
+// String serialises the cookie as a Set-Cookie field value, it does not
+// validate the cookie.
+func (c *Cookie) String() string {
+	var builder strings.Builder
+	builder.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		builder.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		builder.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		builder.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		builder.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		builder.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		builder.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		builder.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		builder.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	return builder.String()
+}
+
+// Set validates the cookie and adds it as a Set-Cookie line to the response
+// headers.
+func Set(h headers.Headers, c *Cookie) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("failed to set cookie: %v", err)
+	}
+	h.Add("Set-Cookie", c.String())
+	return nil
+}
+
+// Parse reads the name=value pairs of a Cookie field value. Malformed pairs
+// are skipped as browsers may send cookies other servers have set.
+func Parse(value string) []*Cookie {
+	cookies := []*Cookie{}
+	// several Cookie lines end up comma separated once combined
+	pairs := strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' })
+	for _, pair := range pairs {
+		name, cookieValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || validateName(name) != nil || validateValue(cookieValue) != nil {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: strings.Trim(cookieValue, `"`)})
+	}
+	return cookies
+}
+
+// FromRequest returns every cookie the client sent.
+func FromRequest(req *request.Request) []*Cookie {
+	value, ok := req.Headers.Get("Cookie")
+	if !ok {
+		return []*Cookie{}
+	}
+	return Parse(value)
+}
+
+// Get returns the first cookie with the name the client sent.
+func Get(req *request.Request, name string) (*Cookie, bool) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("cookie name can not be empty")
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return fmt.Errorf("cookie name '%s' contains invalid character %q", name, name[i])
+		}
+	}
+	return nil
+}
+
+// validateValue accepts cookie-octets optionally wrapped in double quotes.
+func validateValue(value string) error {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		isCookieOctet := c == 0x21 || (c >= 0x23 && c <= 0x2B) || (c >= 0x2D && c <= 0x3A) || (c >= 0x3C && c <= 0x5B) || (c >= 0x5D && c <= 0x7E)
+		if !isCookieOctet {
+			return fmt.Errorf("invalid character %q", c)
+		}
+	}
+	return nil
+}
+
+func validateAttributeValue(value string) error {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == 0x7F || value[i] == ';' {
+			return fmt.Errorf("invalid character %q in '%s'", value[i], value)
+		}
+	}
+	return nil
+}
+
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
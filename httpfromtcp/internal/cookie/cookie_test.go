@@ -0,0 +1,102 @@
+package cookie
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Parses request cookies", func(t *testing.T) {
+		req, err := request.RequestFromConn(strings.NewReader("GET / HTTP/1.1\r\nCookie: session=abc123; theme=\"dark\"; empty=\r\n\r\n"))
+		require.NoError(t, err)
+
+		cookies := FromRequest(req)
+		require.Len(t, cookies, 3)
+		assert.Equal(t, "session", cookies[0].Name)
+		assert.Equal(t, "abc123", cookies[0].Value)
+		assert.Equal(t, "dark", cookies[1].Value)
+		assert.Equal(t, "", cookies[2].Value)
+
+		c, ok := Get(req, "theme")
+		require.True(t, ok)
+		assert.Equal(t, "dark", c.Value)
+		_, ok = Get(req, "missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("Handles repeated Cookie lines", func(t *testing.T) {
+		req, err := request.RequestFromConn(strings.NewReader("GET / HTTP/1.1\r\nCookie: a=1\r\nCookie: b=2\r\n\r\n"))
+		require.NoError(t, err)
+		cookies := FromRequest(req)
+		require.Len(t, cookies, 2)
+		assert.Equal(t, "b", cookies[1].Name)
+	})
+
+	t.Run("Skips malformed pairs", func(t *testing.T) {
+		cookies := Parse("good=1; no-equals; bad name=2; bad=va lue")
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "good", cookies[0].Name)
+	})
+}
+
+func TestSetCookie(t *testing.T) {
+	t.Run("Serialises every attribute", func(t *testing.T) {
+		c := &Cookie{
+			Name:     "session",
+			Value:    "abc123",
+			Path:     "/",
+			Domain:   ".example.com",
+			Expires:  time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC),
+			MaxAge:   3600,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: SameSiteStrict,
+		}
+		assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict", c.String())
+	})
+
+	t.Run("Negative max age deletes the cookie", func(t *testing.T) {
+		c := &Cookie{Name: "session", MaxAge: -1}
+		assert.Equal(t, "session=; Max-Age=0", c.String())
+	})
+
+	t.Run("Writes one line per cookie", func(t *testing.T) {
+		h := response.GetDefaultHeaders(0)
+		require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", Expires: time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)}))
+		require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2"}))
+
+		output := &bytes.Buffer{}
+		w := response.NewWriter(output)
+		require.NoError(t, w.WriteStatusLine(response.StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		assert.Contains(t, output.String(), "Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n")
+	})
+
+	t.Run("Rejects invalid cookies", func(t *testing.T) {
+		testCases := []struct {
+			name   string
+			cookie *Cookie
+		}{
+			{name: "Empty name", cookie: &Cookie{Value: "1"}},
+			{name: "Separator in name", cookie: &Cookie{Name: "a=b", Value: "1"}},
+			{name: "Semicolon in value", cookie: &Cookie{Name: "a", Value: "1;b=2"}},
+			{name: "Header injection in value", cookie: &Cookie{Name: "a", Value: "1\r\nX-Evil: 1"}},
+			{name: "Semicolon in path", cookie: &Cookie{Name: "a", Path: "/;Secure"}},
+			{name: "Insecure SameSite None", cookie: &Cookie{Name: "a", SameSite: SameSiteNone}},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := Set(headers.NewHeaders(), tc.cookie)
+				require.Error(t, err)
+			})
+		}
+	})
+}
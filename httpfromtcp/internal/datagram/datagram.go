@@ -0,0 +1,90 @@
+package datagram
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const Version = 1
+
+// HeaderSize is the size of the fixed header preceding the payload:
+//
+//	version(1) type(1) flags(2) sequence(4) message id(4) payload length(2)
+const HeaderSize = 14
+
+// MaxPayloadSize keeps whole datagrams below the common 1280 byte IPv6
+// minimum MTU so they are not fragmented by IP.
+const MaxPayloadSize = 1200
+
+type Type uint8
+
+const (
+	TypeData Type = 1
+	TypeAck  Type = 2
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeData:
+		return "DATA"
+	case TypeAck:
+		return "ACK"
+	}
+	return fmt.Sprintf("TYPE(%d)", uint8(t))
+}
+
+// Packet is a single datagram. Sequence numbers the datagrams of a sender
+// and MessageID names the message the payload belongs to, an ack echoes
+// both.
+type Packet struct {
+	Type      Type
+	Flags     uint16
+	Sequence  uint32
+	MessageID uint32
+	Payload   []byte
+}
+
+func (p Packet) MarshalBinary() ([]byte, error) {
+	if len(p.Payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds %d", len(p.Payload), MaxPayloadSize)
+	}
+	data := make([]byte, HeaderSize+len(p.Payload))
+	data[0] = Version
+	data[1] = byte(p.Type)
+	binary.BigEndian.PutUint16(data[2:4], p.Flags)
+	binary.BigEndian.PutUint32(data[4:8], p.Sequence)
+	binary.BigEndian.PutUint32(data[8:12], p.MessageID)
+	binary.BigEndian.PutUint16(data[12:14], uint16(len(p.Payload)))
+	copy(data[HeaderSize:], p.Payload)
+	return data, nil
+}
+
+// Unmarshal decodes a datagram, the payload is copied out of data.
+func Unmarshal(data []byte) (Packet, error) {
+	if len(data) < HeaderSize {
+		return Packet{}, fmt.Errorf("datagram of %d bytes is shorter than the %d byte header", len(data), HeaderSize)
+	}
+	if data[0] != Version {
+		return Packet{}, fmt.Errorf("unsupported version %d", data[0])
+	}
+	packet := Packet{
+		Type:      Type(data[1]),
+		Flags:     binary.BigEndian.Uint16(data[2:4]),
+		Sequence:  binary.BigEndian.Uint32(data[4:8]),
+		MessageID: binary.BigEndian.Uint32(data[8:12]),
+	}
+	if packet.Type != TypeData && packet.Type != TypeAck {
+		return Packet{}, fmt.Errorf("unknown packet type %d", data[1])
+	}
+	length := int(binary.BigEndian.Uint16(data[12:14]))
+	if len(data)-HeaderSize != length {
+		return Packet{}, fmt.Errorf("payload length %d does not match the %d bytes received", length, len(data)-HeaderSize)
+	}
+	packet.Payload = append([]byte(nil), data[HeaderSize:]...)
+	return packet, nil
+}
+
+// Ack builds the acknowledgement of p.
+func Ack(p Packet) Packet {
+	return Packet{Type: TypeAck, Sequence: p.Sequence, MessageID: p.MessageID}
+}
@@ -0,0 +1,85 @@
+package datagram
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacket(t *testing.T) {
+	t.Run("Round trips through the wire format", func(t *testing.T) {
+		packet := Packet{Type: TypeData, Flags: 3, Sequence: 7, MessageID: 42, Payload: []byte("hello\n")}
+		data, err := packet.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, HeaderSize+6, len(data))
+		assert.Equal(t, []byte{1, 1, 0, 3, 0, 0, 0, 7, 0, 0, 0, 42, 0, 6}, data[:HeaderSize])
+
+		decoded, err := Unmarshal(data)
+		require.NoError(t, err)
+		assert.Equal(t, packet, decoded)
+	})
+
+	t.Run("Acks echo the sequence and message id", func(t *testing.T) {
+		ack := Ack(Packet{Type: TypeData, Sequence: 9, MessageID: 4, Payload: []byte("x")})
+		assert.Equal(t, Packet{Type: TypeAck, Sequence: 9, MessageID: 4}, ack)
+	})
+
+	t.Run("Rejects oversized payloads", func(t *testing.T) {
+		_, err := Packet{Type: TypeData, Payload: bytes.Repeat([]byte("a"), MaxPayloadSize+1)}.MarshalBinary()
+		assert.Error(t, err)
+	})
+
+	t.Run("Rejects malformed datagrams", func(t *testing.T) {
+		valid, _ := Packet{Type: TypeData, Payload: []byte("abc")}.MarshalBinary()
+		badVersion := append([]byte{}, valid...)
+		badVersion[0] = 2
+		badType := append([]byte{}, valid...)
+		badType[1] = 9
+		for name, data := range map[string][]byte{
+			"short":     valid[:HeaderSize-1],
+			"truncated": valid[:len(valid)-1],
+			"version":   badVersion,
+			"type":      badType,
+		} {
+			_, err := Unmarshal(data)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestTracker(t *testing.T) {
+	t.Run("Classifies arrivals", func(t *testing.T) {
+		tracker := NewTracker()
+		arrivals := []Arrival{}
+		for _, sequence := range []uint32{1, 2, 5, 3, 3, 6, 2} {
+			arrivals = append(arrivals, tracker.Observe(sequence))
+		}
+		assert.Equal(t, []Arrival{ArrivalInOrder, ArrivalInOrder, ArrivalGap, ArrivalReordered, ArrivalDuplicate, ArrivalInOrder, ArrivalDuplicate}, arrivals)
+		assert.Equal(t, Stats{Received: 7, Duplicates: 2, Reordered: 1, Missing: 1}, tracker.Stats())
+	})
+
+	t.Run("Follows sequence numbers across the wrap around", func(t *testing.T) {
+		tracker := NewTracker()
+		tracker.Observe(math.MaxUint32 - 1)
+		assert.Equal(t, ArrivalGap, tracker.Observe(1))
+		assert.Equal(t, ArrivalReordered, tracker.Observe(0))
+		assert.Equal(t, ArrivalDuplicate, tracker.Observe(math.MaxUint32-1))
+		assert.Equal(t, Stats{Received: 4, Duplicates: 1, Reordered: 1, Missing: 1}, tracker.Stats())
+	})
+
+	t.Run("Counts gaps beyond MaxTrackedGap as lost", func(t *testing.T) {
+		tracker := NewTracker()
+		tracker.Observe(0)
+		assert.Equal(t, ArrivalGap, tracker.Observe(1<<31-1))
+		assert.Equal(t, Stats{Received: 2, Missing: MaxTrackedGap, Lost: 1<<31 - 2 - MaxTrackedGap}, tracker.Stats())
+
+		// numbers left far behind are given up on
+		tracker.Observe(1<<31 + 2*MaxTrackedGap)
+		stats := tracker.Stats()
+		assert.Equal(t, MaxTrackedGap, stats.Missing)
+		assert.Equal(t, 1<<31-2+MaxTrackedGap, stats.Lost)
+	})
+}
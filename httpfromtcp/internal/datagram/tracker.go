@@ -0,0 +1,114 @@
+package datagram
+
+// Arrival classifies a sequence number against the ones seen before.
+type Arrival int
+
+const (
+	ArrivalInOrder Arrival = iota
+	// ArrivalGap is in order but skipped at least one sequence number
+	ArrivalGap
+	// ArrivalReordered fills a gap left earlier
+	ArrivalReordered
+	ArrivalDuplicate
+)
+
+func (a Arrival) String() string {
+	switch a {
+	case ArrivalInOrder:
+		return "in order"
+	case ArrivalGap:
+		return "gap"
+	case ArrivalReordered:
+		return "reordered"
+	case ArrivalDuplicate:
+		return "duplicate"
+	}
+	return "unknown"
+}
+
+type Stats struct {
+	Received   int
+	Duplicates int
+	Reordered  int
+	// Missing counts sequence numbers skipped and not received since, they
+	// are either lost or still on their way
+	Missing int
+	// Lost counts skipped sequence numbers given up on, because they fell
+	// more than MaxTrackedGap behind the highest one
+	Lost int
+}
+
+// MaxTrackedGap bounds how far behind the highest sequence number a missing
+// one is still waited for, so a forged or corrupted sequence number can not
+// make the tracker remember billions of them.
+const MaxTrackedGap = 1024
+
+// Tracker follows the sequence numbers received from one sender to expose
+// loss, reordering and duplication.
+type Tracker struct {
+	started bool
+	highest uint32
+	missing map[uint32]struct{}
+	stats   Stats
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{missing: make(map[uint32]struct{})}
+}
+
+// Observe records sequence and reports how it arrived. Sequence numbers are
+// compared with serial number arithmetic (RFC 1982), so they may wrap around.
+func (t *Tracker) Observe(sequence uint32) Arrival {
+	t.stats.Received++
+	if !t.started {
+		t.started = true
+		t.highest = sequence
+		return ArrivalInOrder
+	}
+
+	if ahead := int32(sequence - t.highest); ahead > 0 {
+		arrival := ArrivalInOrder
+		if ahead > 1 {
+			arrival = ArrivalGap
+		}
+		skipped := uint32(ahead - 1)
+		if skipped > MaxTrackedGap {
+			t.stats.Lost += int(skipped - MaxTrackedGap)
+			skipped = MaxTrackedGap
+		}
+		for i := uint32(1); i <= skipped; i++ {
+			t.missing[sequence-i] = struct{}{}
+		}
+		t.highest = sequence
+		t.forgetStale()
+		t.stats.Missing = len(t.missing)
+		return arrival
+	}
+
+	if _, ok := t.missing[sequence]; ok {
+		delete(t.missing, sequence)
+		t.stats.Missing = len(t.missing)
+		t.stats.Reordered++
+		return ArrivalReordered
+	}
+	t.stats.Duplicates++
+	return ArrivalDuplicate
+}
+
+// forgetStale gives up on missing sequence numbers too far behind the
+// highest one.
+func (t *Tracker) forgetStale() {
+	if len(t.missing) <= MaxTrackedGap {
+		return
+	}
+	for sequence := range t.missing {
+		if t.highest-sequence > MaxTrackedGap {
+			delete(t.missing, sequence)
+			t.stats.Lost++
+		}
+	}
+}
+
+func (t *Tracker) Stats() Stats {
+	return t.stats
+}
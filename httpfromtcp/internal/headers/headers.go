@@ -2,45 +2,161 @@ package headers
 
 import (
 	"fmt"
+	"io"
 	"regexp"
+	"slices"
 	"strings"
 )
 
 type Headers map[string]string
 
+// fieldsNotCombinable can not be merged into a comma separated list because
+// their values may contain commas themselves (RFC 9110 section 5.3). Their
+// repeated lines are kept apart with a newline, which can never be part of a
+// field value.
+var fieldsNotCombinable = map[string]bool{
+	"Set-Cookie": true,
+}
+
+const lineSeparator = "\n"
+
+// invalidValueCharacters would end the field line early or truncate it in
+// the peer, and make room for fields or responses nobody set
+const invalidValueCharacters = "\r\n\x00"
+
 func NewHeaders() Headers {
 	return make(map[string]string)
 }
 
 func (h Headers) ParseLine(line string) error {
-	parts := strings.Fields(line)
-	if len(parts) != 2 {
-		return fmt.Errorf("line '%s' can not be parsed as header, incorrect spacing", line)
-	}
-	if !strings.HasSuffix(parts[0], ":") {
+	separatorIndex := strings.Index(line, ":")
+	if separatorIndex == -1 {
 		return fmt.Errorf("line '%s' can not be parsed as header, field name should end with ':'", line)
 	}
-	fieldName := parts[0][:len(parts[0])-1]
+	fieldName := strings.TrimLeft(line[:separatorIndex], " \t")
+	if fieldName != strings.TrimRight(fieldName, " \t") {
+		return fmt.Errorf("line '%s' can not be parsed as header, incorrect spacing", line)
+	}
 	err := validateFieldName(fieldName)
 	if err != nil {
 		return err
 	}
-	fieldName = convertFieldNameToConanocalForm(fieldName)
-	fieldValue := parts[1]
-	value, ok := h[fieldName]
-	if ok {
-		h[fieldName] = fmt.Sprintf("%s, %s", value, fieldValue)
-	} else {
-		h[fieldName] = fieldValue
+	fieldValue := strings.Trim(line[separatorIndex+1:], " \t")
+	if !validFieldValue(fieldValue) {
+		return fmt.Errorf("value of field '%s' contains invalid characters", fieldName)
 	}
+	h.Add(fieldName, fieldValue)
 	return nil
 }
 
 func (h Headers) Get(key string) (val string, ok bool) {
-	value, ok := h[key]
+	value, ok := h[convertFieldNameToConanocalForm(key)]
 	return value, ok
 }
 
+// Set replaces any existing value of the field. A value containing CR, LF
+// or NUL is refused and leaves the headers unchanged.
+func (h Headers) Set(key string, value string) {
+	if !validFieldValue(value) {
+		return
+	}
+	h[convertFieldNameToConanocalForm(key)] = value
+}
+
+// Add appends a value to the field, combining repeated fields into a comma
+// separated list the same way ParseLine does. A value containing CR, LF or
+// NUL is refused and leaves the headers unchanged.
+func (h Headers) Add(key string, value string) {
+	if !validFieldValue(value) {
+		return
+	}
+	fieldName := convertFieldNameToConanocalForm(key)
+	existing, ok := h[fieldName]
+	switch {
+	case !ok:
+		h[fieldName] = value
+	case fieldsNotCombinable[fieldName]:
+		h[fieldName] = existing + lineSeparator + value
+	default:
+		h[fieldName] = fmt.Sprintf("%s, %s", existing, value)
+	}
+}
+
+// Lines returns the value of every field line to send for the key, this is
+// a single line unless the field can not be combined.
+func (h Headers) Lines(key string) []string {
+	value, ok := h.Get(key)
+	if !ok {
+		return nil
+	}
+	if !fieldsNotCombinable[convertFieldNameToConanocalForm(key)] {
+		return []string{value}
+	}
+	return strings.Split(value, lineSeparator)
+}
+
+func (h Headers) Delete(key string) {
+	delete(h, convertFieldNameToConanocalForm(key))
+}
+
+// Values splits a combined field back into its comma separated elements,
+// fields that can not be combined are split into their lines instead.
+func (h Headers) Values(key string) []string {
+	value, ok := h.Get(key)
+	if !ok {
+		return nil
+	}
+	if fieldsNotCombinable[convertFieldNameToConanocalForm(key)] {
+		return h.Lines(key)
+	}
+	values := []string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// HasToken reports whether a comma separated field such as Connection or
+// Transfer-Encoding contains the token, compared case-insensitively.
+func (h Headers) HasToken(key string, token string) bool {
+	for _, value := range h.Values(key) {
+		if strings.EqualFold(value, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write serialises the fields sorted by name, one line per value as
+// returned by Lines, without the empty line ending the field section.
+func (h Headers) Write(w io.Writer) error {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		for _, value := range h.Lines(key) {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", key, value); err != nil {
+				return fmt.Errorf("failed to write header '%s': %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Clone returns a copy that can be modified independently.
+func (h Headers) Clone() Headers {
+	clone := NewHeaders()
+	for key, value := range h {
+		clone[key] = value
+	}
+	return clone
+}
+
 func validateFieldName(fieldName string) error {
 	pattern := `^[a-zA-Z0-9\!\#\$\%\&\'\*\+\-\.\^\_\|\~]+$`
 	matched, err := regexp.Match(pattern, []byte(fieldName))
@@ -53,9 +169,16 @@ func validateFieldName(fieldName string) error {
 	return nil
 }
 
+func validFieldValue(value string) bool {
+	return !strings.ContainsAny(value, invalidValueCharacters)
+}
+
 func convertFieldNameToConanocalForm(fieldName string) string {
 	parts := strings.Split(fieldName, "-")
 	for i, part := range parts {
+		if part == "" {
+			continue
+		}
 		parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:])
 	}
 	return strings.Join(parts, "-")
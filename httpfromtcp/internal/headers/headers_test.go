@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,4 +59,58 @@ func TestHeaderParse(t *testing.T) {
 		require.NoError(t, err3)
 		assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers["Set-Person"])
 	})
+	t.Run("Keeps spaces inside field values", func(t *testing.T) {
+		headers := NewHeaders()
+		err := headers.ParseLine("Accept-Encoding: gzip;q=1.0, deflate;q=0.5")
+		require.NoError(t, err)
+		assert.Equal(t, "gzip;q=1.0, deflate;q=0.5", headers["Accept-Encoding"])
+	})
+
+	t.Run("Get is case insensitive", func(t *testing.T) {
+		headers := NewHeaders()
+		err := headers.ParseLine("content-length: 13")
+		require.NoError(t, err)
+		value, ok := headers.Get("CONTENT-LENGTH")
+		require.True(t, ok)
+		assert.Equal(t, "13", value)
+	})
+
+	t.Run("Splits combined values and matches tokens", func(t *testing.T) {
+		headers := NewHeaders()
+		headers.Add("connection", "keep-alive")
+		headers.Add("Connection", "Upgrade")
+		assert.Equal(t, []string{"keep-alive", "Upgrade"}, headers.Values("Connection"))
+		assert.True(t, headers.HasToken("Connection", "upgrade"))
+		assert.False(t, headers.HasToken("Connection", "close"))
+	})
+
+	t.Run("Keeps repeated Set-Cookie lines apart", func(t *testing.T) {
+		headers := NewHeaders()
+		require.NoError(t, headers.ParseLine("Set-Cookie: id=a3fWa; Expires=Wed, 21 Oct 2015 07:28:00 GMT"))
+		require.NoError(t, headers.ParseLine("Set-Cookie: theme=dark"))
+		assert.Equal(t, []string{"id=a3fWa; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "theme=dark"}, headers.Values("Set-Cookie"))
+		assert.Equal(t, []string{"id=a3fWa; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "theme=dark"}, headers.Lines("Set-Cookie"))
+	})
+
+	t.Run("Rejects CR, LF and NUL in field values", func(t *testing.T) {
+		headers := NewHeaders()
+		for _, line := range []string{"X-Evil: a\rb", "X-Evil: a\nInjected: 1", "X-Evil: a\x00b"} {
+			assert.Error(t, headers.ParseLine(line), line)
+		}
+		headers.Set("Location", "/ok")
+		headers.Set("Location", "/next\r\nSet-Cookie: session=stolen")
+		headers.Add("X-Evil", "a\nb")
+		headers.Add("Set-Cookie", "theme=dark\r\nX-Injected: 1")
+		assert.Equal(t, Headers{"Location": "/ok"}, headers)
+
+		var buf bytes.Buffer
+		require.NoError(t, headers.Write(&buf))
+		assert.Equal(t, "Location: /ok\r\n", buf.String())
+	})
+
+	t.Run("Splits only fields that can not be combined into lines", func(t *testing.T) {
+		headers := Headers{"X-Multi": "a\nb", "Set-Cookie": "a=1\nb=2"}
+		assert.Equal(t, []string{"a\nb"}, headers.Lines("X-Multi"))
+		assert.Equal(t, []string{"a=1", "b=2"}, headers.Lines("Set-Cookie"))
+	})
 }
@@ -0,0 +1,137 @@
+package histogram
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Histogram records non-negative integer values with a bounded relative error
+// in the style of HdrHistogram: every power of two range is split into the
+// same number of linear sub-buckets, so memory grows with the logarithm of
+// the largest value while precision stays at the configured number of
+// significant digits.
+type Histogram struct {
+	subBucketBits int
+	counts        []int64
+	total         int64
+	sum           float64
+	min           int64
+	max           int64
+}
+
+// New creates a histogram keeping significantDigits decimal digits of
+// precision, between 1 and 5.
+func New(significantDigits int) (*Histogram, error) {
+	if significantDigits < 1 || significantDigits > 5 {
+		return nil, fmt.Errorf("significant digits %d should be between 1 and 5", significantDigits)
+	}
+	// a value has to land in a sub-bucket narrower than 10^-digits of it
+	subBucketBits := int(math.Ceil(math.Log2(2 * math.Pow10(significantDigits))))
+	return &Histogram{subBucketBits: subBucketBits, min: math.MaxInt64}, nil
+}
+
+// Record adds value, negative values are recorded as 0.
+func (h *Histogram) Record(value int64) {
+	h.RecordN(value, 1)
+}
+
+// RecordN adds value n times.
+func (h *Histogram) RecordN(value int64, n int64) {
+	if n <= 0 {
+		return
+	}
+	value = max(value, 0)
+	index := h.index(value)
+	if index >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, index-len(h.counts)+1)...)
+	}
+	h.counts[index] += n
+	h.total += n
+	h.sum += float64(value) * float64(n)
+	h.min = min(h.min, value)
+	h.max = max(h.max, value)
+}
+
+// Merge adds every value recorded in other, both need the same precision.
+func (h *Histogram) Merge(other *Histogram) error {
+	if h.subBucketBits != other.subBucketBits {
+		return fmt.Errorf("failed to merge histograms: precision differs")
+	}
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]int64, len(other.counts)-len(h.counts))...)
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.total += other.total
+	h.sum += other.sum
+	h.min = min(h.min, other.min)
+	h.max = max(h.max, other.max)
+	return nil
+}
+
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+func (h *Histogram) Min() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// ValueAtPercentile returns the largest value equivalent, within the
+// precision of the histogram, to the value at percentile (0-100).
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	percentile = min(max(percentile, 0), 100)
+	target := max(int64(math.Ceil(percentile/100*float64(h.total))), 1)
+	var seen int64
+	for index, count := range h.counts {
+		seen += count
+		if seen >= target {
+			return min(h.highestEquivalentValue(index), h.max)
+		}
+	}
+	return h.max
+}
+
+// index maps value to its bucket. Values below 2^subBucketBits get a bucket
+// each, above that every power of two range gets half as many buckets.
+func (h *Histogram) index(value int64) int {
+	subBucketCount := int64(1) << h.subBucketBits
+	if value < subBucketCount {
+		return int(value)
+	}
+	half := subBucketCount / 2
+	shift := bits.Len64(uint64(value)) - h.subBucketBits
+	subBucket := value >> shift
+	return int(subBucketCount + int64(shift-1)*half + subBucket - half)
+}
+
+func (h *Histogram) highestEquivalentValue(index int) int64 {
+	subBucketCount := int64(1) << h.subBucketBits
+	if int64(index) < subBucketCount {
+		return int64(index)
+	}
+	half := subBucketCount / 2
+	offset := int64(index) - subBucketCount
+	shift := offset/half + 1
+	subBucket := offset%half + half
+	return (subBucket+1)<<shift - 1
+}
@@ -0,0 +1,92 @@
+package histogram
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Run("Small values are exact", func(t *testing.T) {
+		h, err := New(3)
+		require.NoError(t, err)
+		for v := int64(1); v <= 100; v++ {
+			h.Record(v)
+		}
+		assert.Equal(t, int64(100), h.Count())
+		assert.Equal(t, int64(1), h.Min())
+		assert.Equal(t, int64(100), h.Max())
+		assert.Equal(t, 50.5, h.Mean())
+		assert.Equal(t, int64(50), h.ValueAtPercentile(50))
+		assert.Equal(t, int64(90), h.ValueAtPercentile(90))
+		assert.Equal(t, int64(99), h.ValueAtPercentile(99))
+		assert.Equal(t, int64(100), h.ValueAtPercentile(100))
+	})
+
+	t.Run("Large values stay within the relative error", func(t *testing.T) {
+		for _, digits := range []int{1, 2, 3} {
+			h, err := New(digits)
+			require.NoError(t, err)
+			rng := rand.New(rand.NewPCG(1, 2))
+			values := make([]int64, 10000)
+			for i := range values {
+				values[i] = rng.Int64N(60_000_000)
+				h.Record(values[i])
+			}
+			slices.Sort(values)
+
+			allowed := math.Pow10(-digits)
+			for _, p := range []float64{50, 90, 99, 99.9} {
+				exact := values[int(math.Ceil(p/100*float64(len(values))))-1]
+				got := h.ValueAtPercentile(p)
+				assert.InEpsilon(t, float64(exact), float64(got), allowed, "digits %d p%v", digits, p)
+			}
+			assert.Equal(t, values[len(values)-1], h.ValueAtPercentile(100))
+		}
+	})
+
+	t.Run("Bucket bounds round trip", func(t *testing.T) {
+		h, err := New(2)
+		require.NoError(t, err)
+		for _, v := range []int64{0, 1, 255, 256, 257, 1000, 1 << 20, 1<<40 + 12345} {
+			index := h.index(v)
+			upper := h.highestEquivalentValue(index)
+			assert.GreaterOrEqual(t, upper, v)
+			assert.Equal(t, index, h.index(upper), v)
+			assert.Equal(t, index+1, h.index(upper+1), v)
+		}
+	})
+
+	t.Run("Merges histograms", func(t *testing.T) {
+		a, _ := New(3)
+		b, _ := New(3)
+		a.RecordN(10, 3)
+		b.Record(5000)
+		require.NoError(t, a.Merge(b))
+		assert.Equal(t, int64(4), a.Count())
+		assert.Equal(t, int64(10), a.Min())
+		assert.Equal(t, int64(5000), a.Max())
+		assert.Equal(t, int64(10), a.ValueAtPercentile(75))
+
+		other, _ := New(1)
+		assert.Error(t, a.Merge(other))
+	})
+
+	t.Run("Empty histograms report zero", func(t *testing.T) {
+		h, _ := New(3)
+		assert.Equal(t, int64(0), h.ValueAtPercentile(99))
+		assert.Equal(t, int64(0), h.Min())
+		assert.Equal(t, 0.0, h.Mean())
+	})
+
+	t.Run("Rejects unsupported precision", func(t *testing.T) {
+		_, err := New(0)
+		assert.Error(t, err)
+		_, err = New(6)
+		assert.Error(t, err)
+	})
+}
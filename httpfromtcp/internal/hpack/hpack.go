@@ -0,0 +1,407 @@
+// Package hpack implements the header compression of HTTP/2 (RFC 7541).
+package hpack
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"slices"
+	"strings"
+)
+
+// DefaultTableSize is the dynamic table size both ends start with, until
+// changed by SETTINGS_HEADER_TABLE_SIZE.
+const DefaultTableSize = 4096
+
+// HeaderField is a single field of a header block, pseudo-header fields
+// such as ":method" included.
+type HeaderField struct {
+	Name  string
+	Value string
+	// Sensitive fields are never added to a dynamic table, neither by the
+	// encoder nor by intermediaries re-encoding them
+	Sensitive bool
+}
+
+// size is the size of the field in the dynamic table (RFC 7541 section 4.1).
+func (f HeaderField) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// sensitiveFields are encoded as never indexed, keeping credentials out of
+// the dynamic table where compression could reveal them.
+var sensitiveFields = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+}
+
+var errTruncated = errors.New("header block is truncated")
+
+// table is the static table followed by a dynamic one, which holds the
+// newest entry first.
+type table struct {
+	dynamic []HeaderField
+	size    int
+	maxSize int
+}
+
+// field returns the entry at index, which starts at 1.
+func (t *table) field(index uint64) (HeaderField, error) {
+	switch {
+	case index == 0:
+		return HeaderField{}, fmt.Errorf("invalid index 0")
+	case index <= uint64(len(staticTable)):
+		return staticTable[index-1], nil
+	case index-uint64(len(staticTable)) <= uint64(len(t.dynamic)):
+		return t.dynamic[index-uint64(len(staticTable))-1], nil
+	}
+	return HeaderField{}, fmt.Errorf("index %d is out of the tables", index)
+}
+
+// search returns the index of an entry matching the field, or failing that
+// of an entry with its name, preferring the static table; 0 when none.
+func (t *table) search(f HeaderField) (index int, nameOnly bool) {
+	nameIndex := 0
+	for i, entry := range staticTable {
+		if entry.Name != f.Name {
+			continue
+		}
+		if entry.Value == f.Value {
+			return i + 1, false
+		}
+		if nameIndex == 0 {
+			nameIndex = i + 1
+		}
+	}
+	for i, entry := range t.dynamic {
+		if entry.Name != f.Name {
+			continue
+		}
+		if entry.Value == f.Value {
+			return len(staticTable) + i + 1, false
+		}
+		if nameIndex == 0 {
+			nameIndex = len(staticTable) + i + 1
+		}
+	}
+	return nameIndex, true
+}
+
+func (t *table) add(f HeaderField) {
+	f.Sensitive = false
+	t.dynamic = append([]HeaderField{f}, t.dynamic...)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *table) setMaxSize(size int) {
+	t.maxSize = size
+	t.evict()
+}
+
+// evict drops the oldest entries until the table fits its maximum size, an
+// entry larger than the whole table empties it.
+func (t *table) evict() {
+	for t.size > t.maxSize && len(t.dynamic) > 0 {
+		oldest := t.dynamic[len(t.dynamic)-1]
+		t.dynamic = t.dynamic[:len(t.dynamic)-1]
+		t.size -= oldest.size()
+	}
+}
+
+// Decoder decodes the header blocks of one connection, its dynamic table
+// carrying over from one block to the next.
+type Decoder struct {
+	table table
+	// allowedMaxSize is the limit advertised to the encoder, which may
+	// choose a smaller table with a size update
+	allowedMaxSize int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{table: table{maxSize: maxTableSize}, allowedMaxSize: maxTableSize}
+}
+
+// SetMaxTableSize changes the table size the encoder is allowed to use,
+// after the new limit was advertised.
+func (d *Decoder) SetMaxTableSize(size int) {
+	d.allowedMaxSize = size
+	if d.table.maxSize > size {
+		d.table.setMaxSize(size)
+	}
+}
+
+// DecodeFields returns the fields of a complete header block in order.
+func (d *Decoder) DecodeFields(block []byte) ([]HeaderField, error) {
+	fields := []HeaderField{}
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// indexed field
+			index, rest, err := decodeInteger(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, err := d.table.field(index)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			block = rest
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			field, rest, err := d.decodeLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(field)
+			fields = append(fields, field)
+			block = rest
+		case b&0xe0 == 0x20:
+			// dynamic table size update, only allowed before the first field
+			if len(fields) > 0 {
+				return nil, fmt.Errorf("dynamic table size update after a header field")
+			}
+			size, rest, err := decodeInteger(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.allowedMaxSize) {
+				return nil, fmt.Errorf("dynamic table size %d exceeds the %d allowed", size, d.allowedMaxSize)
+			}
+			d.table.setMaxSize(int(size))
+			block = rest
+		default:
+			// literal without indexing or never indexed
+			field, rest, err := d.decodeLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			field.Sensitive = b&0xf0 == 0x10
+			fields = append(fields, field)
+			block = rest
+		}
+	}
+	return fields, nil
+}
+
+// Decode returns the fields of a complete header block as Headers, repeated
+// fields combined as by Headers.Add except for cookie crumbs, which are
+// joined with "; " (RFC 9113 section 8.2.3).
+func (d *Decoder) Decode(block []byte) (headers.Headers, error) {
+	fields, err := d.DecodeFields(block)
+	if err != nil {
+		return nil, err
+	}
+	h := headers.NewHeaders()
+	cookies := []string{}
+	for _, field := range fields {
+		if field.Name == "cookie" {
+			cookies = append(cookies, field.Value)
+			continue
+		}
+		h.Add(field.Name, field.Value)
+	}
+	if len(cookies) > 0 {
+		h.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	return h, nil
+}
+
+// decodeLiteral decodes a literal field whose name index has the given
+// prefix size, a zero index is followed by the name as a string.
+func (d *Decoder) decodeLiteral(block []byte, prefix uint8) (HeaderField, []byte, error) {
+	index, rest, err := decodeInteger(block, prefix)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	var field HeaderField
+	if index == 0 {
+		field.Name, rest, err = decodeString(rest)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	} else {
+		indexed, err := d.table.field(index)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		field.Name = indexed.Name
+	}
+	field.Value, rest, err = decodeString(rest)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	return field, rest, nil
+}
+
+// Encoder encodes the header blocks of one connection. Fields are sent
+// indexed when found in the tables and otherwise added to the dynamic
+// table, strings are Huffman encoded unless that makes them longer.
+type Encoder struct {
+	// DisableHuffman sends every string literal as is
+	DisableHuffman bool
+
+	table table
+	// minPendingSize and pendingSize are the smallest and the latest table
+	// size set since the last block, -1 when unchanged
+	minPendingSize int
+	pendingSize    int
+}
+
+func NewEncoder(maxTableSize int) *Encoder {
+	return &Encoder{table: table{maxSize: maxTableSize}, minPendingSize: -1, pendingSize: -1}
+}
+
+// SetMaxTableSize changes the dynamic table size, which is signalled to the
+// decoder at the start of the next block. It must not exceed the limit the
+// decoder advertised.
+func (e *Encoder) SetMaxTableSize(size int) {
+	if e.minPendingSize < 0 || size < e.minPendingSize {
+		e.minPendingSize = size
+	}
+	e.pendingSize = size
+}
+
+// EncodeFields encodes the fields in order into a header block.
+func (e *Encoder) EncodeFields(fields []HeaderField) []byte {
+	block := []byte{}
+	if e.pendingSize >= 0 {
+		// a reduction in between has to be signalled too (RFC 7541
+		// section 4.2)
+		if e.minPendingSize < e.pendingSize {
+			e.table.setMaxSize(e.minPendingSize)
+			block = appendInteger(block, 0x20, 5, uint64(e.minPendingSize))
+		}
+		e.table.setMaxSize(e.pendingSize)
+		block = appendInteger(block, 0x20, 5, uint64(e.pendingSize))
+		e.minPendingSize, e.pendingSize = -1, -1
+	}
+
+	for _, field := range fields {
+		index, nameOnly := e.table.search(field)
+		if index > 0 && !nameOnly && !field.Sensitive {
+			block = appendInteger(block, 0x80, 7, uint64(index))
+			continue
+		}
+		switch {
+		case field.Sensitive:
+			block = appendInteger(block, 0x10, 4, uint64(index))
+		case field.size() > e.table.maxSize:
+			// adding it would only empty the table
+			block = appendInteger(block, 0x00, 4, uint64(index))
+		default:
+			block = appendInteger(block, 0x40, 6, uint64(index))
+			e.table.add(field)
+		}
+		if index == 0 {
+			block = appendString(block, field.Name, !e.DisableHuffman)
+		}
+		block = appendString(block, field.Value, !e.DisableHuffman)
+	}
+	return block
+}
+
+// Encode encodes h into a header block with lowercase names, pseudo-header
+// fields first. Fields that can not be combined are sent once per line, see
+// Headers.Lines, and credentials are marked sensitive.
+func (e *Encoder) Encode(h headers.Headers) []byte {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		aPseudo, bPseudo := strings.HasPrefix(a, ":"), strings.HasPrefix(b, ":")
+		if aPseudo != bPseudo {
+			if aPseudo {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	fields := make([]HeaderField, 0, len(names))
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		for _, value := range h.Lines(name) {
+			fields = append(fields, HeaderField{Name: lower, Value: value, Sensitive: sensitiveFields[lower]})
+		}
+	}
+	return e.EncodeFields(fields)
+}
+
+// decodeInteger decodes an integer with an N-bit prefix (RFC 7541 section
+// 5.1) from the start of data.
+func decodeInteger(data []byte, prefix uint8) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, errTruncated
+	}
+	limit := uint64(1)<<prefix - 1
+	value := uint64(data[0]) & limit
+	if value < limit {
+		return value, data[1:], nil
+	}
+	for i, shift := 1, 0; i < len(data); i, shift = i+1, shift+7 {
+		if shift > 28 {
+			return 0, nil, fmt.Errorf("integer overflows 32 bits")
+		}
+		value += uint64(data[i]&0x7f) << shift
+		if data[i]&0x80 == 0 {
+			return value, data[i+1:], nil
+		}
+	}
+	return 0, nil, errTruncated
+}
+
+// decodeString decodes a string literal (RFC 7541 section 5.2).
+func decodeString(data []byte) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, errTruncated
+	}
+	huffman := data[0]&0x80 != 0
+	length, rest, err := decodeInteger(data, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(rest)) {
+		return "", nil, errTruncated
+	}
+	raw := rest[:length]
+	if !huffman {
+		return string(raw), rest[length:], nil
+	}
+	decoded, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return decoded, rest[length:], nil
+}
+
+// appendInteger encodes value with an N-bit prefix, first holds the bits of
+// the first byte above the prefix.
+func appendInteger(data []byte, first byte, prefix uint8, value uint64) []byte {
+	limit := uint64(1)<<prefix - 1
+	if value < limit {
+		return append(data, first|byte(value))
+	}
+	data = append(data, first|byte(limit))
+	value -= limit
+	for value >= 0x80 {
+		data = append(data, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(data, byte(value))
+}
+
+// appendString encodes s as a string literal, Huffman encoded when allowed
+// and not longer, as the examples of RFC 7541 Appendix C do.
+func appendString(data []byte, s string, huffman bool) []byte {
+	if n := huffmanEncodedLen(s); huffman && n <= len(s) {
+		data = appendInteger(data, 0x80, 7, uint64(n))
+		return huffmanEncode(data, s)
+	}
+	data = appendInteger(data, 0x00, 7, uint64(len(s)))
+	return append(data, s...)
+}
@@ -0,0 +1,320 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"httpfromtcp/internal/headers"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// example is one header block of a sequence from RFC 7541 Appendix C, along
+// with the dynamic table it leaves behind.
+type example struct {
+	block     string
+	fields    []HeaderField
+	table     []HeaderField
+	tableSize int
+}
+
+func TestIntegers(t *testing.T) {
+	t.Run("Encodes and decodes RFC 7541 C.1 examples", func(t *testing.T) {
+		examples := []struct {
+			value   uint64
+			prefix  uint8
+			encoded string
+		}{
+			{10, 5, "0a"},
+			{1337, 5, "1f9a0a"},
+			{42, 8, "2a"},
+		}
+		for _, ex := range examples {
+			encoded := appendInteger(nil, 0, ex.prefix, ex.value)
+			assert.Equal(t, ex.encoded, hex.EncodeToString(encoded))
+			value, rest, err := decodeInteger(encoded, ex.prefix)
+			require.NoError(t, err)
+			assert.Equal(t, ex.value, value)
+			assert.Empty(t, rest)
+		}
+	})
+
+	t.Run("Rejects integers that are truncated or overflow", func(t *testing.T) {
+		_, _, err := decodeInteger([]byte{0x1f, 0x9a}, 5)
+		assert.Error(t, err)
+		_, _, err = decodeInteger([]byte{0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, 5)
+		assert.Error(t, err)
+	})
+}
+
+func TestLiterals(t *testing.T) {
+	// RFC 7541 C.2, each starting from an empty table
+	examples := []struct {
+		name    string
+		encoded string
+		field   HeaderField
+		indexed bool
+	}{
+		{"C.2.1 literal with indexing", "400a637573746f6d2d6b65790d637573746f6d2d686561646572", HeaderField{Name: "custom-key", Value: "custom-header"}, true},
+		{"C.2.2 literal without indexing", "040c2f73616d706c652f70617468", HeaderField{Name: ":path", Value: "/sample/path"}, false},
+		{"C.2.3 literal never indexed", "100870617373776f726406736563726574", HeaderField{Name: "password", Value: "secret", Sensitive: true}, false},
+		{"C.2.4 indexed field", "82", HeaderField{Name: ":method", Value: "GET"}, false},
+	}
+	for _, ex := range examples {
+		t.Run("Decodes "+ex.name, func(t *testing.T) {
+			d := NewDecoder(DefaultTableSize)
+			fields, err := d.DecodeFields(mustDecodeHex(t, ex.encoded))
+			require.NoError(t, err)
+			assert.Equal(t, []HeaderField{ex.field}, fields)
+			if ex.indexed {
+				assert.Equal(t, []HeaderField{ex.field}, d.table.dynamic)
+				assert.Equal(t, 55, d.table.size)
+			} else {
+				assert.Empty(t, d.table.dynamic)
+			}
+		})
+	}
+
+	t.Run("Encodes sensitive fields as never indexed", func(t *testing.T) {
+		e := NewEncoder(DefaultTableSize)
+		e.DisableHuffman = true
+		block := e.EncodeFields([]HeaderField{{Name: "password", Value: "secret", Sensitive: true}})
+		assert.Equal(t, "100870617373776f726406736563726574", hex.EncodeToString(block))
+		assert.Empty(t, e.table.dynamic)
+	})
+}
+
+func TestRequests(t *testing.T) {
+	requests := func(blocks ...string) []example {
+		return []example{
+			{
+				block: blocks[0],
+				fields: []HeaderField{
+					{Name: ":method", Value: "GET"},
+					{Name: ":scheme", Value: "http"},
+					{Name: ":path", Value: "/"},
+					{Name: ":authority", Value: "www.example.com"},
+				},
+				table:     []HeaderField{{Name: ":authority", Value: "www.example.com"}},
+				tableSize: 57,
+			},
+			{
+				block: blocks[1],
+				fields: []HeaderField{
+					{Name: ":method", Value: "GET"},
+					{Name: ":scheme", Value: "http"},
+					{Name: ":path", Value: "/"},
+					{Name: ":authority", Value: "www.example.com"},
+					{Name: "cache-control", Value: "no-cache"},
+				},
+				table: []HeaderField{
+					{Name: "cache-control", Value: "no-cache"},
+					{Name: ":authority", Value: "www.example.com"},
+				},
+				tableSize: 110,
+			},
+			{
+				block: blocks[2],
+				fields: []HeaderField{
+					{Name: ":method", Value: "GET"},
+					{Name: ":scheme", Value: "https"},
+					{Name: ":path", Value: "/index.html"},
+					{Name: ":authority", Value: "www.example.com"},
+					{Name: "custom-key", Value: "custom-value"},
+				},
+				table: []HeaderField{
+					{Name: "custom-key", Value: "custom-value"},
+					{Name: "cache-control", Value: "no-cache"},
+					{Name: ":authority", Value: "www.example.com"},
+				},
+				tableSize: 164,
+			},
+		}
+	}
+
+	t.Run("C.3 requests without Huffman coding", func(t *testing.T) {
+		runExamples(t, DefaultTableSize, false, requests(
+			"828684410f7777772e6578616d706c652e636f6d",
+			"828684be58086e6f2d6361636865",
+			"828785bf400a637573746f6d2d6b65790c637573746f6d2d76616c7565",
+		))
+	})
+
+	t.Run("C.4 requests with Huffman coding", func(t *testing.T) {
+		runExamples(t, DefaultTableSize, true, requests(
+			"828684418cf1e3c2e5f23a6ba0ab90f4ff",
+			"828684be5886a8eb10649cbf",
+			"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+		))
+	})
+}
+
+func TestResponses(t *testing.T) {
+	responses := func(blocks ...string) []example {
+		location := HeaderField{Name: "location", Value: "https://www.example.com"}
+		date21 := HeaderField{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"}
+		date22 := HeaderField{Name: "date", Value: "Mon, 21 Oct 2013 20:13:22 GMT"}
+		cacheControl := HeaderField{Name: "cache-control", Value: "private"}
+		status302 := HeaderField{Name: ":status", Value: "302"}
+		status307 := HeaderField{Name: ":status", Value: "307"}
+		gzip := HeaderField{Name: "content-encoding", Value: "gzip"}
+		setCookie := HeaderField{Name: "set-cookie", Value: "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"}
+		return []example{
+			{
+				block:     blocks[0],
+				fields:    []HeaderField{status302, cacheControl, date21, location},
+				table:     []HeaderField{location, date21, cacheControl, status302},
+				tableSize: 222,
+			},
+			{
+				// ":status: 302" is evicted to make room
+				block:     blocks[1],
+				fields:    []HeaderField{status307, cacheControl, date21, location},
+				table:     []HeaderField{status307, location, date21, cacheControl},
+				tableSize: 222,
+			},
+			{
+				block:     blocks[2],
+				fields:    []HeaderField{{Name: ":status", Value: "200"}, cacheControl, date22, location, gzip, setCookie},
+				table:     []HeaderField{setCookie, gzip, date22},
+				tableSize: 215,
+			},
+		}
+	}
+
+	t.Run("C.5 responses without Huffman coding", func(t *testing.T) {
+		runExamples(t, 256, false, responses(
+			"4803333032580770726976617465611d4d6f6e2c203231204f637420323031332032303a31333a323120474d546e1768747470733a2f2f7777772e6578616d706c652e636f6d",
+			"4803333037c1c0bf",
+			"88c1611d4d6f6e2c203231204f637420323031332032303a31333a323220474d54c05a04677a69707738666f6f3d4153444a4b48514b425a584f5157454f50495541585157454f49553b206d61782d6167653d333630303b2076657273696f6e3d31",
+		))
+	})
+
+	t.Run("C.6 responses with Huffman coding", func(t *testing.T) {
+		runExamples(t, 256, true, responses(
+			"488264025885aec3771a4b6196d07abe941054d444a8200595040b8166e082a62d1bff6e919d29ad171863c78f0b97c8e9ae82ae43d3",
+			"4883640effc1c0bf",
+			"88c16196d07abe941054d444a8200595040b8166e084a62d1bffc05a839bd9ab77ad94e7821dd7f2e6c7b335dfdfcd5b3960d5af27087f3672c1ab270fb5291f9587316065c003ed4ee5b1063d5007",
+		))
+	})
+}
+
+// runExamples decodes the blocks of a sequence with one decoder and encodes
+// their fields with one encoder, which has to reproduce them byte for byte.
+func runExamples(t *testing.T, tableSize int, huffman bool, examples []example) {
+	d := NewDecoder(tableSize)
+	e := NewEncoder(tableSize)
+	e.DisableHuffman = !huffman
+	for _, ex := range examples {
+		fields, err := d.DecodeFields(mustDecodeHex(t, ex.block))
+		require.NoError(t, err)
+		assert.Equal(t, ex.fields, fields)
+		assert.Equal(t, ex.table, d.table.dynamic)
+		assert.Equal(t, ex.tableSize, d.table.size)
+
+		assert.Equal(t, ex.block, hex.EncodeToString(e.EncodeFields(ex.fields)))
+		assert.Equal(t, ex.table, e.table.dynamic)
+	}
+}
+
+func TestHuffman(t *testing.T) {
+	t.Run("Round trips every byte value", func(t *testing.T) {
+		var b strings.Builder
+		for i := 0; i < 256; i++ {
+			b.WriteByte(byte(i))
+		}
+		s := b.String()
+		encoded := huffmanEncode(nil, s)
+		assert.Len(t, encoded, huffmanEncodedLen(s))
+		decoded, err := huffmanDecode(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, s, decoded)
+	})
+
+	t.Run("Rejects invalid padding", func(t *testing.T) {
+		// "a" is 00011, padded with zeros instead of ones
+		_, err := huffmanDecode([]byte{0x18})
+		assert.Error(t, err)
+		// a whole byte of padding
+		_, err = huffmanDecode([]byte{0x1f, 0xff})
+		assert.Error(t, err)
+	})
+}
+
+func TestTableSize(t *testing.T) {
+	t.Run("Signals the smallest and the final size of a change", func(t *testing.T) {
+		e := NewEncoder(DefaultTableSize)
+		d := NewDecoder(DefaultTableSize)
+		e.EncodeFields([]HeaderField{{Name: "custom-key", Value: "custom-value"}})
+		e.SetMaxTableSize(0)
+		e.SetMaxTableSize(100)
+		block := e.EncodeFields(nil)
+		assert.Equal(t, "203f45", hex.EncodeToString(block))
+		assert.Empty(t, e.table.dynamic)
+
+		_, err := d.DecodeFields(block)
+		require.NoError(t, err)
+		assert.Equal(t, 100, d.table.maxSize)
+	})
+
+	t.Run("Rejects updates beyond the advertised size or after a field", func(t *testing.T) {
+		d := NewDecoder(DefaultTableSize)
+		// 4097
+		_, err := d.DecodeFields([]byte{0x3f, 0xe2, 0x1f})
+		assert.Error(t, err)
+		_, err = d.DecodeFields([]byte{0x82, 0x20})
+		assert.Error(t, err)
+	})
+
+	t.Run("Sends fields larger than the table without indexing", func(t *testing.T) {
+		e := NewEncoder(40)
+		e.DisableHuffman = true
+		block := e.EncodeFields([]HeaderField{{Name: "custom-key", Value: "custom-value"}})
+		assert.Equal(t, byte(0x00), block[0])
+		assert.Empty(t, e.table.dynamic)
+	})
+}
+
+func TestHeaders(t *testing.T) {
+	t.Run("Round trips Headers", func(t *testing.T) {
+		h := headers.NewHeaders()
+		h.Set(":status", "200")
+		h.Set("Content-Type", "text/plain")
+		h.Add("Set-Cookie", "a=1; Path=/")
+		h.Add("Set-Cookie", "b=2")
+		h.Set("Authorization", "Bearer secret")
+
+		e := NewEncoder(DefaultTableSize)
+		block := e.Encode(h)
+		fields, err := NewDecoder(DefaultTableSize).DecodeFields(block)
+		require.NoError(t, err)
+		assert.Equal(t, []HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "authorization", Value: "Bearer secret", Sensitive: true},
+			{Name: "content-type", Value: "text/plain"},
+			{Name: "set-cookie", Value: "a=1; Path=/"},
+			{Name: "set-cookie", Value: "b=2"},
+		}, fields)
+
+		decoded, err := NewDecoder(DefaultTableSize).Decode(block)
+		require.NoError(t, err)
+		assert.Equal(t, h, decoded)
+	})
+
+	t.Run("Joins cookie crumbs", func(t *testing.T) {
+		e := NewEncoder(DefaultTableSize)
+		block := e.EncodeFields([]HeaderField{{Name: "cookie", Value: "a=1"}, {Name: "cookie", Value: "b=2"}})
+		h, err := NewDecoder(DefaultTableSize).Decode(block)
+		require.NoError(t, err)
+		cookie, _ := h.Get("Cookie")
+		assert.Equal(t, "a=1; b=2", cookie)
+	})
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,97 @@
+package hpack
+
+import (
+	"fmt"
+	"sync"
+)
+
+type huffmanCode struct {
+	code   uint32
+	length uint8
+}
+
+const huffmanEOS = 256
+
+// huffmanNode is a node of the decoding tree, leaves have no children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   int
+}
+
+var huffmanTree = sync.OnceValue(func() *huffmanNode {
+	root := &huffmanNode{}
+	for symbol, c := range huffmanCodes {
+		node := root
+		for i := int(c.length) - 1; i >= 0; i-- {
+			bit := (c.code >> i) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.symbol = symbol
+	}
+	return root
+})
+
+// huffmanDecode decodes a Huffman encoded string, which has to end with at
+// most 7 bits of padding taken from the EOS code, i.e. all ones.
+func huffmanDecode(data []byte) (string, error) {
+	root := huffmanTree()
+	decoded := make([]byte, 0, len(data)*8/5)
+	node := root
+	// depth and padding track the bits since the last complete symbol
+	depth, padding := 0, true
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> i) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", fmt.Errorf("invalid Huffman code")
+			}
+			depth++
+			padding = padding && bit == 1
+			if node.children[0] != nil || node.children[1] != nil {
+				continue
+			}
+			if node.symbol == huffmanEOS {
+				return "", fmt.Errorf("EOS in Huffman encoded string")
+			}
+			decoded = append(decoded, byte(node.symbol))
+			node, depth, padding = root, 0, true
+		}
+	}
+	if depth > 7 || !padding {
+		return "", fmt.Errorf("invalid Huffman padding")
+	}
+	return string(decoded), nil
+}
+
+// huffmanEncodedLen is the number of bytes huffmanEncode produces for s.
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodes[s[i]].length)
+	}
+	return (bits + 7) / 8
+}
+
+// huffmanEncode appends s Huffman encoded to data, padding the last byte
+// with the most significant bits of EOS.
+func huffmanEncode(data []byte, s string) []byte {
+	var pending uint64
+	var bits uint8
+	for i := 0; i < len(s); i++ {
+		c := huffmanCodes[s[i]]
+		pending = pending<<c.length | uint64(c.code)
+		bits += c.length
+		for bits >= 8 {
+			bits -= 8
+			data = append(data, byte(pending>>bits))
+		}
+	}
+	if bits > 0 {
+		data = append(data, byte(pending<<(8-bits))|byte(0xff>>bits))
+	}
+	return data
+}
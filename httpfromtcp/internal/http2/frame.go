@@ -0,0 +1,219 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Preface opens every HTTP/2 connection from the client (RFC 9113 section
+// 3.4), its first line parses as an HTTP/1.1 style request line.
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	frameHeaderSize = 9
+	// defaultMaxFrameSize is the initial SETTINGS_MAX_FRAME_SIZE, frames
+	// received are held to it as it is never raised
+	defaultMaxFrameSize = 16384
+	maxFrameSizeLimit   = 1<<24 - 1
+	defaultWindowSize   = 65535
+	maxWindowSize       = 1<<31 - 1
+)
+
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+func (t frameType) String() string {
+	names := map[frameType]string{
+		frameData:         "DATA",
+		frameHeaders:      "HEADERS",
+		framePriority:     "PRIORITY",
+		frameRSTStream:    "RST_STREAM",
+		frameSettings:     "SETTINGS",
+		framePushPromise:  "PUSH_PROMISE",
+		framePing:         "PING",
+		frameGoAway:       "GOAWAY",
+		frameWindowUpdate: "WINDOW_UPDATE",
+		frameContinuation: "CONTINUATION",
+	}
+	if name, ok := names[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(t))
+}
+
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+// ErrorCode is carried by RST_STREAM and GOAWAY (RFC 9113 section 7).
+type ErrorCode uint32
+
+const (
+	ErrCodeNo              ErrorCode = 0x0
+	ErrCodeProtocol        ErrorCode = 0x1
+	ErrCodeInternal        ErrorCode = 0x2
+	ErrCodeFlowControl     ErrorCode = 0x3
+	ErrCodeStreamClosed    ErrorCode = 0x5
+	ErrCodeFrameSize       ErrorCode = 0x6
+	ErrCodeRefusedStream   ErrorCode = 0x7
+	ErrCodeCancel          ErrorCode = 0x8
+	ErrCodeCompression     ErrorCode = 0x9
+	ErrCodeEnhanceYourCalm ErrorCode = 0xb
+)
+
+func (c ErrorCode) String() string {
+	names := map[ErrorCode]string{
+		ErrCodeNo:              "NO_ERROR",
+		ErrCodeProtocol:        "PROTOCOL_ERROR",
+		ErrCodeInternal:        "INTERNAL_ERROR",
+		ErrCodeFlowControl:     "FLOW_CONTROL_ERROR",
+		ErrCodeStreamClosed:    "STREAM_CLOSED",
+		ErrCodeFrameSize:       "FRAME_SIZE_ERROR",
+		ErrCodeRefusedStream:   "REFUSED_STREAM",
+		ErrCodeCancel:          "CANCEL",
+		ErrCodeCompression:     "COMPRESSION_ERROR",
+		ErrCodeEnhanceYourCalm: "ENHANCE_YOUR_CALM",
+	}
+	if name, ok := names[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ERROR(%d)", uint32(c))
+}
+
+// connError ends the whole connection with a GOAWAY.
+type connError struct {
+	code   ErrorCode
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("connection error %s: %s", e.code, e.reason)
+}
+
+// streamError resets a single stream with RST_STREAM.
+type streamError struct {
+	streamID uint32
+	code     ErrorCode
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("stream %d error %s: %s", e.streamID, e.code, e.reason)
+}
+
+type frame struct {
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// readFrame reads the next frame, whose payload may not exceed maxSize.
+func readFrame(r io.Reader, maxSize uint32) (frame, error) {
+	head := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return frame{}, fmt.Errorf("failed to read frame header: %w", err)
+	}
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	f := frame{
+		typ:      frameType(head[3]),
+		flags:    head[4],
+		streamID: binary.BigEndian.Uint32(head[5:9]) & 0x7fffffff,
+	}
+	if length > maxSize {
+		return frame{}, connError{ErrCodeFrameSize, fmt.Sprintf("%s frame of %d bytes exceeds %d", f.typ, length, maxSize)}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	return f, nil
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	data := make([]byte, frameHeaderSize, frameHeaderSize+len(f.payload))
+	length := len(f.payload)
+	data[0], data[1], data[2] = byte(length>>16), byte(length>>8), byte(length)
+	data[3] = byte(f.typ)
+	data[4] = f.flags
+	binary.BigEndian.PutUint32(data[5:9], f.streamID&0x7fffffff)
+	data = append(data, f.payload...)
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s frame: %w", f.typ, err)
+	}
+	return nil
+}
+
+// stripPadding removes the padding of a DATA or HEADERS frame flagged
+// PADDED.
+func stripPadding(f frame) ([]byte, error) {
+	if !f.has(flagPadded) {
+		return f.payload, nil
+	}
+	if len(f.payload) == 0 {
+		return nil, connError{ErrCodeProtocol, fmt.Sprintf("padded %s frame without a pad length", f.typ)}
+	}
+	padLength := int(f.payload[0])
+	if padLength >= len(f.payload) {
+		return nil, connError{ErrCodeProtocol, fmt.Sprintf("padding of %s frame exceeds its payload", f.typ)}
+	}
+	return f.payload[1 : len(f.payload)-padLength], nil
+}
+
+type setting struct {
+	id    uint16
+	value uint32
+}
+
+func encodeSettings(settings []setting) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, s.id)
+		payload = binary.BigEndian.AppendUint32(payload, s.value)
+	}
+	return payload
+}
+
+func decodeSettings(payload []byte) ([]setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, connError{ErrCodeFrameSize, fmt.Sprintf("SETTINGS payload of %d bytes is not a multiple of 6", len(payload))}
+	}
+	settings := make([]setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, setting{
+			id:    binary.BigEndian.Uint16(payload[i : i+2]),
+			value: binary.BigEndian.Uint32(payload[i+2 : i+6]),
+		})
+	}
+	return settings, nil
+}
@@ -0,0 +1,794 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/hpack"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler has the signature of server.Handler, which can not be imported
+// here as the server hands connections over to this package.
+type Handler func(w *response.Writer, req *request.Request)
+
+const (
+	// maxConcurrentStreams is advertised to clients, streams opened beyond
+	// it are refused
+	maxConcurrentStreams = 100
+	// headerTableSize is the HPACK dynamic table size clients may use
+	headerTableSize = 4096
+	// maxHeaderBlockSize bounds a header block spread over CONTINUATION frames
+	maxHeaderBlockSize = 64 << 10
+	// maxRequestBodySize bounds the body buffered for the handler, larger
+	// ones are answered with 413
+	maxRequestBodySize = 10 << 20
+)
+
+// connectionSpecificFields are not allowed in HTTP/2 (RFC 9113 section
+// 8.2.2), response headers of that kind are dropped.
+var connectionSpecificFields = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// IsPreface reports whether req is the request line of the connection
+// preface, sent by clients starting HTTP/2 with prior knowledge.
+func IsPreface(req *request.Request) bool {
+	return req.RequestLine.Method == "PRI" && req.RequestLine.RequestTarget == "*" && req.RequestLine.HttpVersion == "2.0"
+}
+
+// IsUpgrade reports whether req asks to switch to h2c (RFC 7540 section 3.2).
+func IsUpgrade(req *request.Request) bool {
+	_, hasSettings := req.Headers.Get("HTTP2-Settings")
+	return hasSettings &&
+		req.Headers.HasToken("Upgrade", "h2c") &&
+		req.Headers.HasToken("Connection", "Upgrade") &&
+		req.Headers.HasToken("Connection", "HTTP2-Settings")
+}
+
+// ServeConn speaks HTTP/2 on conn until the client goes away, dispatching
+// every stream to handler concurrently. reader has to start with the
+// connection preface. upgrade is the HTTP/1.1 request that switched to h2c,
+// after the 101 response has been sent, and is answered on stream 1; it is
+// nil for prior knowledge connections. A connection without open streams for
+// idleTimeout is closed with GOAWAY, it is kept open when idleTimeout is not
+// positive.
+func ServeConn(conn net.Conn, reader io.Reader, handler Handler, upgrade *request.Request, idleTimeout time.Duration) error {
+	sc := &serverConn{
+		conn:              conn,
+		reader:            bufio.NewReader(reader),
+		handler:           handler,
+		idleTimeout:       idleTimeout,
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+		encoder:           hpack.NewEncoder(hpack.DefaultTableSize),
+		decoder:           hpack.NewDecoder(headerTableSize),
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc.serve(upgrade)
+}
+
+type streamState int
+
+const (
+	// stateOpen streams are still receiving the request
+	stateOpen streamState = iota
+	// stateHalfClosedRemote streams have the whole request and are being
+	// answered
+	stateHalfClosedRemote
+)
+
+type stream struct {
+	id         uint32
+	state      streamState
+	req        *request.Request
+	body       []byte
+	sendWindow int64
+	reset      bool
+}
+
+type serverConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	handler     Handler
+	idleTimeout time.Duration
+
+	// writeMu keeps frames whole on the wire and header blocks in the order
+	// they were encoded
+	writeMu sync.Mutex
+	encoder *hpack.Encoder
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	lastStreamID      uint32
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	goingAway         bool
+	// closed is set once no more frames are read
+	closed bool
+	// idleDeadline is when the connection is closed unless a stream opens,
+	// zero while streams are open
+	idleDeadline time.Time
+
+	// only used by the reading goroutine
+	decoder  *hpack.Decoder
+	handlers sync.WaitGroup
+}
+
+func (sc *serverConn) serve(upgrade *request.Request) error {
+	defer sc.shutdown()
+
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		return fmt.Errorf("failed to read connection preface: %w", err)
+	}
+	if string(preface) != Preface {
+		return fmt.Errorf("invalid connection preface %q", preface)
+	}
+
+	err := sc.writeFrame(frame{typ: frameSettings, payload: encodeSettings([]setting{
+		{settingMaxConcurrentStreams, maxConcurrentStreams},
+		{settingMaxHeaderListSize, maxHeaderBlockSize},
+		{settingEnablePush, 0},
+	})})
+	if err != nil {
+		return err
+	}
+
+	if upgrade != nil {
+		if err := sc.startUpgrade(upgrade); err != nil {
+			return sc.goAway(err)
+		}
+	}
+
+	first := true
+	for {
+		sc.mu.Lock()
+		sc.updateIdleDeadline()
+		sc.mu.Unlock()
+		f, err := readFrame(sc.reader, defaultMaxFrameSize)
+		if err != nil {
+			var ce connError
+			if errors.As(err, &ce) {
+				return sc.goAway(ce)
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				sc.goAway(connError{ErrCodeNo, "idle timeout"})
+				return nil
+			}
+			// the client closed the connection
+			return nil
+		}
+		if first && f.typ != frameSettings {
+			return sc.goAway(connError{ErrCodeProtocol, fmt.Sprintf("connection preface ends with %s instead of SETTINGS", f.typ)})
+		}
+		first = false
+
+		err = sc.process(f)
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+		if err != nil {
+			return sc.goAway(err)
+		}
+	}
+}
+
+// startUpgrade applies the settings of the HTTP2-Settings header and opens
+// stream 1 with the request that asked for the upgrade.
+func (sc *serverConn) startUpgrade(req *request.Request) error {
+	encoded, _ := req.Headers.Get("HTTP2-Settings")
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return connError{ErrCodeProtocol, fmt.Sprintf("invalid HTTP2-Settings header: %v", err)}
+	}
+	if err := sc.applySettings(payload); err != nil {
+		return err
+	}
+
+	upgraded := request.NewRequest(req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body)
+	upgraded.RequestLine.HttpVersion = "2.0"
+	upgraded.RemoteAddr = req.RemoteAddr
+	upgraded.LocalAddr = req.LocalAddr
+	for name := range req.Headers {
+		if connectionSpecificFields[strings.ToLower(name)] || strings.EqualFold(name, "HTTP2-Settings") {
+			continue
+		}
+		for _, value := range req.Headers.Lines(name) {
+			upgraded.Headers.Add(name, value)
+		}
+	}
+
+	sc.mu.Lock()
+	s := &stream{id: 1, req: upgraded, sendWindow: sc.peerInitialWindow}
+	sc.streams[1] = s
+	sc.lastStreamID = 1
+	sc.mu.Unlock()
+	sc.dispatch(s, sc.handler)
+	return nil
+}
+
+// process handles a frame, returning a streamError to reset one stream or
+// any other error to end the connection.
+func (sc *serverConn) process(f frame) error {
+	switch f.typ {
+	case frameSettings:
+		return sc.processSettings(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameData:
+		return sc.processData(f)
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	case frameRSTStream:
+		return sc.processRSTStream(f)
+	case framePing:
+		if f.streamID != 0 {
+			return connError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if len(f.payload) != 8 {
+			return connError{ErrCodeFrameSize, "PING payload is not 8 bytes"}
+		}
+		if f.has(flagAck) {
+			return nil
+		}
+		return sc.writeFrame(frame{typ: framePing, flags: flagAck, payload: f.payload})
+	case frameGoAway:
+		if f.streamID != 0 {
+			return connError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		sc.mu.Lock()
+		sc.goingAway = true
+		sc.mu.Unlock()
+		return nil
+	case framePriority:
+		if f.streamID == 0 {
+			return connError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.payload) != 5 {
+			return streamError{f.streamID, ErrCodeFrameSize, "PRIORITY payload is not 5 bytes"}
+		}
+		// priorities are advisory and ignored
+		return nil
+	case framePushPromise:
+		return connError{ErrCodeProtocol, "PUSH_PROMISE from a client"}
+	case frameContinuation:
+		return connError{ErrCodeProtocol, "CONTINUATION without a preceding HEADERS"}
+	}
+	// frames of unknown types are ignored (RFC 9113 section 4.1)
+	return nil
+}
+
+func (sc *serverConn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return connError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError{ErrCodeFrameSize, "SETTINGS acknowledgement with a payload"}
+		}
+		return nil
+	}
+	if err := sc.applySettings(f.payload); err != nil {
+		return err
+	}
+	return sc.writeFrame(frame{typ: frameSettings, flags: flagAck})
+}
+
+func (sc *serverConn) applySettings(payload []byte) error {
+	settings, err := decodeSettings(payload)
+	if err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range settings {
+		switch s.id {
+		case settingEnablePush:
+			if s.value > 1 {
+				return connError{ErrCodeProtocol, fmt.Sprintf("SETTINGS_ENABLE_PUSH of %d", s.value)}
+			}
+		case settingInitialWindowSize:
+			if s.value > maxWindowSize {
+				return connError{ErrCodeFlowControl, fmt.Sprintf("SETTINGS_INITIAL_WINDOW_SIZE of %d", s.value)}
+			}
+			// the change applies to the windows of every open stream
+			delta := int64(s.value) - sc.peerInitialWindow
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return connError{ErrCodeFlowControl, fmt.Sprintf("window of stream %d overflows", st.id)}
+				}
+			}
+			sc.peerInitialWindow = int64(s.value)
+		case settingMaxFrameSize:
+			if s.value < defaultMaxFrameSize || s.value > maxFrameSizeLimit {
+				return connError{ErrCodeProtocol, fmt.Sprintf("SETTINGS_MAX_FRAME_SIZE of %d", s.value)}
+			}
+			sc.peerMaxFrameSize = s.value
+		case settingHeaderTableSize:
+			// the table of the encoder is never grown beyond the default
+			sc.writeMu.Lock()
+			sc.encoder.SetMaxTableSize(int(min(s.value, hpack.DefaultTableSize)))
+			sc.writeMu.Unlock()
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f frame) error {
+	if f.streamID == 0 {
+		return connError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	payload, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	if f.has(flagPriority) {
+		if len(payload) < 5 {
+			return connError{ErrCodeFrameSize, "HEADERS too short for its priority"}
+		}
+		payload = payload[5:]
+	}
+
+	block := append([]byte(nil), payload...)
+	endHeaders := f.has(flagEndHeaders)
+	for !endHeaders {
+		next, err := readFrame(sc.reader, defaultMaxFrameSize)
+		if err != nil {
+			return err
+		}
+		if next.typ != frameContinuation || next.streamID != f.streamID {
+			return connError{ErrCodeProtocol, fmt.Sprintf("%s on stream %d inside a header block", next.typ, next.streamID)}
+		}
+		block = append(block, next.payload...)
+		if len(block) > maxHeaderBlockSize {
+			return connError{ErrCodeEnhanceYourCalm, "header block too large"}
+		}
+		endHeaders = next.has(flagEndHeaders)
+	}
+
+	// the block is decoded even when the stream is refused, to keep the
+	// dynamic table in sync with the client
+	fields, err := sc.decoder.DecodeFields(block)
+	if err != nil {
+		return connError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	s, exists := sc.streams[f.streamID]
+	if exists {
+		sc.mu.Unlock()
+		if s.state != stateOpen {
+			return streamError{f.streamID, ErrCodeStreamClosed, "HEADERS after the end of the stream"}
+		}
+		// trailers, which end the request
+		if !f.has(flagEndStream) {
+			return streamError{f.streamID, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		sc.dispatch(s, sc.handler)
+		return nil
+	}
+	if f.streamID%2 == 0 || f.streamID <= sc.lastStreamID {
+		sc.mu.Unlock()
+		return connError{ErrCodeProtocol, fmt.Sprintf("stream %d can not be opened by the client", f.streamID)}
+	}
+	sc.lastStreamID = f.streamID
+	goingAway := sc.goingAway
+	active := len(sc.streams)
+	sc.mu.Unlock()
+
+	if goingAway {
+		return nil
+	}
+	if active >= maxConcurrentStreams {
+		return streamError{f.streamID, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	req, err := newRequest(fields)
+	if err != nil {
+		return streamError{f.streamID, ErrCodeProtocol, err.Error()}
+	}
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	req.LocalAddr = sc.conn.LocalAddr().String()
+
+	sc.mu.Lock()
+	s = &stream{id: f.streamID, req: req, sendWindow: sc.peerInitialWindow}
+	sc.streams[f.streamID] = s
+	sc.mu.Unlock()
+	if f.has(flagEndStream) {
+		sc.dispatch(s, sc.handler)
+	}
+	return nil
+}
+
+// newRequest builds the request of a stream from its header fields (RFC 9113
+// section 8.3.1).
+func newRequest(fields []hpack.HeaderField) (*request.Request, error) {
+	pseudo := map[string]string{}
+	h := headers.NewHeaders()
+	cookies := []string{}
+	for _, field := range fields {
+		if field.Name != strings.ToLower(field.Name) {
+			return nil, fmt.Errorf("field name '%s' is not lowercase", field.Name)
+		}
+		if strings.HasPrefix(field.Name, ":") {
+			if len(h) > 0 || len(cookies) > 0 {
+				return nil, fmt.Errorf("pseudo-header field '%s' after a regular field", field.Name)
+			}
+			switch field.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header field '%s'", field.Name)
+			}
+			if _, ok := pseudo[field.Name]; ok {
+				return nil, fmt.Errorf("repeated pseudo-header field '%s'", field.Name)
+			}
+			pseudo[field.Name] = field.Value
+			continue
+		}
+		if connectionSpecificFields[field.Name] {
+			return nil, fmt.Errorf("connection-specific field '%s'", field.Name)
+		}
+		if field.Name == "te" && field.Value != "trailers" {
+			return nil, fmt.Errorf("TE field other than 'trailers'")
+		}
+		// cookie crumbs are joined back into a single field (RFC 9113
+		// section 8.2.3)
+		if field.Name == "cookie" {
+			cookies = append(cookies, field.Value)
+			continue
+		}
+		h.Add(field.Name, field.Value)
+	}
+	if len(cookies) > 0 {
+		h.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	method := pseudo[":method"]
+	target := pseudo[":path"]
+	switch {
+	case method == "":
+		return nil, fmt.Errorf("missing :method")
+	case method == "CONNECT":
+		target = pseudo[":authority"]
+		if target == "" || pseudo[":scheme"] != "" || pseudo[":path"] != "" {
+			return nil, fmt.Errorf("CONNECT requires :authority only")
+		}
+	case pseudo[":scheme"] == "" || target == "":
+		return nil, fmt.Errorf("missing :scheme or :path")
+	}
+	if authority := pseudo[":authority"]; authority != "" {
+		h.Set("Host", authority)
+	}
+
+	req := request.NewRequest(method, target, nil)
+	req.RequestLine.HttpVersion = "2.0"
+	req.Headers = h
+	return req, nil
+}
+
+func (sc *serverConn) processData(f frame) error {
+	if f.streamID == 0 {
+		return connError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	// the connection window is given back right away, the whole frame
+	// counts including padding
+	if len(f.payload) > 0 {
+		if err := sc.windowUpdate(0, len(f.payload)); err != nil {
+			return err
+		}
+	}
+
+	sc.mu.Lock()
+	s, exists := sc.streams[f.streamID]
+	idle := f.streamID > sc.lastStreamID
+	sc.mu.Unlock()
+	if !exists {
+		if idle {
+			return connError{ErrCodeProtocol, fmt.Sprintf("DATA on idle stream %d", f.streamID)}
+		}
+		// frames may still be in flight for a stream that was reset
+		return nil
+	}
+	if s.state != stateOpen {
+		return streamError{f.streamID, ErrCodeStreamClosed, "DATA after the end of the stream"}
+	}
+
+	s.body = append(s.body, data...)
+	if len(s.body) > maxRequestBodySize {
+		s.body = nil
+		sc.dispatch(s, func(w *response.Writer, req *request.Request) {
+			w.WriteText(response.StatusContentTooLarge, fmt.Sprintf("request body exceeds %d bytes\n", maxRequestBodySize))
+		})
+		return nil
+	}
+	if f.has(flagEndStream) {
+		sc.dispatch(s, sc.handler)
+		return nil
+	}
+	if len(f.payload) > 0 {
+		return sc.windowUpdate(f.streamID, len(f.payload))
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f frame) error {
+	if len(f.payload) != 4 {
+		return connError{ErrCodeFrameSize, "WINDOW_UPDATE payload is not 4 bytes"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & 0x7fffffff)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID == 0 {
+		if increment == 0 {
+			return connError{ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connError{ErrCodeFlowControl, "connection window overflows"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+	if increment == 0 {
+		return streamError{f.streamID, ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+	}
+	s, exists := sc.streams[f.streamID]
+	if !exists {
+		if f.streamID > sc.lastStreamID {
+			return connError{ErrCodeProtocol, fmt.Sprintf("WINDOW_UPDATE on idle stream %d", f.streamID)}
+		}
+		return nil
+	}
+	s.sendWindow += increment
+	if s.sendWindow > maxWindowSize {
+		return streamError{f.streamID, ErrCodeFlowControl, "stream window overflows"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f frame) error {
+	if f.streamID == 0 {
+		return connError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return connError{ErrCodeFrameSize, "RST_STREAM payload is not 4 bytes"}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.streamID > sc.lastStreamID {
+		return connError{ErrCodeProtocol, fmt.Sprintf("RST_STREAM on idle stream %d", f.streamID)}
+	}
+	if s, exists := sc.streams[f.streamID]; exists {
+		s.reset = true
+		// a stream still receiving its request has no handler to clean up
+		if s.state == stateOpen {
+			delete(sc.streams, s.id)
+		}
+		sc.cond.Broadcast()
+	}
+	return nil
+}
+
+// dispatch runs handler for the complete request of s in its own goroutine.
+func (sc *serverConn) dispatch(s *stream, handler Handler) {
+	s.state = stateHalfClosedRemote
+	if s.body != nil {
+		s.req.Body = s.body
+	}
+	s.body = nil
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		t := &responseTransport{sc: sc, stream: s}
+		w := response.NewTransportWriter(t)
+		handler(w, s.req)
+		err := w.Close()
+		if w.Aborted() || (err != nil && !t.failed) {
+			sc.resetStream(s.id, ErrCodeInternal)
+		}
+		sc.mu.Lock()
+		delete(sc.streams, s.id)
+		// the reading goroutine may be blocked without a deadline
+		sc.updateIdleDeadline()
+		sc.mu.Unlock()
+	}()
+}
+
+// updateIdleDeadline starts counting idleTimeout when the last stream is
+// gone and stops while streams are open. Frames other than new streams,
+// PING among them, do not push the deadline back. Called with mu held.
+func (sc *serverConn) updateIdleDeadline() {
+	if sc.idleTimeout <= 0 {
+		return
+	}
+	switch {
+	case len(sc.streams) > 0 && !sc.idleDeadline.IsZero():
+		sc.idleDeadline = time.Time{}
+	case len(sc.streams) == 0 && sc.idleDeadline.IsZero():
+		sc.idleDeadline = time.Now().Add(sc.idleTimeout)
+	default:
+		return
+	}
+	sc.conn.SetReadDeadline(sc.idleDeadline)
+}
+
+// reserve waits until up to n bytes of DATA may be sent on s, returning how
+// many.
+func (sc *serverConn) reserve(s *stream, n int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if s.reset {
+			return 0, fmt.Errorf("stream %d was reset", s.id)
+		}
+		available := min(s.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize), int64(n))
+		if available > 0 {
+			s.sendWindow -= available
+			sc.sendWindow -= available
+			return int(available), nil
+		}
+		// no WINDOW_UPDATE can arrive once reading stopped
+		if sc.closed {
+			return 0, fmt.Errorf("connection closed")
+		}
+		sc.cond.Wait()
+	}
+}
+
+func (sc *serverConn) writeFrame(f frame) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return writeFrame(sc.conn, f)
+}
+
+func (sc *serverConn) windowUpdate(streamID uint32, increment int) error {
+	return sc.writeFrame(frame{typ: frameWindowUpdate, streamID: streamID, payload: binary.BigEndian.AppendUint32(nil, uint32(increment))})
+}
+
+func (sc *serverConn) resetStream(streamID uint32, code ErrorCode) {
+	sc.mu.Lock()
+	if s, exists := sc.streams[streamID]; exists {
+		s.reset = true
+		if s.state == stateOpen {
+			delete(sc.streams, streamID)
+		}
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	sc.writeFrame(frame{typ: frameRSTStream, streamID: streamID, payload: binary.BigEndian.AppendUint32(nil, uint32(code))})
+}
+
+// goAway tells the client which streams were processed before the
+// connection is closed because of err.
+func (sc *serverConn) goAway(err error) error {
+	code := ErrCodeInternal
+	var ce connError
+	if errors.As(err, &ce) {
+		code = ce.code
+	}
+	sc.mu.Lock()
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, err.Error()...)
+	sc.writeFrame(frame{typ: frameGoAway, payload: payload})
+	return err
+}
+
+// shutdown waits for running handlers before closing the connection, so
+// responses to a client that half-closed it are still sent. Handlers
+// waiting for flow control credit fail as it can not arrive anymore.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.handlers.Wait()
+	sc.conn.Close()
+}
+
+// responseTransport sends the response of a stream as HEADERS and DATA
+// frames.
+type responseTransport struct {
+	sc     *serverConn
+	stream *stream
+	// failed is set once the stream can not carry the response any more
+	failed bool
+}
+
+func (t *responseTransport) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	fields := headers.NewHeaders()
+	fields.Set(":status", strconv.Itoa(int(statusCode)))
+	for name, value := range h {
+		if !connectionSpecificFields[strings.ToLower(name)] {
+			fields[name] = value
+		}
+	}
+
+	sc := t.sc
+	sc.mu.Lock()
+	maxFrameSize := int(sc.peerMaxFrameSize)
+	reset := t.stream.reset
+	sc.mu.Unlock()
+	if reset {
+		t.failed = true
+		return fmt.Errorf("stream %d was reset", t.stream.id)
+	}
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	block := sc.encoder.Encode(fields)
+	typ := frameHeaders
+	for {
+		chunk := block[:min(len(block), maxFrameSize)]
+		block = block[len(chunk):]
+		var flags uint8
+		if len(block) == 0 {
+			flags = flagEndHeaders
+		}
+		if err := writeFrame(sc.conn, frame{typ: typ, flags: flags, streamID: t.stream.id, payload: chunk}); err != nil {
+			t.failed = true
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ = frameContinuation
+	}
+}
+
+func (t *responseTransport) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := t.sc.reserve(t.stream, len(p)-written)
+		if err != nil {
+			t.failed = true
+			return written, err
+		}
+		err = t.sc.writeFrame(frame{typ: frameData, streamID: t.stream.id, payload: p[written : written+n]})
+		if err != nil {
+			t.failed = true
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close ends the stream with an empty DATA frame.
+func (t *responseTransport) Close() error {
+	if t.failed {
+		return nil
+	}
+	return t.sc.writeFrame(frame{typ: frameData, flags: flagEndStream, streamID: t.stream.id})
+}
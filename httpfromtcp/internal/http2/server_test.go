@@ -0,0 +1,368 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"httpfromtcp/internal/hpack"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeConn(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body))
+	}
+
+	t.Run("Answers a request with prior knowledge", func(t *testing.T) {
+		c := startConn(t, func(w *response.Writer, req *request.Request) {
+			host, _ := req.Headers.Get("Host")
+			cookie, _ := req.Headers.Get("Cookie")
+			w.WriteText(response.StatusOK, fmt.Sprintf("%s %s %s %s %s", req.RequestLine.Method, req.RequestLine.HttpVersion, host, req.Path(), cookie))
+		}, nil)
+		c.writeHeaders(1, true, hpack.HeaderField{Name: "cookie", Value: "a=1"}, hpack.HeaderField{Name: "cookie", Value: "b=2"})
+		resp := c.readResponse(1)
+		assert.Equal(t, "200", resp.fields[":status"])
+		assert.Equal(t, "text/plain", resp.fields["content-type"])
+		assert.Equal(t, "GET 2.0 example.com / a=1; b=2", resp.body)
+	})
+
+	t.Run("Reads the body from DATA frames", func(t *testing.T) {
+		c := startConn(t, echo, nil)
+		c.writeHeaders(1, false, hpack.HeaderField{Name: ":method", Value: "POST"})
+		c.writeFrame(frame{typ: frameData, streamID: 1, payload: []byte("hello ")})
+		// padding is stripped
+		c.writeFrame(frame{typ: frameData, flags: flagPadded | flagEndStream, streamID: 1, payload: []byte("\x03world\x00\x00\x00")})
+		resp := c.readResponse(1)
+		assert.Equal(t, "POST / hello world", resp.body)
+	})
+
+	t.Run("Answers streams concurrently", func(t *testing.T) {
+		release := make(chan struct{})
+		c := startConn(t, func(w *response.Writer, req *request.Request) {
+			if req.Path() == "/slow" {
+				<-release
+			}
+			w.WriteText(response.StatusOK, req.Path())
+		}, nil)
+		c.writeHeaders(1, true, hpack.HeaderField{Name: ":path", Value: "/slow"})
+		c.writeHeaders(3, true, hpack.HeaderField{Name: ":path", Value: "/fast"})
+		assert.Equal(t, "/fast", c.readResponse(3).body)
+		close(release)
+		assert.Equal(t, "/slow", c.readResponse(1).body)
+	})
+
+	t.Run("Respects the flow control window of the client", func(t *testing.T) {
+		c := startConn(t, func(w *response.Writer, req *request.Request) {
+			w.WriteText(response.StatusOK, "0123456789abcdefghij")
+		}, nil)
+		c.writeFrame(frame{typ: frameSettings, payload: encodeSettings([]setting{{settingInitialWindowSize, 8}})})
+		c.writeHeaders(1, true)
+
+		f := c.readFrame(frameHeaders)
+		assert.Equal(t, uint32(1), f.streamID)
+		f = c.readFrame(frameData)
+		assert.Equal(t, "01234567", string(f.payload))
+		c.expectSilence()
+
+		c.writeFrame(frame{typ: frameWindowUpdate, streamID: 1, payload: binary.BigEndian.AppendUint32(nil, 100)})
+		resp := c.readResponse(1)
+		assert.Equal(t, "89abcdefghij", resp.body)
+	})
+
+	t.Run("Fails the writes of a stream reset by the client", func(t *testing.T) {
+		writeErr := make(chan error, 1)
+		c := startConn(t, func(w *response.Writer, req *request.Request) {
+			if req.Path() != "/" {
+				return
+			}
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(5))
+			_, err := w.WriteBody([]byte("never"))
+			writeErr <- err
+		}, nil)
+		c.writeFrame(frame{typ: frameSettings, payload: encodeSettings([]setting{{settingInitialWindowSize, 0}})})
+		c.writeHeaders(1, true)
+		c.readFrame(frameHeaders)
+		c.writeFrame(frame{typ: frameRSTStream, streamID: 1, payload: binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel))})
+
+		select {
+		case err := <-writeErr:
+			assert.Error(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("write did not fail after RST_STREAM")
+		}
+		// the connection keeps serving other streams
+		c.writeHeaders(3, true, hpack.HeaderField{Name: ":path", Value: "/empty"})
+		assert.Equal(t, "200", c.readResponse(3).fields[":status"])
+	})
+
+	t.Run("Splits large bodies into frames of the maximum size", func(t *testing.T) {
+		body := make([]byte, defaultMaxFrameSize+100)
+		c := startConn(t, func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}, nil)
+		c.writeHeaders(1, true)
+		c.readFrame(frameHeaders)
+		assert.Len(t, c.readFrame(frameData).payload, defaultMaxFrameSize)
+		assert.Len(t, c.readFrame(frameData).payload, 100)
+	})
+
+	t.Run("Applies the header table size of the client", func(t *testing.T) {
+		c := startConn(t, echo, nil)
+		c.writeFrame(frame{typ: frameSettings, payload: encodeSettings([]setting{{settingHeaderTableSize, 0}})})
+		c.writeHeaders(1, true)
+		f := c.readFrame(frameHeaders)
+		// the block starts with a dynamic table size update to 0
+		assert.Equal(t, byte(0x20), f.payload[0])
+		assert.Contains(t, f.fields, hpack.HeaderField{Name: ":status", Value: "200"})
+	})
+
+	t.Run("Acknowledges PING", func(t *testing.T) {
+		c := startConn(t, echo, nil)
+		c.writeFrame(frame{typ: framePing, payload: []byte("12345678")})
+		f := c.readFrame(framePing)
+		assert.Equal(t, uint8(flagAck), f.flags)
+		assert.Equal(t, "12345678", string(f.payload))
+	})
+
+	t.Run("Resets streams with malformed requests", func(t *testing.T) {
+		c := startConn(t, echo, nil)
+		c.writeHeaders(1, true, hpack.HeaderField{Name: "connection", Value: "keep-alive"})
+		f := c.readFrame(frameRSTStream)
+		assert.Equal(t, uint32(1), f.streamID)
+		assert.Equal(t, ErrCodeProtocol, ErrorCode(binary.BigEndian.Uint32(f.payload)))
+	})
+
+	t.Run("Sends GOAWAY on protocol errors", func(t *testing.T) {
+		c := startConn(t, echo, nil)
+		c.writeHeaders(1, true)
+		c.readResponse(1)
+		// client streams have odd identifiers
+		c.writeHeaders(2, true)
+		f := c.readFrame(frameGoAway)
+		assert.Equal(t, uint32(1), binary.BigEndian.Uint32(f.payload[0:4]))
+		assert.Equal(t, ErrCodeProtocol, ErrorCode(binary.BigEndian.Uint32(f.payload[4:8])))
+		_, err := readFrame(c.conn, maxFrameSizeLimit)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Sends GOAWAY when the preface is not followed by SETTINGS", func(t *testing.T) {
+		client, server := net.Pipe()
+		go ServeConn(server, server, echo, nil, 0)
+		go func() {
+			io.WriteString(client, Preface)
+			writeFrame(client, frame{typ: framePing, payload: make([]byte, 8)})
+		}()
+		readFrame(client, maxFrameSizeLimit)
+		f, err := readFrame(client, maxFrameSizeLimit)
+		require.NoError(t, err)
+		assert.Equal(t, frameGoAway, f.typ)
+	})
+
+	t.Run("Sends GOAWAY once idle without open streams", func(t *testing.T) {
+		release := make(chan struct{})
+		c := startIdleConn(t, func(w *response.Writer, req *request.Request) {
+			<-release
+			w.WriteText(response.StatusOK, "done")
+		}, nil, 50*time.Millisecond)
+		c.writeHeaders(1, true)
+		// an open stream keeps the connection, however long it takes
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		assert.Equal(t, "done", c.readResponse(1).body)
+
+		// PING does not count as activity
+		c.writeFrame(frame{typ: framePing, payload: make([]byte, 8)})
+		start := time.Now()
+		f := c.readFrame(frameGoAway)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, uint32(1), binary.BigEndian.Uint32(f.payload[0:4]))
+		assert.Equal(t, ErrCodeNo, ErrorCode(binary.BigEndian.Uint32(f.payload[4:8])))
+		_, err := readFrame(c.conn, maxFrameSizeLimit)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Answers the upgrade request on stream 1", func(t *testing.T) {
+		upgrade := request.NewRequest("POST", "/upgraded", []byte("body"))
+		upgrade.Headers.Set("HTTP2-Settings", "AAMAAABk")
+		c := startConn(t, echo, upgrade)
+		assert.Equal(t, "POST /upgraded body", c.readResponse(1).body)
+		// the next stream of the client is 3
+		c.writeHeaders(3, true)
+		assert.Equal(t, "GET / ", c.readResponse(3).body)
+	})
+}
+
+// testConn is the client side of a connection served by ServeConn.
+type testConn struct {
+	t       *testing.T
+	conn    net.Conn
+	encoder *hpack.Encoder
+	decoder *hpack.Decoder
+	// pending holds frames read while waiting for others
+	pending []testFrame
+}
+
+// testFrame is a received frame, HEADERS are decoded on arrival to keep
+// the dynamic table in sync.
+type testFrame struct {
+	frame
+	fields []hpack.HeaderField
+}
+
+type testResponse struct {
+	fields map[string]string
+	body   string
+}
+
+func startConn(t *testing.T, handler Handler, upgrade *request.Request) *testConn {
+	return startIdleConn(t, handler, upgrade, 0)
+}
+
+func startIdleConn(t *testing.T, handler Handler, upgrade *request.Request, idleTimeout time.Duration) *testConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		ServeConn(conn, conn, handler, upgrade, idleTimeout)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	c := &testConn{t: t, conn: conn, encoder: hpack.NewEncoder(hpack.DefaultTableSize), decoder: hpack.NewDecoder(hpack.DefaultTableSize)}
+
+	_, err = io.WriteString(conn, Preface)
+	require.NoError(t, err)
+	c.writeFrame(frame{typ: frameSettings})
+	settings := c.readFrame(frameSettings)
+	assert.Zero(t, settings.flags)
+	c.writeFrame(frame{typ: frameSettings, flags: flagAck})
+	return c
+}
+
+func (c *testConn) writeFrame(f frame) {
+	require.NoError(c.t, writeFrame(c.conn, f))
+}
+
+// writeHeaders opens a GET request for / on streamID, fields replace the
+// default ones of the same name.
+func (c *testConn) writeHeaders(streamID uint32, endStream bool, fields ...hpack.HeaderField) {
+	defaults := []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "example.com"},
+	}
+	for i, field := range defaults {
+		for _, override := range fields {
+			if override.Name == field.Name {
+				defaults[i] = override
+			}
+		}
+	}
+	for _, field := range fields {
+		if field.Name[0] != ':' {
+			defaults = append(defaults, field)
+		}
+	}
+	var flags uint8 = flagEndHeaders
+	if endStream {
+		flags |= flagEndStream
+	}
+	c.writeFrame(frame{typ: frameHeaders, flags: flags, streamID: streamID, payload: c.encoder.EncodeFields(defaults)})
+}
+
+// readFrame returns the next frame of type typ, keeping frames of other
+// types for later apart from acknowledgements and window updates.
+func (c *testConn) readFrame(typ frameType) testFrame {
+	for i, f := range c.pending {
+		if f.typ == typ {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return f
+		}
+	}
+	for {
+		f := c.next()
+		if f.typ == typ {
+			return f
+		}
+		c.pending = append(c.pending, f)
+	}
+}
+
+// readResponse collects the response of streamID up to END_STREAM.
+func (c *testConn) readResponse(streamID uint32) testResponse {
+	resp := testResponse{fields: map[string]string{}}
+	for {
+		f, found := testFrame{}, false
+		for i, pending := range c.pending {
+			if pending.streamID == streamID {
+				f, found = pending, true
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
+		}
+		if !found {
+			f = c.next()
+			if f.streamID != streamID {
+				c.pending = append(c.pending, f)
+				continue
+			}
+		}
+		switch f.typ {
+		case frameHeaders:
+			for _, field := range f.fields {
+				resp.fields[field.Name] = field.Value
+			}
+		case frameData:
+			resp.body += string(f.payload)
+		case frameRSTStream:
+			c.t.Fatalf("stream %d was reset", streamID)
+		}
+		if f.has(flagEndStream) {
+			return resp
+		}
+	}
+}
+
+func (c *testConn) next() testFrame {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		f, err := readFrame(c.conn, maxFrameSizeLimit)
+		require.NoError(c.t, err)
+		if f.typ == frameWindowUpdate || (f.typ == frameSettings && f.has(flagAck)) {
+			continue
+		}
+		received := testFrame{frame: f}
+		if f.typ == frameHeaders {
+			received.fields, err = c.decoder.DecodeFields(f.payload)
+			require.NoError(c.t, err)
+		}
+		return received
+	}
+}
+
+// expectSilence checks that the server sends nothing but window updates
+// for a moment.
+func (c *testConn) expectSilence() {
+	c.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	f, err := readFrame(c.conn, maxFrameSizeLimit)
+	for err == nil && f.typ == frameWindowUpdate {
+		f, err = readFrame(c.conn, maxFrameSizeLimit)
+	}
+	require.Error(c.t, err, "unexpected %s frame", f.typ)
+}
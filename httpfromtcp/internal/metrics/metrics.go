@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultDurationBuckets suit request latencies measured in seconds.
+var DefaultDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets suit message sizes measured in bytes.
+var DefaultSizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry holds metric families and renders them in the Prometheus text
+// exposition format, in the order they were registered.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == f.name {
+			panic(fmt.Sprintf("metric '%s' is already registered", f.name))
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	f := newFamily(name, help, typeCounter, labelNames, nil)
+	r.register(f)
+	return &Counter{family: f}
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	f := newFamily(name, help, typeGauge, labelNames, nil)
+	r.register(f)
+	return &Gauge{family: f}
+}
+
+// NewHistogram creates a histogram with the given upper bounds, a +Inf
+// bucket is always added.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	f := newFamily(name, help, typeHistogram, labelNames, buckets)
+	r.register(f)
+	return &Histogram{family: f}
+}
+
+// WriteText writes every metric in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	for _, f := range families {
+		if err := f.writeText(w); err != nil {
+			return fmt.Errorf("failed to write metric '%s': %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// Handle serves the registry to a scraper.
+func (r *Registry) Handle(w *response.Writer, req *request.Request) {
+	var body bytes.Buffer
+	if err := r.WriteText(&body); err != nil {
+		w.WriteText(response.StatusInternalServerError, err.Error()+"\n")
+		return
+	}
+	h := response.GetDefaultHeaders(body.Len())
+	h.Set("Content-Type", ContentType)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.WriteBody(body.Bytes())
+}
+
+type Counter struct {
+	family *family
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, counters can never go down so negative values
+// are ignored.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.family.update(labelValues, func(s *series) { s.value += value })
+}
+
+type Gauge struct {
+	family *family
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.update(labelValues, func(s *series) { s.value = value })
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.family.update(labelValues, func(s *series) { s.value += value })
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+type Histogram struct {
+	family *family
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.family.update(labelValues, func(s *series) {
+		for i, upperBound := range h.family.buckets {
+			if value <= upperBound {
+				s.bucketCounts[i]++
+			}
+		}
+		s.sum += value
+		s.count++
+	})
+}
+
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues  []string
+	value        float64
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+func newFamily(name string, help string, metricType string, labelNames []string, buckets []float64) *family {
+	return &family{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+}
+
+func (f *family) update(labelValues []string, apply func(s *series)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric '%s' expects labels %v, received %d values", f.name, f.labelNames, len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labelValues:  slices.Clone(labelValues),
+			bucketCounts: make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+	apply(s)
+}
+
+func (f *family) writeText(w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.metricType)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.metricType != typeHistogram {
+			fmt.Fprintf(&b, "%s%s %s\n", f.name, f.labels(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		for i, upperBound := range f.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, f.labels(s.labelValues, formatFloat(upperBound)), s.bucketCounts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, f.labels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, f.labels(s.labelValues, ""), formatFloat(s.sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", f.name, f.labels(s.labelValues, ""), s.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// labels renders `{name="value",...}`, le is added for histogram buckets.
+func (f *family) labels(labelValues []string, le string) string {
+	pairs := []string{}
+	for i, name := range f.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(labelValues[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
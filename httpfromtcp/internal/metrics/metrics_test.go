@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	t.Run("Counters and gauges", func(t *testing.T) {
+		registry := NewRegistry()
+		requests := registry.NewCounter("requests_total", "Requests served.", "method", "status")
+		active := registry.NewGauge("active_connections", "Open connections.")
+
+		requests.Inc("GET", "200")
+		requests.Inc("GET", "200")
+		requests.Add(3, "POST", "500")
+		requests.Add(-1, "POST", "500")
+		active.Inc()
+		active.Inc()
+		active.Dec()
+
+		var output bytes.Buffer
+		require.NoError(t, registry.WriteText(&output))
+		assert.Equal(t, ""+
+			"# HELP requests_total Requests served.\n"+
+			"# TYPE requests_total counter\n"+
+			"requests_total{method=\"GET\",status=\"200\"} 2\n"+
+			"requests_total{method=\"POST\",status=\"500\"} 3\n"+
+			"# HELP active_connections Open connections.\n"+
+			"# TYPE active_connections gauge\n"+
+			"active_connections 1\n", output.String())
+	})
+
+	t.Run("Histograms have cumulative buckets", func(t *testing.T) {
+		registry := NewRegistry()
+		latency := registry.NewHistogram("latency_seconds", "Handler latency.", []float64{0.5, 0.1})
+
+		latency.Observe(0.05)
+		latency.Observe(0.2)
+		latency.Observe(3)
+
+		var output bytes.Buffer
+		require.NoError(t, registry.WriteText(&output))
+		assert.Equal(t, ""+
+			"# HELP latency_seconds Handler latency.\n"+
+			"# TYPE latency_seconds histogram\n"+
+			"latency_seconds_bucket{le=\"0.1\"} 1\n"+
+			"latency_seconds_bucket{le=\"0.5\"} 2\n"+
+			"latency_seconds_bucket{le=\"+Inf\"} 3\n"+
+			"latency_seconds_sum 3.25\n"+
+			"latency_seconds_count 3\n", output.String())
+	})
+
+	t.Run("Escapes label values and help", func(t *testing.T) {
+		registry := NewRegistry()
+		errors := registry.NewCounter("errors_total", "Errors\nby \\ type.", "type")
+		errors.Inc("say \"hi\"\n")
+
+		var output bytes.Buffer
+		require.NoError(t, registry.WriteText(&output))
+		assert.Contains(t, output.String(), "# HELP errors_total Errors\\nby \\\\ type.\n")
+		assert.Contains(t, output.String(), "errors_total{type=\"say \\\"hi\\\"\\n\"} 1\n")
+	})
+
+	t.Run("Rejects wrong label count", func(t *testing.T) {
+		registry := NewRegistry()
+		requests := registry.NewCounter("requests_total", "Requests served.", "method")
+		assert.Panics(t, func() { requests.Inc() })
+	})
+
+	t.Run("Rejects duplicate names", func(t *testing.T) {
+		registry := NewRegistry()
+		registry.NewCounter("requests_total", "Requests served.")
+		assert.Panics(t, func() { registry.NewGauge("requests_total", "Again.") })
+	})
+}
+
+func TestHandle(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Requests served.").Inc()
+	req, err := request.RequestFromConn(strings.NewReader("GET /metrics HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	w := response.NewWriter(&output)
+	registry.Handle(w, req)
+	require.NoError(t, w.Close())
+
+	assert.True(t, strings.HasPrefix(output.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, output.String(), "Content-Type: "+ContentType+"\r\n")
+	assert.True(t, strings.HasSuffix(output.String(), "requests_total 1\n"))
+}
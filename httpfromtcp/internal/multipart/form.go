@@ -0,0 +1,160 @@
+package multipart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"io"
+	"os"
+	"strings"
+)
+
+// Form is a fully read multipart/form-data body.
+type Form struct {
+	Values request.Values
+	Files  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file, its content is either held in
+// memory or in a temporary file depending on the memory budget.
+type FileHeader struct {
+	FileName string
+	Headers  headers.Headers
+	Size     int64
+	content  []byte
+	tmpFile  string
+}
+
+// Open returns the content of the file, the caller has to close it.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpFile == "" {
+		return io.NopCloser(bytes.NewReader(fh.content)), nil
+	}
+	file, err := os.Open(fh.tmpFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded file '%s': %v", fh.FileName, err)
+	}
+	return file, nil
+}
+
+// RemoveAll deletes the temporary files backing the uploaded files.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, fileHeaders := range f.Files {
+		for _, fh := range fileHeaders {
+			if fh.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsForm reports whether the request carries a multipart/form-data body,
+// for server.WithStreamedBodies.
+func IsForm(req *request.Request) bool {
+	contentType, _ := req.Headers.Get("Content-Type")
+	mediaType, _ := parseHeaderParams(contentType)
+	return strings.EqualFold(mediaType, "multipart/form-data")
+}
+
+// ParseRequest reads the multipart/form-data body of the request through
+// req.BodyReader. Memory stays bounded by limits.MaxMemory when the server
+// streams the body from the connection (see server.WithStreamedBodies),
+// otherwise the request already holds all of it in req.Body.
+func ParseRequest(req *request.Request, limits Limits) (*Form, error) {
+	contentType, _ := req.Headers.Get("Content-Type")
+	boundary, err := Boundary(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return ReadForm(req.BodyReader(), boundary, limits)
+}
+
+// ReadForm reads every part of a multipart/form-data body. Plain fields and
+// files share limits.MaxMemory: a field that does not fit fails the form
+// with ErrBodyTooLarge, while file parts spill to temporary files once the
+// memory is used up. Call RemoveAll once done with the form.
+func ReadForm(body io.Reader, boundary string, limits Limits) (*Form, error) {
+	form := &Form{
+		Values: make(request.Values),
+		Files:  make(map[string][]*FileHeader),
+	}
+	reader := NewReader(body, boundary, limits)
+	memoryLeft := limits.MaxMemory
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, fmt.Errorf("failed to read multipart form: %v", err)
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		fileName := part.FileName()
+		if fileName == "" {
+			var value bytes.Buffer
+			n, err := io.Copy(&value, io.LimitReader(part, max(memoryLeft, 0)+1))
+			if err != nil {
+				form.RemoveAll()
+				return nil, fmt.Errorf("failed to read field '%s': %v", name, err)
+			}
+			if n > memoryLeft {
+				form.RemoveAll()
+				return nil, fmt.Errorf("failed to read field '%s': %w", name, ErrBodyTooLarge)
+			}
+			memoryLeft -= n
+			form.Values.Add(name, value.String())
+			continue
+		}
+
+		fh := &FileHeader{FileName: fileName, Headers: part.Headers}
+		// read one byte past the budget to find out if the file fits
+		var content bytes.Buffer
+		n, err := io.Copy(&content, io.LimitReader(part, max(memoryLeft, 0)+1))
+		if err != nil {
+			form.RemoveAll()
+			return nil, fmt.Errorf("failed to read file '%s': %v", fileName, err)
+		}
+		if n <= memoryLeft {
+			fh.content = content.Bytes()
+			fh.Size = n
+			memoryLeft -= n
+		} else {
+			if err := spillToFile(fh, &content, part); err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+		}
+		form.Files[name] = append(form.Files[name], fh)
+	}
+}
+
+func spillToFile(fh *FileHeader, buffered io.Reader, rest io.Reader) error {
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for '%s': %v", fh.FileName, err)
+	}
+	defer file.Close()
+	fh.tmpFile = file.Name()
+
+	size, err := io.Copy(file, io.MultiReader(buffered, rest))
+	if err != nil {
+		os.Remove(fh.tmpFile)
+		return fmt.Errorf("failed to write temporary file for '%s': %v", fh.FileName, err)
+	}
+	fh.Size = size
+	return nil
+}
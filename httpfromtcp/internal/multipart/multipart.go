@@ -0,0 +1,305 @@
+package multipart
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strings"
+)
+
+const CRLF = "\r\n"
+
+const maxBoundaryLength = 70
+
+// maxLineLength bounds boundary and part header lines
+const maxLineLength = 8192
+
+var ErrTooManyParts = errors.New("multipart body has too many parts")
+
+var ErrBodyTooLarge = errors.New("multipart body is too large")
+
+type Limits struct {
+	// MaxParts is the most parts a body may contain
+	MaxParts int
+	// MaxTotalSize caps the bytes read for the whole body, part headers included
+	MaxTotalSize int64
+	// MaxMemory is how many bytes of field values and file content ReadForm
+	// keeps in memory before spilling file parts to temporary files, on top
+	// of whatever the body it reads from holds
+	MaxMemory int64
+}
+
+var DefaultLimits = Limits{
+	MaxParts:     1000,
+	MaxTotalSize: 32 << 20,
+	MaxMemory:    10 << 20,
+}
+
+// Boundary extracts the boundary parameter from a multipart Content-Type.
+func Boundary(contentType string) (string, error) {
+	mediaType, params := parseHeaderParams(contentType)
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return "", fmt.Errorf("content type '%s' is not multipart", contentType)
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return "", fmt.Errorf("content type '%s' has no boundary", contentType)
+	}
+	if len(boundary) == 0 || len(boundary) > maxBoundaryLength {
+		return "", fmt.Errorf("boundary '%s' must be between 1 and %d characters", boundary, maxBoundaryLength)
+	}
+	return boundary, nil
+}
+
+// Reader iterates over the parts of a multipart body (RFC 2046 section 5.1)
+// without buffering whole parts in memory.
+type Reader struct {
+	reader         *bufio.Reader
+	dashBoundary   string
+	nlDashBoundary []byte
+	limits         Limits
+	bytesRead      int64
+	partsRead      int
+	current        *Part
+	done           bool
+}
+
+func NewReader(body io.Reader, boundary string, limits Limits) *Reader {
+	return &Reader{
+		reader:         bufio.NewReaderSize(body, maxLineLength),
+		dashBoundary:   "--" + boundary,
+		nlDashBoundary: []byte(CRLF + "--" + boundary),
+		limits:         limits,
+	}
+}
+
+// NextPart skips whatever is left of the current part and returns the next
+// one, or io.EOF once the closing boundary has been read.
+func (r *Reader) NextPart() (*Part, error) {
+	if r.current != nil {
+		if _, err := io.Copy(io.Discard, r.current); err != nil {
+			return nil, err
+		}
+		r.current = nil
+	}
+	if r.done {
+		return nil, io.EOF
+	}
+
+	if r.partsRead == 0 {
+		if err := r.skipPreamble(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := r.readBoundary(); err != nil {
+			return nil, err
+		}
+	}
+	if r.done {
+		return nil, io.EOF
+	}
+
+	r.partsRead++
+	if r.partsRead > r.limits.MaxParts {
+		return nil, fmt.Errorf("%w, limit is %d", ErrTooManyParts, r.limits.MaxParts)
+	}
+
+	part := &Part{Headers: headers.NewHeaders(), reader: r}
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part headers: %v", err)
+		}
+		if line == "" {
+			break
+		}
+		if err := part.Headers.ParseLine(line); err != nil {
+			return nil, fmt.Errorf("failed to parse part header: %v", err)
+		}
+	}
+	r.current = part
+	return part, nil
+}
+
+// skipPreamble discards everything up to and including the first boundary
+// line.
+func (r *Reader) skipPreamble() error {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return fmt.Errorf("failed to find first boundary: %v", err)
+		}
+		line = strings.TrimRight(line, " \t")
+		if line == r.dashBoundary {
+			return nil
+		}
+		if line == r.dashBoundary+"--" {
+			r.done = true
+			return nil
+		}
+	}
+}
+
+// readBoundary consumes the delimiter that ended the previous part and
+// tells a regular boundary apart from the closing one.
+func (r *Reader) readBoundary() error {
+	if _, err := r.reader.Discard(len(r.nlDashBoundary)); err != nil {
+		return fmt.Errorf("failed to read boundary: %v", err)
+	}
+	if err := r.count(len(r.nlDashBoundary)); err != nil {
+		return err
+	}
+	rest, err := r.readLine()
+	if err != nil && !(errors.Is(err, io.EOF) && strings.HasPrefix(rest, "--")) {
+		return fmt.Errorf("failed to read boundary: %v", err)
+	}
+	if strings.HasPrefix(rest, "--") {
+		r.done = true
+		return nil
+	}
+	if strings.TrimRight(rest, " \t") != "" {
+		return fmt.Errorf("unexpected data '%s' after boundary", rest)
+	}
+	return nil
+}
+
+// readLine returns the next CRLF terminated line without the CRLF. At the
+// end of the body it returns what was left together with io.EOF.
+func (r *Reader) readLine() (string, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("line exceeds %d bytes", maxLineLength)
+	}
+	if err := r.count(len(line)); err != nil {
+		return "", err
+	}
+	if err != nil {
+		if err == io.EOF {
+			return string(line), io.EOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func (r *Reader) count(n int) error {
+	r.bytesRead += int64(n)
+	if r.bytesRead > r.limits.MaxTotalSize {
+		return fmt.Errorf("%w, limit is %d bytes", ErrBodyTooLarge, r.limits.MaxTotalSize)
+	}
+	return nil
+}
+
+// Part is a single section of a multipart body, reading from it yields the
+// part content up to the next boundary.
+type Part struct {
+	Headers headers.Headers
+	reader  *Reader
+	eof     bool
+}
+
+// FormName returns the name parameter of a form-data Content-Disposition.
+func (p *Part) FormName() string {
+	disposition, params := parseHeaderParams(p.contentDisposition())
+	if disposition != "form-data" {
+		return ""
+	}
+	return params["name"]
+}
+
+// FileName returns the filename parameter of the Content-Disposition with
+// any directory components removed.
+func (p *Part) FileName() string {
+	_, params := parseHeaderParams(p.contentDisposition())
+	fileName := params["filename"]
+	if i := strings.LastIndexAny(fileName, `/\`); i != -1 {
+		fileName = fileName[i+1:]
+	}
+	return fileName
+}
+
+func (p *Part) contentDisposition() string {
+	value, _ := p.Headers.Get("Content-Disposition")
+	return value
+}
+
+func (p *Part) Read(d []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	r := p.reader
+	delimiter := r.nlDashBoundary
+	for {
+		buffered, _ := r.reader.Peek(r.reader.Buffered())
+		i := bytes.Index(buffered, delimiter)
+		if i == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		available := i
+		if i == -1 {
+			// the tail of the buffer could be the start of a delimiter
+			available = len(buffered) - (len(delimiter) - 1)
+		}
+		if available > 0 {
+			n := copy(d, buffered[:available])
+			r.reader.Discard(n)
+			if err := r.count(n); err != nil {
+				return n, err
+			}
+			return n, nil
+		}
+		if _, err := r.reader.Peek(len(buffered) + 1); err != nil {
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+}
+
+// parseHeaderParams splits a value such as `form-data; name="file"` into
+// its lowercased leading token and parameters, unquoting quoted values.
+func parseHeaderParams(value string) (string, map[string]string) {
+	params := make(map[string]string)
+	token, rest, _ := strings.Cut(value, ";")
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		if rest == "" {
+			break
+		}
+		name, afterName, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		var paramValue string
+		if strings.HasPrefix(afterName, `"`) {
+			paramValue, rest = readQuotedString(afterName[1:])
+		} else {
+			paramValue, rest, _ = strings.Cut(afterName, ";")
+			paramValue = strings.TrimSpace(paramValue)
+		}
+		params[name] = paramValue
+	}
+	return strings.ToLower(strings.TrimSpace(token)), params
+}
+
+func readQuotedString(s string) (string, string) {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return builder.String(), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+		}
+		builder.WriteByte(s[i])
+	}
+	return builder.String(), ""
+}
@@ -0,0 +1,183 @@
+package multipart
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const boundary = "----WebKitFormBoundary7MA4YWxkTrZu0gW"
+
+func body(parts ...string) string {
+	return "preamble is ignored\r\n" + strings.Join(parts, "") + "--" + boundary + "--\r\n"
+}
+
+func field(name string, value string) string {
+	return "--" + boundary + "\r\n" + "Content-Disposition: form-data; name=\"" + name + "\"\r\n" + "\r\n" + value + "\r\n"
+}
+
+func file(name string, fileName string, content string) string {
+	return "--" + boundary + "\r\n" + "Content-Disposition: form-data; name=\"" + name + "\"; filename=\"" + fileName + "\"\r\n" + "Content-Type: text/plain\r\n" + "\r\n" + content + "\r\n"
+}
+
+func TestBoundary(t *testing.T) {
+	t.Run("Reads quoted and unquoted boundaries", func(t *testing.T) {
+		b, err := Boundary("multipart/form-data; boundary=" + boundary)
+		require.NoError(t, err)
+		assert.Equal(t, boundary, b)
+		b, err = Boundary(`multipart/form-data; charset=utf-8; boundary="with space"`)
+		require.NoError(t, err)
+		assert.Equal(t, "with space", b)
+	})
+
+	t.Run("Rejects non multipart types", func(t *testing.T) {
+		_, err := Boundary("application/json")
+		require.Error(t, err)
+	})
+
+	t.Run("Rejects missing boundary", func(t *testing.T) {
+		_, err := Boundary("multipart/form-data")
+		require.Error(t, err)
+	})
+}
+
+func TestReader(t *testing.T) {
+	t.Run("Streams parts with their headers", func(t *testing.T) {
+		reader := NewReader(strings.NewReader(body(field("title", "hello"), file("upload", "../../docs/notes.txt", "line one\r\nline two"))), boundary, DefaultLimits)
+
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "title", part.FormName())
+		assert.Equal(t, "", part.FileName())
+		content, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(content))
+
+		part, err = reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "upload", part.FormName())
+		assert.Equal(t, "notes.txt", part.FileName())
+		assert.Equal(t, "text/plain", part.Headers["Content-Type"])
+		content, err = io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "line one\r\nline two", string(content))
+
+		_, err = reader.NextPart()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Skips unread part content", func(t *testing.T) {
+		reader := NewReader(strings.NewReader(body(field("a", strings.Repeat("x", 20000)), field("b", "2"))), boundary, DefaultLimits)
+		_, err := reader.NextPart()
+		require.NoError(t, err)
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "b", part.FormName())
+	})
+
+	t.Run("Enforces the part count", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxParts = 1
+		reader := NewReader(strings.NewReader(body(field("a", "1"), field("b", "2"))), boundary, limits)
+		_, err := reader.NextPart()
+		require.NoError(t, err)
+		_, err = reader.NextPart()
+		require.ErrorIs(t, err, ErrTooManyParts)
+	})
+
+	t.Run("Enforces the total size", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxTotalSize = 1024
+		reader := NewReader(strings.NewReader(body(field("a", strings.Repeat("x", 2048)))), boundary, limits)
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		_, err = io.ReadAll(part)
+		require.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+
+	t.Run("Reports a missing closing boundary", func(t *testing.T) {
+		reader := NewReader(strings.NewReader("--"+boundary+"\r\n\r\nunterminated"), boundary, DefaultLimits)
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		_, err = io.ReadAll(part)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestReadForm(t *testing.T) {
+	t.Run("Keeps small files in memory", func(t *testing.T) {
+		form, err := ReadForm(strings.NewReader(body(field("title", "hello"), field("title", "again"), file("upload", "a.txt", "small"))), boundary, DefaultLimits)
+		require.NoError(t, err)
+		defer form.RemoveAll()
+
+		assert.Equal(t, []string{"hello", "again"}, form.Values["title"])
+		require.Len(t, form.Files["upload"], 1)
+		fh := form.Files["upload"][0]
+		assert.Equal(t, "a.txt", fh.FileName)
+		assert.Equal(t, int64(5), fh.Size)
+		assert.Empty(t, fh.tmpFile)
+		assert.Equal(t, "small", readFile(t, fh))
+	})
+
+	t.Run("Spills large files to disk", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxMemory = 10
+		content := strings.Repeat("0123456789", 1000)
+		form, err := ReadForm(strings.NewReader(body(file("upload", "big.bin", content))), boundary, limits)
+		require.NoError(t, err)
+
+		fh := form.Files["upload"][0]
+		require.NotEmpty(t, fh.tmpFile)
+		assert.Equal(t, int64(len(content)), fh.Size)
+		assert.Equal(t, content, readFile(t, fh))
+
+		require.NoError(t, form.RemoveAll())
+		_, err = os.Stat(fh.tmpFile)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestParseRequest(t *testing.T) {
+	t.Run("Reads a body streamed from the connection", func(t *testing.T) {
+		content := strings.Repeat("0123456789", 1000)
+		raw := body(field("title", "hello"), file("upload", "big.bin", content))
+		reader := request.NewReader(strings.NewReader(fmt.Sprintf("POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=%s\r\nContent-Length: %d\r\n\r\n%s", boundary, len(raw), raw)))
+		reader.StreamBody = IsForm
+		req, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Empty(t, req.Body)
+
+		limits := DefaultLimits
+		limits.MaxMemory = 100
+		form, err := ParseRequest(req, limits)
+		require.NoError(t, err)
+		defer form.RemoveAll()
+		assert.Equal(t, []string{"hello"}, form.Values["title"])
+		assert.Equal(t, content, readFile(t, form.Files["upload"][0]))
+	})
+
+	t.Run("Counts fields against the memory limit", func(t *testing.T) {
+		req := request.NewRequest("POST", "/upload", []byte(body(field("a", "12345"), field("b", "67890"))))
+		req.Headers.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		limits := DefaultLimits
+		limits.MaxMemory = 8
+		_, err := ParseRequest(req, limits)
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+}
+
+func readFile(t *testing.T, fh *FileHeader) string {
+	t.Helper()
+	file, err := fh.Open()
+	require.NoError(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return string(content)
+}
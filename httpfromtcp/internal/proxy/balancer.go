@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Strategy int
+
+const (
+	StrategyRoundRobin Strategy = iota
+	StrategyLeastConnections
+	StrategyConsistentHash
+)
+
+const (
+	DefaultMaxFailures      = 3
+	DefaultEjectionDuration = 30 * time.Second
+	// hashReplicas is the number of points each backend gets on the hash
+	// ring, more points spread the keys more evenly
+	hashReplicas = 100
+)
+
+var strategyNames = map[string]Strategy{
+	"round-robin":       StrategyRoundRobin,
+	"least-connections": StrategyLeastConnections,
+	"consistent-hash":   StrategyConsistentHash,
+}
+
+// ParseStrategy maps the names used on the command line to a Strategy.
+func ParseStrategy(name string) (Strategy, error) {
+	strategy, ok := strategyNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown load balancing strategy '%s'", name)
+	}
+	return strategy, nil
+}
+
+// Backend is a single upstream together with the state used to pick it.
+type Backend struct {
+	Address string
+
+	mu sync.Mutex
+	// healthy is owned by the active health checks
+	healthy bool
+	// failures counts consecutive failed requests, reaching MaxFailures
+	// ejects the backend until ejectedUntil
+	failures     int
+	ejectedUntil time.Time
+	active       int
+}
+
+// Available reports whether requests can be sent to the backend.
+func (b *Backend) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy && !time.Now().Before(b.ejectedUntil)
+}
+
+func (b *Backend) activeConnections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.active
+}
+
+type hashPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+// LoadBalancer forwards requests to one of several upstreams. Backends are
+// taken out of rotation by failing active health checks, or passively after
+// MaxFailures consecutive failed requests.
+type LoadBalancer struct {
+	Backends []*Backend
+	Strategy Strategy
+	// HashHeader is the request header hashed by StrategyConsistentHash,
+	// requests without it fall back to round-robin
+	HashHeader       string
+	MaxFailures      int
+	EjectionDuration time.Duration
+	DialTimeout      time.Duration
+	ResponseTimeout  time.Duration
+
+	next     uint64
+	nextMu   sync.Mutex
+	ring     []hashPoint
+	quitChan chan struct{}
+	stopOnce sync.Once
+}
+
+func NewLoadBalancer(upstreams []string, strategy Strategy) *LoadBalancer {
+	lb := &LoadBalancer{
+		Strategy:         strategy,
+		MaxFailures:      DefaultMaxFailures,
+		EjectionDuration: DefaultEjectionDuration,
+		DialTimeout:      DefaultDialTimeout,
+		ResponseTimeout:  DefaultResponseTimeout,
+		quitChan:         make(chan struct{}),
+	}
+	for _, upstream := range upstreams {
+		backend := &Backend{Address: upstream, healthy: true}
+		lb.Backends = append(lb.Backends, backend)
+		for i := 0; i < hashReplicas; i++ {
+			lb.ring = append(lb.ring, hashPoint{hash: hashKey(upstream + "#" + strconv.Itoa(i)), backend: backend})
+		}
+	}
+	sort.Slice(lb.ring, func(i, j int) bool { return lb.ring[i].hash < lb.ring[j].hash })
+	return lb
+}
+
+func (lb *LoadBalancer) Handle(w *response.Writer, req *request.Request) {
+	if rejectTransferCoding(w, req) {
+		return
+	}
+	backend := lb.pick(req)
+	if backend == nil {
+		w.WriteText(response.StatusServiceUnavailable, "no healthy upstream available\n")
+		return
+	}
+
+	backend.mu.Lock()
+	backend.active++
+	backend.mu.Unlock()
+	defer func() {
+		backend.mu.Lock()
+		backend.active--
+		backend.mu.Unlock()
+	}()
+
+	resp, conn, err := roundTrip(backend.Address, OutgoingRequest(req), lb.DialTimeout, lb.ResponseTimeout)
+	if err != nil {
+		lb.recordFailure(backend)
+		WriteGatewayError(w, err)
+		return
+	}
+	defer conn.Close()
+	lb.recordSuccess(backend)
+	CopyResponse(w, resp, conn)
+}
+
+func (lb *LoadBalancer) pick(req *request.Request) *Backend {
+	switch lb.Strategy {
+	case StrategyLeastConnections:
+		return lb.pickLeastConnections()
+	case StrategyConsistentHash:
+		if key, ok := req.Headers.Get(lb.HashHeader); ok && lb.HashHeader != "" {
+			return lb.pickHashed(key)
+		}
+	}
+	return lb.pickRoundRobin()
+}
+
+func (lb *LoadBalancer) pickRoundRobin() *Backend {
+	lb.nextMu.Lock()
+	start := lb.next
+	lb.next++
+	lb.nextMu.Unlock()
+
+	for i := range lb.Backends {
+		backend := lb.Backends[(int(start)+i)%len(lb.Backends)]
+		if backend.Available() {
+			return backend
+		}
+	}
+	return nil
+}
+
+func (lb *LoadBalancer) pickLeastConnections() *Backend {
+	var picked *Backend
+	least := 0
+	for _, backend := range lb.Backends {
+		if !backend.Available() {
+			continue
+		}
+		active := backend.activeConnections()
+		if picked == nil || active < least {
+			picked = backend
+			least = active
+		}
+	}
+	return picked
+}
+
+// pickHashed walks the ring clockwise from the key, so a backend going away
+// only moves the keys that were mapped to it.
+func (lb *LoadBalancer) pickHashed(key string) *Backend {
+	if len(lb.ring) == 0 {
+		return nil
+	}
+	hash := hashKey(key)
+	start := sort.Search(len(lb.ring), func(i int) bool { return lb.ring[i].hash >= hash })
+	for i := range lb.ring {
+		backend := lb.ring[(start+i)%len(lb.ring)].backend
+		if backend.Available() {
+			return backend
+		}
+	}
+	return nil
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func (lb *LoadBalancer) recordFailure(backend *Backend) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.failures++
+	if lb.MaxFailures > 0 && backend.failures >= lb.MaxFailures {
+		backend.ejectedUntil = time.Now().Add(lb.EjectionDuration)
+		backend.failures = 0
+	}
+}
+
+func (lb *LoadBalancer) recordSuccess(backend *Backend) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.failures = 0
+}
+
+// StartHealthChecks sends a GET for path to every backend each interval
+// until Close is called. A backend is healthy while it answers with 2xx,
+// a passing check also lifts a passive ejection.
+func (lb *LoadBalancer) StartHealthChecks(path string, interval time.Duration) {
+	lb.checkAll(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lb.quitChan:
+				return
+			case <-ticker.C:
+				lb.checkAll(path)
+			}
+		}
+	}()
+}
+
+func (lb *LoadBalancer) Close() error {
+	lb.stopOnce.Do(func() { close(lb.quitChan) })
+	return nil
+}
+
+func (lb *LoadBalancer) checkAll(path string) {
+	var wg sync.WaitGroup
+	for _, backend := range lb.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := lb.check(backend, path)
+			backend.mu.Lock()
+			defer backend.mu.Unlock()
+			backend.healthy = healthy
+			if healthy {
+				backend.failures = 0
+				backend.ejectedUntil = time.Time{}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (lb *LoadBalancer) check(backend *Backend, path string) bool {
+	req := request.NewRequest("GET", path, nil)
+	req.Headers.Set("Host", backend.Address)
+	req.Headers.Set("Connection", "close")
+	resp, conn, err := roundTrip(backend.Address, req, lb.DialTimeout, lb.ResponseTimeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	io.Copy(io.Discard, resp.Body)
+	code := resp.StatusCode()
+	return code >= 200 && code < 300
+}
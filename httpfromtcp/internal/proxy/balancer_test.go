@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBalancer(t *testing.T) {
+	t.Run("Round-robin alternates between backends", func(t *testing.T) {
+		a := startNamedBackend(t, "a", nil)
+		b := startNamedBackend(t, "b", nil)
+		lb := NewLoadBalancer([]string{a, b}, StrategyRoundRobin)
+		proxy := startServer(t, lb.Handle)
+
+		names := []string{}
+		for range 4 {
+			names = append(names, body(send(t, proxy, "GET / HTTP/1.1\r\n\r\n")))
+		}
+		assert.Equal(t, []string{"a", "b", "a", "b"}, names)
+	})
+
+	t.Run("Least-connections avoids the busy backend", func(t *testing.T) {
+		release := make(chan struct{})
+		a := startNamedBackend(t, "a", release)
+		b := startNamedBackend(t, "b", nil)
+		lb := NewLoadBalancer([]string{a, b}, StrategyLeastConnections)
+		proxy := startServer(t, lb.Handle)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "a", body(send(t, proxy, "GET / HTTP/1.1\r\n\r\n")))
+		}()
+		require.Eventually(t, func() bool { return lb.Backends[0].activeConnections() == 1 }, time.Second, 5*time.Millisecond)
+
+		for range 3 {
+			assert.Equal(t, "b", body(send(t, proxy, "GET / HTTP/1.1\r\n\r\n")))
+		}
+		close(release)
+		wg.Wait()
+	})
+
+	t.Run("Consistent hashing keeps a key on the same backend", func(t *testing.T) {
+		a := startNamedBackend(t, "a", nil)
+		b := startNamedBackend(t, "b", nil)
+		c := startNamedBackend(t, "c", nil)
+		lb := NewLoadBalancer([]string{a, b, c}, StrategyConsistentHash)
+		lb.HashHeader = "X-User"
+		proxy := startServer(t, lb.Handle)
+
+		seen := map[string]bool{}
+		for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+			first := body(send(t, proxy, "GET / HTTP/1.1\r\nX-User: "+user+"\r\n\r\n"))
+			second := body(send(t, proxy, "GET / HTTP/1.1\r\nX-User: "+user+"\r\n\r\n"))
+			assert.Equal(t, first, second)
+			seen[first] = true
+		}
+		assert.Greater(t, len(seen), 1)
+	})
+
+	t.Run("Consistent hashing only moves keys of a removed backend", func(t *testing.T) {
+		lb := NewLoadBalancer([]string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, StrategyConsistentHash)
+		keys := []string{"k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9", "k10"}
+		before := map[string]*Backend{}
+		for _, key := range keys {
+			before[key] = lb.pickHashed(key)
+		}
+
+		lb.Backends[1].healthy = false
+		for _, key := range keys {
+			after := lb.pickHashed(key)
+			assert.NotEqual(t, lb.Backends[1], after)
+			if before[key] != lb.Backends[1] {
+				assert.Equal(t, before[key], after, key)
+			}
+		}
+	})
+
+	t.Run("Ejects a backend after consecutive failures", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		down := listener.Addr().String()
+		listener.Close()
+		b := startNamedBackend(t, "b", nil)
+		lb := NewLoadBalancer([]string{down, b}, StrategyRoundRobin)
+		lb.MaxFailures = 2
+		proxy := startServer(t, lb.Handle)
+
+		responses := []string{}
+		for range 6 {
+			raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+			responses = append(responses, strings.SplitN(raw, "\r\n", 2)[0]+" "+body(raw))
+		}
+		assert.True(t, strings.HasPrefix(responses[0], "HTTP/1.1 502 Bad Gateway"))
+		assert.True(t, strings.HasPrefix(responses[2], "HTTP/1.1 502 Bad Gateway"))
+		assert.False(t, lb.Backends[0].Available())
+		for _, r := range responses[3:] {
+			assert.Equal(t, "HTTP/1.1 200 OK b", r)
+		}
+	})
+
+	t.Run("Active health checks take failing backends out of rotation", func(t *testing.T) {
+		unhealthy := startServer(t, func(w *response.Writer, req *request.Request) {
+			if req.Path() == "/healthz" {
+				w.WriteText(response.StatusInternalServerError, "down")
+				return
+			}
+			w.WriteText(response.StatusOK, "a")
+		}).Addr().String()
+		b := startNamedBackend(t, "b", nil)
+		lb := NewLoadBalancer([]string{unhealthy, b}, StrategyRoundRobin)
+		lb.StartHealthChecks("/healthz", time.Hour)
+		t.Cleanup(func() { lb.Close() })
+		proxy := startServer(t, lb.Handle)
+
+		for range 3 {
+			assert.Equal(t, "b", body(send(t, proxy, "GET / HTTP/1.1\r\n\r\n")))
+		}
+	})
+
+	t.Run("Answers 503 without an available backend", func(t *testing.T) {
+		lb := NewLoadBalancer([]string{"127.0.0.1:1"}, StrategyRoundRobin)
+		lb.Backends[0].healthy = false
+		proxy := startServer(t, lb.Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 503 Service Unavailable\r\n"))
+	})
+}
+
+func TestParseStrategy(t *testing.T) {
+	strategy, err := ParseStrategy("least-connections")
+	require.NoError(t, err)
+	assert.Equal(t, StrategyLeastConnections, strategy)
+
+	_, err = ParseStrategy("random")
+	assert.Error(t, err)
+}
+
+// startNamedBackend answers every request with its name, when release is set
+// it waits for it to be closed first
+func startNamedBackend(t *testing.T, name string, release chan struct{}) string {
+	t.Helper()
+	return startServer(t, func(w *response.Writer, req *request.Request) {
+		if release != nil {
+			<-release
+		}
+		w.WriteText(response.StatusOK, name)
+	}).Addr().String()
+}
+
+func body(raw string) string {
+	_, body, _ := strings.Cut(raw, "\r\n\r\n")
+	return body
+}
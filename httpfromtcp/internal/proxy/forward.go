@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAllowedConnectPorts only lets CONNECT reach TLS endpoints.
+var DefaultAllowedConnectPorts = []int{443}
+
+// ForwardProxy serves clients that use it as their HTTP proxy. Requests with
+// an absolute-form http:// target are fetched from the origin, CONNECT
+// requests open a tunnel to one of AllowedConnectPorts.
+type ForwardProxy struct {
+	AllowedConnectPorts []int
+	DialTimeout         time.Duration
+	ResponseTimeout     time.Duration
+}
+
+func NewForwardProxy(allowedConnectPorts ...int) *ForwardProxy {
+	if len(allowedConnectPorts) == 0 {
+		allowedConnectPorts = DefaultAllowedConnectPorts
+	}
+	return &ForwardProxy{
+		AllowedConnectPorts: allowedConnectPorts,
+		DialTimeout:         DefaultDialTimeout,
+		ResponseTimeout:     DefaultResponseTimeout,
+	}
+}
+
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+
+	target := req.RequestLine.RequestTarget
+	if strings.HasPrefix(target, "https://") {
+		w.WriteText(response.StatusNotImplemented, "https targets have to be tunnelled with CONNECT\n")
+		return
+	}
+	authority := req.Authority()
+	if authority == "" {
+		w.WriteText(response.StatusBadRequest, "forward proxy requests need an absolute-form target\n")
+		return
+	}
+	if rejectTransferCoding(w, req) {
+		return
+	}
+
+	outgoing := OutgoingRequest(req)
+	outgoing.RequestLine.RequestTarget = req.OriginForm()
+	outgoing.Headers.Set("Host", authority)
+	resp, conn, err := roundTrip(withDefaultPort(authority, "80"), outgoing, p.DialTimeout, p.ResponseTimeout)
+	if err != nil {
+		WriteGatewayError(w, err)
+		return
+	}
+	defer conn.Close()
+	CopyResponse(w, resp, conn)
+}
+
+// tunnel answers 200 once the destination is reached and then splices bytes
+// both ways until either side closes.
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	address := req.Authority()
+	_, portRaw, _ := net.SplitHostPort(address)
+	port, err := strconv.Atoi(portRaw)
+	if err != nil || !slices.Contains(p.AllowedConnectPorts, port) {
+		w.WriteText(response.StatusForbidden, fmt.Sprintf("CONNECT to port %s is not allowed\n", portRaw))
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", address, p.DialTimeout)
+	if err != nil {
+		WriteGatewayError(w, fmt.Errorf("failed to connect to %s: %w", address, err))
+		return
+	}
+	defer upstream.Close()
+
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+		return
+	}
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	// clients may start the tunnelled protocol without waiting for the 200
+	if _, err := upstream.Write(buffered); err != nil {
+		return
+	}
+	Splice(client, upstream)
+}
+
+// Splice copies between a and b in both directions. When one direction
+// ends the write side of the other connection is closed so the peer sees
+// EOF, and Splice returns once both directions are done.
+func Splice(a net.Conn, b net.Conn) {
+	var wg sync.WaitGroup
+	copyAndCloseWrite := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyAndCloseWrite(a, b)
+	go copyAndCloseWrite(b, a)
+	wg.Wait()
+}
+
+func withDefaultPort(authority string, port string) string {
+	if _, _, err := net.SplitHostPort(authority); err == nil {
+		return authority
+	}
+	return net.JoinHostPort(strings.Trim(authority, "[]"), port)
+}
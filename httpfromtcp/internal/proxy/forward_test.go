@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/compression"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardProxy(t *testing.T) {
+	t.Run("Fetches absolute-form targets from the origin", func(t *testing.T) {
+		origin := startServer(t, func(w *response.Writer, req *request.Request) {
+			host, _ := req.Headers.Get("Host")
+			_, hasProxyAuth := req.Headers.Get("Proxy-Authorization")
+			w.WriteText(response.StatusOK, fmt.Sprintf("%s host=%s proxy-auth=%t", req.RequestLine.RequestTarget, host, hasProxyAuth))
+		})
+		proxy := startServer(t, NewForwardProxy().Handle)
+
+		address := origin.Addr().String()
+		raw := send(t, proxy, "GET http://"+address+"/items?page=2 HTTP/1.1\r\nHost: "+address+"\r\nProxy-Authorization: Basic Zm9v\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+		assert.Equal(t, "/items?page=2 host="+address+" proxy-auth=false", body(raw))
+	})
+
+	t.Run("Rejects origin-form targets", func(t *testing.T) {
+		proxy := startServer(t, NewForwardProxy().Handle)
+
+		raw := send(t, proxy, "GET /items HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 400 Bad Request\r\n"))
+	})
+
+	t.Run("Refers https targets to CONNECT", func(t *testing.T) {
+		proxy := startServer(t, NewForwardProxy().Handle)
+
+		raw := send(t, proxy, "GET https://example.com/ HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 501 Not Implemented\r\n"))
+	})
+
+	t.Run("Tunnels CONNECT to an allowed port", func(t *testing.T) {
+		echo := startRawUpstream(t, func(conn net.Conn) {
+			io.Copy(conn, conn)
+		})
+		_, portRaw, _ := net.SplitHostPort(echo)
+		port, _ := strconv.Atoi(portRaw)
+		proxy := startServer(t, NewForwardProxy(port).Handle)
+
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\n")
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := response.ResponseFromReader(reader, "CONNECT")
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusOK, resp.StatusCode())
+
+		_, err = io.WriteString(conn, "ping through the tunnel")
+		require.NoError(t, err)
+		conn.(*net.TCPConn).CloseWrite()
+		echoed, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "ping through the tunnel", string(echoed))
+	})
+
+	t.Run("Tunnels CONNECT through the middleware chain untouched", func(t *testing.T) {
+		echo := startRawUpstream(t, func(conn net.Conn) {
+			io.Copy(conn, conn)
+		})
+		_, portRaw, _ := net.SplitHostPort(echo)
+		port, _ := strconv.Atoi(portRaw)
+		proxy := startServer(t, server.Chain(NewForwardProxy(port).Handle,
+			compression.NewRequestDecoder(compression.DefaultMaxDecodedSize),
+			compression.Middleware,
+		))
+
+		raw := send(t, proxy, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\nAccept-Encoding: gzip\r\n\r\nplain bytes")
+		head, tunnelled, _ := strings.Cut(raw, "\r\n\r\n")
+		// no Transfer-Encoding, Content-Encoding nor Vary
+		assert.Equal(t, "HTTP/1.1 200 OK", head)
+		assert.Equal(t, "plain bytes", tunnelled)
+	})
+
+	t.Run("Forwards bytes sent right behind CONNECT", func(t *testing.T) {
+		echo := startRawUpstream(t, func(conn net.Conn) {
+			io.Copy(conn, conn)
+		})
+		_, portRaw, _ := net.SplitHostPort(echo)
+		port, _ := strconv.Atoi(portRaw)
+		proxy := startServer(t, NewForwardProxy(port).Handle)
+
+		raw := send(t, proxy, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\nhello before the 200")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"), raw)
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\nhello before the 200"), raw)
+	})
+
+	t.Run("Refuses CONNECT to a port outside the allowlist", func(t *testing.T) {
+		proxy := startServer(t, NewForwardProxy(443).Handle)
+
+		raw := send(t, proxy, "CONNECT 127.0.0.1:25 HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 403 Forbidden\r\n"))
+	})
+
+	t.Run("Answers 502 when the CONNECT destination is down", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		listener.Close()
+		_, portRaw, _ := net.SplitHostPort(address)
+		port, _ := strconv.Atoi(portRaw)
+		proxy := startServer(t, NewForwardProxy(port).Handle)
+
+		raw := send(t, proxy, "CONNECT "+address+" HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 502 Bad Gateway\r\n"))
+	})
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDialTimeout     = 5 * time.Second
+	DefaultResponseTimeout = 30 * time.Second
+)
+
+// hopByHopHeaders only apply to a single connection and must not be
+// forwarded (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy forwards every request to a single upstream HTTP/1.1 server
+// and streams its response back.
+type ReverseProxy struct {
+	Upstream string
+	// DialTimeout bounds connecting to the upstream
+	DialTimeout time.Duration
+	// ResponseTimeout bounds sending the request and receiving the response
+	// head, the body is streamed without a deadline
+	ResponseTimeout time.Duration
+}
+
+func NewReverseProxy(upstream string) *ReverseProxy {
+	return &ReverseProxy{
+		Upstream:        upstream,
+		DialTimeout:     DefaultDialTimeout,
+		ResponseTimeout: DefaultResponseTimeout,
+	}
+}
+
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	if rejectTransferCoding(w, req) {
+		return
+	}
+	outgoing := OutgoingRequest(req)
+	resp, conn, err := roundTrip(p.Upstream, outgoing, p.DialTimeout, p.ResponseTimeout)
+	if err != nil {
+		WriteGatewayError(w, err)
+		return
+	}
+	defer conn.Close()
+	CopyResponse(w, resp, conn)
+}
+
+// rejectTransferCoding answers 411 to requests whose body is in a transfer
+// coding, as the server does not decode those and the body would reach the
+// upstream empty. It reports whether it answered.
+func rejectTransferCoding(w *response.Writer, req *request.Request) bool {
+	if _, ok := req.Headers.Get("Transfer-Encoding"); !ok {
+		return false
+	}
+	w.WriteText(response.StatusLengthRequired, "request bodies have to be sent with a Content-Length\n")
+	return true
+}
+
+// OutgoingRequest copies req for forwarding: hop-by-hop headers are removed
+// and the client address is appended to X-Forwarded-For and Forwarded. A
+// request asking to upgrade the connection keeps its Upgrade header.
+func OutgoingRequest(req *request.Request) *request.Request {
+	outgoing := request.NewRequest(req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body)
+	outgoing.Headers = req.Headers.Clone()
+	RemoveHopByHopHeaders(outgoing.Headers)
+	outgoing.Headers.Delete("Content-Length")
+	if len(req.Body) > 0 || req.RequestLine.Method == "POST" || req.RequestLine.Method == "PUT" {
+		outgoing.Headers.Set("Content-Length", strconv.Itoa(len(req.Body)))
+	}
+	if protocol, ok := req.Headers.Get("Upgrade"); ok && req.Headers.HasToken("Connection", "upgrade") {
+		outgoing.Headers.Set("Upgrade", protocol)
+		outgoing.Headers.Set("Connection", "Upgrade")
+	} else {
+		// connections to the upstream are not reused
+		outgoing.Headers.Set("Connection", "close")
+	}
+
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if clientIP != "" {
+		outgoing.Headers.Add("X-Forwarded-For", clientIP)
+		outgoing.Headers.Add("Forwarded", forwardedElement(clientIP, req))
+	}
+	return outgoing
+}
+
+// forwardedElement builds a Forwarded element (RFC 7239), IPv6 addresses
+// have to be bracketed and quoted.
+func forwardedElement(clientIP string, req *request.Request) string {
+	forValue := clientIP
+	if strings.Contains(clientIP, ":") {
+		forValue = `"[` + clientIP + `]"`
+	}
+	element := "for=" + forValue
+	if host, ok := req.Headers.Get("Host"); ok && host != "" {
+		element += `;host="` + strings.ReplaceAll(host, `"`, "") + `"`
+	}
+	return element + ";proto=http"
+}
+
+// RemoveHopByHopHeaders drops the fixed hop-by-hop headers together with
+// any field the Connection header lists.
+func RemoveHopByHopHeaders(h headers.Headers) {
+	for _, name := range h.Values("Connection") {
+		h.Delete(name)
+	}
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+}
+
+// roundTrip sends req on a new connection and reads the response head. The
+// caller has to close the connection once it is done with the body. Reads
+// from the returned connection continue after the response head, for
+// protocols switched to with 101.
+func roundTrip(address string, req *request.Request, dialTimeout time.Duration, responseTimeout time.Duration) (*response.Response, net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to upstream %s: %w", address, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := exchange(conn, reader, req, responseTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return resp, &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+func exchange(conn net.Conn, reader *bufio.Reader, req *request.Request, responseTimeout time.Duration) (*response.Response, error) {
+	if responseTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(responseTimeout))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send request upstream: %w", err)
+	}
+	resp, err := response.ResponseFromReader(reader, req.RequestLine.Method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstream response: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return resp, nil
+}
+
+// WriteGatewayError answers with 504 when the upstream timed out and with
+// 502 for any other failure to get a response.
+func WriteGatewayError(w *response.Writer, err error) {
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		w.WriteText(response.StatusGatewayTimeout, fmt.Sprintf("%v\n", err))
+		return
+	}
+	w.WriteText(response.StatusBadGateway, fmt.Sprintf("%v\n", err))
+}
+
+// bufferedConn reads the bytes buffered while parsing the response head
+// before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// CopyResponse relays an upstream response read from upstream. A body of
+// unknown length is re-framed with the chunked coding, keeping any other
+// transfer coding applied to it, and a body that breaks off midway aborts
+// the response so the client can tell it is truncated. A 101 response keeps
+// its Upgrade header and splices the client with upstream for the new
+// protocol.
+func CopyResponse(w *response.Writer, resp *response.Response, upstream net.Conn) {
+	h := resp.Headers.Clone()
+	RemoveHopByHopHeaders(h)
+	switchingProtocols := resp.StatusCode() == response.StatusSwitchingProtocols
+	if protocol, ok := resp.Headers.Get("Upgrade"); ok && switchingProtocols {
+		h.Set("Upgrade", protocol)
+		h.Set("Connection", "Upgrade")
+	}
+	if resp.ContentLength < 0 {
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", strings.Join(append(innerTransferCodings(resp.Headers), "chunked"), ", "))
+	}
+
+	if err := w.WriteStatusLine(resp.StatusCode()); err != nil {
+		w.Abort()
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		w.Abort()
+		return
+	}
+	if switchingProtocols {
+		client, buffered, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		// the client may speak the new protocol without waiting for the 101
+		if _, err := upstream.Write(buffered); err != nil {
+			return
+		}
+		Splice(client, upstream)
+		return
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		w.Abort()
+	}
+}
+
+// innerTransferCodings lists the transfer codings of the upstream body that
+// are left once the chunked coding, always the last one, is taken off.
+func innerTransferCodings(h headers.Headers) []string {
+	codings := h.Values("Transfer-Encoding")
+	if len(codings) > 0 && strings.EqualFold(codings[len(codings)-1], "chunked") {
+		codings = codings[:len(codings)-1]
+	}
+	return codings
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxy(t *testing.T) {
+	t.Run("Forwards requests and adds forwarding headers", func(t *testing.T) {
+		upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+			xff, _ := req.Headers.Get("X-Forwarded-For")
+			forwarded, _ := req.Headers.Get("Forwarded")
+			w.WriteText(response.StatusCreated, fmt.Sprintf("%s %s body=%s xff=%s forwarded=%s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body, xff, forwarded))
+		})
+		proxy := startServer(t, NewReverseProxy(upstream.Addr().String()).Handle)
+
+		raw := send(t, proxy, "POST /orders?id=1 HTTP/1.1\r\nHost: shop.local\r\nX-Forwarded-For: 10.0.0.1\r\nContent-Length: 5\r\n\r\nhello")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 201 Created\r\n"))
+		assert.True(t, strings.HasSuffix(raw, `POST /orders?id=1 body=hello xff=10.0.0.1, 127.0.0.1 forwarded=for=127.0.0.1;host="shop.local";proto=http`))
+	})
+
+	t.Run("Strips hop-by-hop headers", func(t *testing.T) {
+		upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+			names := []string{}
+			for _, name := range []string{"Keep-Alive", "Te", "Upgrade", "X-Secret", "X-Kept"} {
+				if _, ok := req.Headers.Get(name); ok {
+					names = append(names, name)
+				}
+			}
+			connection, _ := req.Headers.Get("Connection")
+			w.WriteText(response.StatusOK, strings.Join(names, ",")+" connection="+connection)
+		})
+		proxy := startServer(t, NewReverseProxy(upstream.Addr().String()).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\nConnection: keep-alive, X-Secret\r\nKeep-Alive: timeout=5\r\nTE: trailers\r\nUpgrade: websocket\r\nX-Secret: 1\r\nX-Kept: 1\r\n\r\n")
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\nX-Kept connection=close"))
+	})
+
+	t.Run("Streams chunked responses", func(t *testing.T) {
+		upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(0)
+			h.Delete("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte("first "))
+			w.WriteBody([]byte("second"))
+		})
+		proxy := startServer(t, NewReverseProxy(upstream.Addr().String()).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.Contains(t, raw, "Transfer-Encoding: chunked\r\n")
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n6\r\nfirst \r\n6\r\nsecond\r\n0\r\n\r\n"))
+	})
+
+	t.Run("Re-frames close delimited responses as chunked", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			request.RequestFromConn(conn)
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil close")
+		})
+		proxy := startServer(t, NewReverseProxy(upstream).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasSuffix(raw, "Transfer-Encoding: chunked\r\n\r\nb\r\nuntil close\r\n0\r\n\r\n"))
+	})
+
+	t.Run("Keeps transfer codings other than chunked", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			request.RequestFromConn(conn)
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n4\r\ngzip\r\n0\r\n\r\n")
+		})
+		proxy := startServer(t, NewReverseProxy(upstream).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.Contains(t, raw, "Transfer-Encoding: gzip, chunked\r\n")
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n4\r\ngzip\r\n0\r\n\r\n"))
+	})
+
+	t.Run("Re-frames close delimited codings as chunked", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			request.RequestFromConn(conn)
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\ngzip")
+		})
+		proxy := startServer(t, NewReverseProxy(upstream).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.Contains(t, raw, "Transfer-Encoding: gzip, chunked\r\n")
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n4\r\ngzip\r\n0\r\n\r\n"))
+	})
+
+	t.Run("Tunnels upgraded connections", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			req, err := request.RequestFromConn(conn)
+			if err != nil {
+				return
+			}
+			upgrade, _ := req.Headers.Get("Upgrade")
+			io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: "+upgrade+"\r\nConnection: Upgrade\r\n\r\n")
+			io.Copy(conn, conn)
+		})
+		proxy := startServer(t, NewReverseProxy(upstream).Handle)
+
+		raw := send(t, proxy, "GET /chat HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nping")
+		head, body, found := strings.Cut(raw, "\r\n\r\n")
+		require.True(t, found)
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"))
+		assert.Contains(t, head, "Upgrade: echo")
+		assert.Contains(t, head, "Connection: Upgrade")
+		assert.Equal(t, "ping", body)
+	})
+
+	t.Run("Refuses chunked request bodies instead of dropping them", func(t *testing.T) {
+		forwarded := make(chan struct{}, 1)
+		upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+			forwarded <- struct{}{}
+			w.WriteText(response.StatusOK, "ok")
+		})
+		proxy := startServer(t, NewReverseProxy(upstream.Addr().String()).Handle)
+
+		raw := send(t, proxy, "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 411 Length Required\r\n"))
+		assert.Empty(t, forwarded)
+	})
+
+	t.Run("Answers 502 when the upstream is down", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		listener.Close()
+		proxy := startServer(t, NewReverseProxy(address).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 502 Bad Gateway\r\n"))
+	})
+
+	t.Run("Answers 502 on a malformed upstream response", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			request.RequestFromConn(conn)
+			io.WriteString(conn, "NOT HTTP\r\n\r\n")
+		})
+		proxy := startServer(t, NewReverseProxy(upstream).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 502 Bad Gateway\r\n"))
+	})
+
+	t.Run("Answers 504 when the upstream is too slow", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			request.RequestFromConn(conn)
+			time.Sleep(500 * time.Millisecond)
+		})
+		reverseProxy := NewReverseProxy(upstream)
+		reverseProxy.ResponseTimeout = 50 * time.Millisecond
+		proxy := startServer(t, reverseProxy.Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 504 Gateway Timeout\r\n"))
+	})
+
+	t.Run("Truncates the response when the upstream breaks off", func(t *testing.T) {
+		upstream := startRawUpstream(t, func(conn net.Conn) {
+			request.RequestFromConn(conn)
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel")
+		})
+		proxy := startServer(t, NewReverseProxy(upstream).Handle)
+
+		raw := send(t, proxy, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+		assert.NotContains(t, raw, "0\r\n\r\n")
+	})
+}
+
+func startServer(t *testing.T, handler server.Handler) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// startRawUpstream serves every connection with handle and closes it after,
+// for upstreams misbehaving in ways the server never would
+func startRawUpstream(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func send(t *testing.T, s *server.Server, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	// no further requests, so the server closes the connection after answering
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(data)
+}
@@ -0,0 +1,186 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds reading the header of a connection.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Trusted lists the proxies whose header is believed.
+type Trusted struct {
+	Prefixes []netip.Prefix
+	// Unix trusts every peer of a Unix domain socket, access to which is
+	// controlled by the permissions of the socket file
+	Unix bool
+}
+
+// Listener expects a PROXY protocol header on connections from trusted
+// proxies and reports the addresses it carries as theirs. Connections from
+// other peers are passed through untouched, so a header they send is never
+// believed and fails as a malformed request instead.
+type Listener struct {
+	net.Listener
+	// HeaderTimeout bounds reading the header, however long the read
+	// deadline of the connection, so a proxy that never sends one does not
+	// hold the connection forever
+	HeaderTimeout time.Duration
+	trusted       Trusted
+}
+
+func NewListener(inner net.Listener, trusted Trusted) *Listener {
+	return &Listener{Listener: inner, HeaderTimeout: DefaultHeaderTimeout, trusted: trusted}
+}
+
+// ParseTrusted parses a comma separated list of CIDR prefixes or single
+// addresses, e.g. "10.0.0.0/8,192.0.2.7", where "unix" stands for the peers
+// of a Unix domain socket.
+func ParseTrusted(list string) (Trusted, error) {
+	trusted := Trusted{Prefixes: []netip.Prefix{}}
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		switch {
+		case raw == "":
+			continue
+		case raw == "unix":
+			trusted.Unix = true
+		case !strings.Contains(raw, "/"):
+			addr, err := netip.ParseAddr(raw)
+			if err != nil {
+				return Trusted{}, fmt.Errorf("failed to parse trusted proxy '%s': %v", raw, err)
+			}
+			trusted.Prefixes = append(trusted.Prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		default:
+			prefix, err := netip.ParsePrefix(raw)
+			if err != nil {
+				return Trusted{}, fmt.Errorf("failed to parse trusted proxy '%s': %v", raw, err)
+			}
+			trusted.Prefixes = append(trusted.Prefixes, prefix.Masked())
+		}
+	}
+	return trusted, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	c := NewConn(conn)
+	c.headerTimeout = l.HeaderTimeout
+	return c, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.UnixAddr:
+		return l.trusted.Unix
+	case *net.TCPAddr:
+		ip := addr.AddrPort().Addr().Unmap()
+		for _, prefix := range l.trusted.Prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is a connection starting with a PROXY protocol header, which is read
+// on the first call to Read, RemoteAddr or LocalAddr, within the header
+// timeout or the read deadline of the connection, whichever comes first.
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+	once          sync.Once
+	header        *Header
+	err           error
+
+	mu sync.Mutex
+	// readDeadline is the deadline set by the caller, restored once the
+	// header has been read
+	readDeadline time.Time
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: DefaultHeaderTimeout}
+}
+
+// Header returns the header sent by the proxy, an error when it was
+// missing, malformed or not sent in time.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		c.mu.Unlock()
+		if headerDeadline := time.Now().Add(c.headerTimeout); deadline.IsZero() || headerDeadline.Before(deadline) {
+			c.Conn.SetReadDeadline(headerDeadline)
+		}
+
+		c.header, c.err = ReadHeader(c.reader)
+
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+	})
+	return c.header, c.err
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Read fails for good when the connection did not start with a valid
+// header.
+func (c *Conn) Read(p []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr is the source address sent by the proxy, or the address of
+// the proxy itself when it sent none.
+func (c *Conn) RemoteAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header.Source != nil {
+		return header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr is the destination address sent by the proxy, or the local
+// address of the connection from the proxy when it sent none.
+func (c *Conn) LocalAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header.Destination != nil {
+		return header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite half-closes the underlying connection when it supports it.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
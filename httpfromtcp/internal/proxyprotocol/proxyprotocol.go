@@ -0,0 +1,192 @@
+// Package proxyprotocol reads the header load balancers such as HAProxy
+// send ahead of a proxied connection to pass on the addresses of the
+// original one (https://www.haproxy.org/download/3.0/doc/proxy-protocol.txt).
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// signatureV2 starts every version 2 header.
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// maxLineV1 is the longest version 1 header, CRLF included
+	maxLineV1 = 107
+	// headerSizeV2 is the fixed part of a version 2 header
+	headerSizeV2 = 16
+)
+
+// Header is what the proxy tells about the original connection.
+type Header struct {
+	Version int
+	// Source and Destination are the addresses of the client and of the
+	// server it connected to, nil when the proxy did not pass them on, as
+	// for its own health checks or unknown protocols
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads a version 1 or 2 header from the start of r.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	start, err := r.Peek(len("PROXY "))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if string(start) == "PROXY " {
+		return readV1(r)
+	}
+	if bytes.HasPrefix(signatureV2, start) {
+		return readV2(r)
+	}
+	return nil, fmt.Errorf("connection does not start with a PROXY protocol header")
+}
+
+// readV1 parses the text header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1
+// 56324 443\r\n".
+func readV1(r *bufio.Reader) (*Header, error) {
+	line := []byte{}
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxLineV1 {
+			return nil, fmt.Errorf("PROXY protocol header exceeds %d bytes", maxLineV1)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	parts := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	header := &Header{Version: 1}
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		// the rest of the line is to be ignored
+		return header, nil
+	}
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid PROXY protocol header '%s'", strings.TrimSpace(string(line)))
+	}
+	var is4 bool
+	switch parts[1] {
+	case "TCP4":
+		is4 = true
+	case "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol family '%s'", parts[1])
+	}
+
+	addrs := [2]net.Addr{}
+	for i := range addrs {
+		ip, err := netip.ParseAddr(parts[2+i])
+		if err != nil || ip.Is4() != is4 {
+			return nil, fmt.Errorf("invalid %s address '%s' in PROXY protocol header", parts[1], parts[2+i])
+		}
+		port, err := parsePort(parts[4+i])
+		if err != nil {
+			return nil, err
+		}
+		addrs[i] = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port))
+	}
+	header.Source, header.Destination = addrs[0], addrs[1]
+	return header, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("invalid port '%s' in PROXY protocol header", s)
+	}
+	return uint16(port), nil
+}
+
+const (
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+)
+
+// readV2 parses the binary header.
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, headerSizeV2)
+	if _, err := readFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:len(signatureV2)], signatureV2) {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 signature")
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	command := fixed[12] & 0x0f
+	family := fixed[13] >> 4
+	length := binary.BigEndian.Uint16(fixed[14:16])
+	// the addresses are followed by TLVs, which are skipped
+	payload := make([]byte, length)
+	if _, err := readFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &Header{Version: 2}
+	switch command {
+	case commandLocal:
+		// a connection of the proxy itself, such as a health check
+		return header, nil
+	case commandProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol command %d", command)
+	}
+
+	switch family {
+	case familyInet, familyInet6:
+		size := 4
+		if family == familyInet6 {
+			size = 16
+		}
+		if len(payload) < 2*size+4 {
+			return nil, fmt.Errorf("PROXY protocol v2 addresses are truncated")
+		}
+		src, _ := netip.AddrFromSlice(payload[:size])
+		dst, _ := netip.AddrFromSlice(payload[size : 2*size])
+		ports := payload[2*size:]
+		header.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports[0:2])))
+		header.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:4])))
+	case familyUnix:
+		if len(payload) < 216 {
+			return nil, fmt.Errorf("PROXY protocol v2 addresses are truncated")
+		}
+		header.Source = &net.UnixAddr{Name: unixPath(payload[:108]), Net: "unix"}
+		header.Destination = &net.UnixAddr{Name: unixPath(payload[108:216]), Net: "unix"}
+	case familyUnspec:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 family %d", family)
+	}
+	return header, nil
+}
+
+func readFull(r *bufio.Reader, p []byte) (int, error) {
+	n, err := io.ReadFull(r, p)
+	if err != nil {
+		return n, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	return n, nil
+}
+
+// unixPath trims the NUL padding of an address of the unix family.
+func unixPath(raw []byte) string {
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		raw = raw[:i]
+	}
+	return string(raw)
+}
@@ -0,0 +1,218 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	t.Run("Reads TCP4 and TCP6 addresses and leaves the rest", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+		header, err := ReadHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, 1, header.Version)
+		assert.Equal(t, "192.0.2.1:56324", header.Source.String())
+		assert.Equal(t, "198.51.100.1:443", header.Destination.String())
+		rest, _ := io.ReadAll(r)
+		assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+
+		header, err = ReadHeader(bufio.NewReader(strings.NewReader("PROXY TCP6 2001:db8::1 2001:db8::2 4000 80\r\n")))
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::1]:4000", header.Source.String())
+		assert.Equal(t, "[2001:db8::2]:80", header.Destination.String())
+	})
+
+	t.Run("Passes no addresses for UNKNOWN", func(t *testing.T) {
+		header, err := ReadHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN ffff:f...f:ffff 0\r\n")))
+		require.NoError(t, err)
+		assert.Nil(t, header.Source)
+		assert.Nil(t, header.Destination)
+	})
+
+	t.Run("Rejects malformed headers", func(t *testing.T) {
+		for _, raw := range []string{
+			"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+			"PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n",
+			"PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n",
+			"PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n",
+			"PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+			"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n",
+			"PROXY " + strings.Repeat("A", 120) + "\r\n",
+			"GET / HTTP/1.1\r\n",
+		} {
+			_, err := ReadHeader(bufio.NewReader(strings.NewReader(raw)))
+			assert.Error(t, err, raw)
+		}
+	})
+}
+
+// headerV2 builds a version 2 header with the given command, family and
+// address block.
+func headerV2(command byte, family byte, addresses []byte) []byte {
+	header := append([]byte{}, signatureV2...)
+	header = append(header, 0x20|command, family<<4|0x1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	t.Run("Reads IPv4 addresses and skips TLVs", func(t *testing.T) {
+		addresses := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+		// a PP2_TYPE_AUTHORITY TLV
+		addresses = append(addresses, 0x02, 0x00, 0x03, 'a', 'b', 'c')
+		r := bufio.NewReader(strings.NewReader(string(headerV2(commandProxy, familyInet, addresses)) + "GET"))
+		header, err := ReadHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, 2, header.Version)
+		assert.Equal(t, "192.0.2.1:56324", header.Source.String())
+		assert.Equal(t, "198.51.100.1:443", header.Destination.String())
+		rest, _ := io.ReadAll(r)
+		assert.Equal(t, "GET", string(rest))
+	})
+
+	t.Run("Reads IPv6 and unix addresses", func(t *testing.T) {
+		src, dst := netip.MustParseAddr("2001:db8::1").As16(), netip.MustParseAddr("2001:db8::2").As16()
+		addresses := append(append(src[:], dst[:]...), 0x0f, 0xa0, 0x00, 0x50)
+		header, err := ReadHeader(bufio.NewReader(strings.NewReader(string(headerV2(commandProxy, familyInet6, addresses)))))
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::1]:4000", header.Source.String())
+		assert.Equal(t, "[2001:db8::2]:80", header.Destination.String())
+
+		addresses = make([]byte, 216)
+		copy(addresses, "/run/client.sock")
+		copy(addresses[108:], "/run/server.sock")
+		header, err = ReadHeader(bufio.NewReader(strings.NewReader(string(headerV2(commandProxy, familyUnix, addresses)))))
+		require.NoError(t, err)
+		assert.Equal(t, "/run/client.sock", header.Source.String())
+		assert.Equal(t, "/run/server.sock", header.Destination.String())
+	})
+
+	t.Run("Passes no addresses for LOCAL", func(t *testing.T) {
+		header, err := ReadHeader(bufio.NewReader(strings.NewReader(string(headerV2(commandLocal, familyUnspec, nil)))))
+		require.NoError(t, err)
+		assert.Nil(t, header.Source)
+	})
+
+	t.Run("Rejects malformed headers", func(t *testing.T) {
+		wrongVersion := headerV2(commandProxy, familyInet, make([]byte, 12))
+		wrongVersion[12] = 0x11
+		for _, raw := range [][]byte{
+			wrongVersion,
+			headerV2(commandProxy, familyInet, make([]byte, 8)),
+			headerV2(0x2, familyInet, make([]byte, 12)),
+			headerV2(commandProxy, familyInet, make([]byte, 12))[:20],
+		} {
+			_, err := ReadHeader(bufio.NewReader(strings.NewReader(string(raw))))
+			assert.Error(t, err, "%x", raw)
+		}
+	})
+}
+
+func TestListener(t *testing.T) {
+	listen := func(t *testing.T, network string, address string, trusted string) *Listener {
+		parsed, err := ParseTrusted(trusted)
+		require.NoError(t, err)
+		inner, err := net.Listen(network, address)
+		require.NoError(t, err)
+		listener := NewListener(inner, parsed)
+		t.Cleanup(func() { listener.Close() })
+		return listener
+	}
+	acceptFrom := func(t *testing.T, listener *Listener, sent string) net.Conn {
+		client, err := net.Dial(listener.Addr().Network(), listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		_, err = io.WriteString(client, sent)
+		require.NoError(t, err)
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	accept := func(t *testing.T, trusted string, sent string) net.Conn {
+		return acceptFrom(t, listen(t, "tcp", "127.0.0.1:0", trusted), sent)
+	}
+
+	t.Run("Reports the addresses sent by a trusted proxy", func(t *testing.T) {
+		conn := accept(t, "10.0.0.0/8, 127.0.0.1", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello")
+		assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+		assert.Equal(t, "198.51.100.1:443", conn.LocalAddr().String())
+		data := make([]byte, 5)
+		_, err := io.ReadFull(conn, data)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("Fails reads when a trusted proxy sends no header", func(t *testing.T) {
+		conn := accept(t, "127.0.0.0/8", "GET / HTTP/1.1\r\n")
+		_, err := conn.Read(make([]byte, 10))
+		assert.ErrorContains(t, err, "PROXY protocol header")
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	})
+
+	t.Run("Does not believe untrusted peers", func(t *testing.T) {
+		sent := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+		conn := accept(t, "10.0.0.0/8", sent)
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+		data := make([]byte, len(sent))
+		_, err := io.ReadFull(conn, data)
+		require.NoError(t, err)
+		assert.Equal(t, sent, string(data))
+	})
+
+	t.Run("Trusts peers of a unix socket only when told to", func(t *testing.T) {
+		sent := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+		conn := acceptFrom(t, listen(t, "unix", filepath.Join(t.TempDir(), "trusted.sock"), "10.0.0.0/8, unix"), sent)
+		assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+
+		conn = acceptFrom(t, listen(t, "unix", filepath.Join(t.TempDir(), "untrusted.sock"), "10.0.0.0/8"), sent)
+		data := make([]byte, len(sent))
+		_, err := io.ReadFull(conn, data)
+		require.NoError(t, err)
+		assert.Equal(t, sent, string(data))
+	})
+
+	t.Run("Gives up on a trusted proxy that sends no header in time", func(t *testing.T) {
+		listener := listen(t, "tcp", "127.0.0.1:0", "127.0.0.1")
+		listener.HeaderTimeout = 20 * time.Millisecond
+		conn := acceptFrom(t, listener, "")
+		_, err := conn.Read(make([]byte, 10))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("Keeps the read deadline of the caller once the header is read", func(t *testing.T) {
+		listener := listen(t, "tcp", "127.0.0.1:0", "127.0.0.1")
+		listener.HeaderTimeout = 20 * time.Millisecond
+		conn := acceptFrom(t, listener, "PROXY UNKNOWN\r\n")
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err := conn.(*Conn).Header()
+		require.NoError(t, err)
+
+		// the header timeout no longer applies
+		time.Sleep(40 * time.Millisecond)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			conn.(*Conn).Conn.(*net.TCPConn).CloseRead()
+		}()
+		_, err = conn.Read(make([]byte, 2))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Rejects invalid trusted proxies", func(t *testing.T) {
+		_, err := ParseTrusted("10.0.0.0/33")
+		assert.Error(t, err)
+		_, err = ParseTrusted("proxy.example.com")
+		assert.Error(t, err)
+	})
+}
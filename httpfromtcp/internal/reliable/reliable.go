@@ -0,0 +1,534 @@
+package reliable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/datagram"
+	"net"
+	"sync"
+	"time"
+)
+
+// fragmentHeaderSize prefixes the payload of every data packet with the
+// fragment index and the number of fragments of its message, 2 bytes each.
+const fragmentHeaderSize = 4
+
+// MaxFragmentSize is the part of a message carried by one datagram.
+const MaxFragmentSize = datagram.MaxPayloadSize - fragmentHeaderSize
+
+// sackBits is the number of sequence numbers past the cumulative ack that an
+// ack reports on individually.
+const sackBits = 32
+
+var (
+	ErrPeerUnreachable = errors.New("peer did not acknowledge after the maximum number of retransmissions")
+	ErrMessageTooLarge = errors.New("message exceeds the maximum message size")
+	ErrListenerClosed  = errors.New("listener closed")
+	ErrIdleTimeout     = errors.New("peer sent nothing within the idle timeout")
+)
+
+type Config struct {
+	// Window is the number of datagrams that may be in flight unacknowledged
+	Window int
+	// InitialRTO is the first retransmission timeout, it doubles on every
+	// retransmission of the same datagram up to MaxRTO
+	InitialRTO time.Duration
+	MaxRTO     time.Duration
+	// MaxRetries is the number of retransmissions of one datagram before
+	// the peer is considered gone
+	MaxRetries int
+	// MaxMessageSize bounds the messages sent and those reassembled from
+	// the peer, larger incoming ones are dropped
+	MaxMessageSize int
+	// IdleTimeout closes a Conn handed out by a Listener once its peer has
+	// sent nothing for that long, zero keeps it until it is closed
+	IdleTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Window:         32,
+		InitialRTO:     200 * time.Millisecond,
+		MaxRTO:         5 * time.Second,
+		MaxRetries:     8,
+		MaxMessageSize: 1 << 20,
+		IdleTimeout:    2 * time.Minute,
+	}
+}
+
+type Stats struct {
+	Sent            int
+	Retransmissions int
+	Duplicates      int
+	Delivered       int
+}
+
+// segment is a data datagram waiting to be acknowledged
+type segment struct {
+	data     []byte
+	deadline time.Time
+	rto      time.Duration
+	retries  int
+}
+
+// Conn delivers whole messages to a single peer over an unreliable packet
+// connection, in the order they were sent and without duplicates. Messages
+// larger than a datagram are split into fragments and reassembled.
+type Conn struct {
+	packetConn net.PacketConn
+	peer       net.Addr
+	config     Config
+	// ownsPacketConn is set when the Conn reads from packetConn itself and
+	// closes it along with the Conn
+	ownsPacketConn bool
+	// release is called once the Conn stops, to let a Listener forget it
+	release func()
+	// idleTimeout is the IdleTimeout of the config for Conns of a Listener
+	idleTimeout time.Duration
+
+	// sendMu keeps the fragments of a message together, Send waits for
+	// the window with mu released
+	sendMu sync.Mutex
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	err  error
+
+	nextSequence  uint32
+	nextMessageID uint32
+	inFlight      map[uint32]*segment
+
+	expected  uint32
+	received  map[uint32]datagram.Packet
+	fragments [][]byte
+	// fragmentsSize is the size of the message reassembled so far
+	fragmentsSize int
+	messages      [][]byte
+	lastReceived  time.Time
+
+	stats    Stats
+	quitChan chan struct{}
+}
+
+// NewConn exchanges messages with peer over packetConn, which the Conn
+// takes over: datagrams from other addresses are ignored and packetConn is
+// closed by Close.
+func NewConn(packetConn net.PacketConn, peer net.Addr, config Config) *Conn {
+	c := newConn(packetConn, peer, config)
+	c.ownsPacketConn = true
+	go c.readLoop()
+	go c.retransmitLoop()
+	return c
+}
+
+func newConn(packetConn net.PacketConn, peer net.Addr, config Config) *Conn {
+	c := &Conn{
+		packetConn:    packetConn,
+		peer:          peer,
+		config:        config,
+		nextSequence:  1,
+		nextMessageID: 1,
+		inFlight:      make(map[uint32]*segment),
+		expected:      1,
+		received:      make(map[uint32]datagram.Packet),
+		lastReceived:  time.Now(),
+		quitChan:      make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Send queues msg, blocking while the window is full. It returns once every
+// fragment has been sent at least once, not when they are acknowledged.
+func (c *Conn) Send(msg []byte) error {
+	if len(msg) > c.config.MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	count := max((len(msg)+MaxFragmentSize-1)/MaxFragmentSize, 1)
+	if count > 0xffff {
+		return ErrMessageTooLarge
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	messageID := c.nextMessageID
+	c.nextMessageID++
+
+	for index := range count {
+		chunk := msg[index*MaxFragmentSize : min((index+1)*MaxFragmentSize, len(msg))]
+		for c.err == nil && len(c.inFlight) >= c.config.Window {
+			c.cond.Wait()
+		}
+		if c.err != nil {
+			return c.err
+		}
+
+		payload := make([]byte, fragmentHeaderSize+len(chunk))
+		binary.BigEndian.PutUint16(payload[0:2], uint16(index))
+		binary.BigEndian.PutUint16(payload[2:4], uint16(count))
+		copy(payload[fragmentHeaderSize:], chunk)
+		packet := datagram.Packet{Type: datagram.TypeData, Sequence: c.nextSequence, MessageID: messageID, Payload: payload}
+		data, err := packet.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		c.inFlight[packet.Sequence] = &segment{data: data, rto: c.config.InitialRTO, deadline: time.Now().Add(c.config.InitialRTO)}
+		c.nextSequence++
+		c.stats.Sent++
+		// a datagram lost on the way out is recovered by retransmission
+		c.packetConn.WriteTo(data, c.peer)
+	}
+	return nil
+}
+
+// Flush blocks until every message sent has been acknowledged.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.err == nil && len(c.inFlight) > 0 {
+		c.cond.Wait()
+	}
+	return c.err
+}
+
+// Receive returns the next message.
+func (c *Conn) Receive() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.err == nil && len(c.messages) == 0 {
+		c.cond.Wait()
+	}
+	if len(c.messages) > 0 {
+		msg := c.messages[0]
+		c.messages = c.messages[1:]
+		return msg, nil
+	}
+	return nil, c.err
+}
+
+func (c *Conn) Close() error {
+	c.fail(net.ErrClosed)
+	if c.ownsPacketConn {
+		return c.packetConn.Close()
+	}
+	return nil
+}
+
+// fail stops the connection, every blocked and later call returns err.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	close(c.quitChan)
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	if c.release != nil {
+		c.release()
+	}
+}
+
+func (c *Conn) readLoop() {
+	buffer := make([]byte, datagram.HeaderSize+datagram.MaxPayloadSize)
+	for {
+		n, addr, err := c.packetConn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				c.fail(net.ErrClosed)
+				return
+			}
+			continue
+		}
+		if addr.String() != c.peer.String() {
+			continue
+		}
+		packet, err := datagram.Unmarshal(buffer[:n])
+		if err != nil {
+			continue
+		}
+		c.handle(packet)
+	}
+}
+
+// handle processes a datagram received from the peer.
+func (c *Conn) handle(packet datagram.Packet) {
+	c.mu.Lock()
+	c.lastReceived = time.Now()
+	c.mu.Unlock()
+	switch packet.Type {
+	case datagram.TypeAck:
+		c.handleAck(packet)
+	case datagram.TypeData:
+		c.handleData(packet)
+	}
+}
+
+// handleAck drops every datagram up to the cumulative ack in Sequence along
+// with those flagged in the selective ack bitmap carried in the payload.
+func (c *Conn) handleAck(packet datagram.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cumulative := packet.Sequence
+	for sequence := range c.inFlight {
+		if sequence <= cumulative {
+			delete(c.inFlight, sequence)
+		}
+	}
+	if len(packet.Payload) == 4 {
+		bitmap := binary.BigEndian.Uint32(packet.Payload)
+		for i := range uint32(sackBits) {
+			if bitmap&(1<<i) != 0 {
+				delete(c.inFlight, cumulative+2+i)
+			}
+		}
+	}
+	c.cond.Broadcast()
+}
+
+func (c *Conn) handleData(packet datagram.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sequence := packet.Sequence
+	_, buffered := c.received[sequence]
+	switch {
+	case sequence < c.expected || buffered:
+		c.stats.Duplicates++
+	case sequence >= c.expected+uint32(4*c.config.Window):
+		// too far ahead to buffer, the sender retransmits it later
+		return
+	case len(packet.Payload) < fragmentHeaderSize:
+		return
+	default:
+		c.received[sequence] = packet
+	}
+
+	for {
+		next, ok := c.received[c.expected]
+		if !ok {
+			break
+		}
+		delete(c.received, c.expected)
+		c.expected++
+		c.reassemble(next)
+	}
+	c.sendAck()
+}
+
+// reassemble adds an in order fragment to the message being rebuilt.
+func (c *Conn) reassemble(packet datagram.Packet) {
+	index := int(binary.BigEndian.Uint16(packet.Payload[0:2]))
+	count := int(binary.BigEndian.Uint16(packet.Payload[2:4]))
+	if index != len(c.fragments) {
+		// fragments arrive in order, anything else means the sender broke
+		// the protocol so the partial message is abandoned
+		c.fragments, c.fragmentsSize = nil, 0
+		if index != 0 {
+			return
+		}
+	}
+	fragment := packet.Payload[fragmentHeaderSize:]
+	if c.fragmentsSize+len(fragment) > c.config.MaxMessageSize {
+		// the rest of an oversized message is dropped as out of order
+		c.fragments, c.fragmentsSize = nil, 0
+		return
+	}
+	c.fragments = append(c.fragments, fragment)
+	c.fragmentsSize += len(fragment)
+	if len(c.fragments) < count {
+		return
+	}
+	msg := make([]byte, 0, c.fragmentsSize)
+	for _, fragment := range c.fragments {
+		msg = append(msg, fragment...)
+	}
+	c.fragments, c.fragmentsSize = nil, 0
+	c.messages = append(c.messages, msg)
+	c.stats.Delivered++
+	c.cond.Broadcast()
+}
+
+// sendAck reports everything received so far, it is called with mu held.
+func (c *Conn) sendAck() {
+	var bitmap uint32
+	for i := range uint32(sackBits) {
+		if _, ok := c.received[c.expected+1+i]; ok {
+			bitmap |= 1 << i
+		}
+	}
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, bitmap)
+	ack, err := datagram.Packet{Type: datagram.TypeAck, Sequence: c.expected - 1, Payload: payload}.MarshalBinary()
+	if err != nil {
+		return
+	}
+	c.packetConn.WriteTo(ack, c.peer)
+}
+
+func (c *Conn) retransmitLoop() {
+	interval := max(min(c.config.InitialRTO/4, 10*time.Millisecond), time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quitChan:
+			return
+		case now := <-ticker.C:
+			if err := c.retransmit(now); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Conn) retransmit(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idleTimeout > 0 && now.Sub(c.lastReceived) > c.idleTimeout {
+		return fmt.Errorf("nothing received from %s for %s: %w", c.peer, c.idleTimeout, ErrIdleTimeout)
+	}
+	for sequence, seg := range c.inFlight {
+		if now.Before(seg.deadline) {
+			continue
+		}
+		if seg.retries >= c.config.MaxRetries {
+			return fmt.Errorf("datagram %d to %s: %w", sequence, c.peer, ErrPeerUnreachable)
+		}
+		seg.retries++
+		seg.rto = min(seg.rto*2, c.config.MaxRTO)
+		seg.deadline = now.Add(seg.rto)
+		c.stats.Retransmissions++
+		c.packetConn.WriteTo(seg.data, c.peer)
+	}
+	return nil
+}
+
+// acceptBacklog bounds the peers waiting to be accepted, datagrams from new
+// peers are dropped while it is full
+const acceptBacklog = 16
+
+// Listener hands out a Conn for every peer sending to packetConn.
+type Listener struct {
+	packetConn net.PacketConn
+	config     Config
+
+	mu         sync.Mutex
+	conns      map[string]*Conn
+	acceptChan chan *Conn
+	quitChan   chan struct{}
+	closeOnce  sync.Once
+}
+
+func Listen(packetConn net.PacketConn, config Config) *Listener {
+	l := &Listener{
+		packetConn: packetConn,
+		config:     config,
+		conns:      make(map[string]*Conn),
+		acceptChan: make(chan *Conn, acceptBacklog),
+		quitChan:   make(chan struct{}),
+	}
+	go l.readLoop()
+	return l
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.packetConn.LocalAddr()
+}
+
+// Accept waits for a datagram from a new peer.
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case conn := <-l.acceptChan:
+		return conn, nil
+	case <-l.quitChan:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops every Conn of the listener and closes packetConn.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.quitChan) })
+	l.mu.Lock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for _, conn := range l.conns {
+		conns = append(conns, conn)
+	}
+	l.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return l.packetConn.Close()
+}
+
+func (l *Listener) readLoop() {
+	buffer := make([]byte, datagram.HeaderSize+datagram.MaxPayloadSize)
+	for {
+		n, addr, err := l.packetConn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				l.closeOnce.Do(func() { close(l.quitChan) })
+				return
+			}
+			continue
+		}
+		packet, err := datagram.Unmarshal(buffer[:n])
+		if err != nil {
+			continue
+		}
+		if conn := l.conn(addr, packet); conn != nil {
+			conn.handle(packet)
+		}
+	}
+}
+
+// conn finds the Conn of addr, creating it when a peer starts sending data.
+func (l *Listener) conn(addr net.Addr, packet datagram.Packet) *Conn {
+	key := addr.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if conn, ok := l.conns[key]; ok {
+		return conn
+	}
+	if packet.Type != datagram.TypeData {
+		return nil
+	}
+	// the conn is complete before it is handed out, as the accepting
+	// goroutine may close it right away
+	conn := newConn(l.packetConn, addr, l.config)
+	// peers that go quiet or spoofed their address are forgotten
+	conn.idleTimeout = l.config.IdleTimeout
+	conn.release = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.conns[key] == conn {
+			delete(l.conns, key)
+		}
+	}
+	l.conns[key] = conn
+	select {
+	case l.acceptChan <- conn:
+	default:
+		// the backlog is full, the peer is picked up when it retransmits
+		delete(l.conns, key)
+		return nil
+	}
+	go conn.retransmitLoop()
+	return conn
+}
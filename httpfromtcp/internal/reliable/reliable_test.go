@@ -0,0 +1,333 @@
+package reliable
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn(t *testing.T) {
+	config := DefaultConfig()
+	config.InitialRTO = 5 * time.Millisecond
+	config.MaxRTO = 50 * time.Millisecond
+	config.MaxRetries = 20
+
+	t.Run("Delivers messages in order", func(t *testing.T) {
+		a, b := connPair(t, &fakeNetwork{}, config)
+		for i := range 10 {
+			require.NoError(t, a.Send([]byte(fmt.Sprintf("message %d", i))))
+		}
+		for i := range 10 {
+			msg, err := b.Receive()
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("message %d", i), string(msg))
+		}
+		require.NoError(t, a.Flush())
+		assert.Equal(t, 0, a.Stats().Retransmissions)
+	})
+
+	t.Run("Fragments and reassembles large messages", func(t *testing.T) {
+		a, b := connPair(t, &fakeNetwork{}, config)
+		large := bytes.Repeat([]byte("0123456789"), MaxFragmentSize/2)
+		require.NoError(t, a.Send(large))
+		require.NoError(t, a.Send(nil))
+
+		msg, err := b.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, large, msg)
+		assert.Equal(t, 6, a.Stats().Sent)
+
+		empty, err := b.Receive()
+		require.NoError(t, err)
+		assert.Empty(t, empty)
+	})
+
+	t.Run("Keeps the fragments of concurrent sends together", func(t *testing.T) {
+		windowed := config
+		windowed.Window = 2
+		a, b := connPair(t, &fakeNetwork{}, windowed)
+
+		var wg sync.WaitGroup
+		for i := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, a.Send(bytes.Repeat([]byte{byte(i)}, 3*MaxFragmentSize)))
+			}()
+		}
+		received := map[byte]int{}
+		for range 4 {
+			msg, err := b.Receive()
+			require.NoError(t, err)
+			require.Len(t, msg, 3*MaxFragmentSize)
+			assert.Equal(t, bytes.Repeat(msg[:1], len(msg)), msg)
+			received[msg[0]]++
+		}
+		wg.Wait()
+		assert.Equal(t, map[byte]int{0: 1, 1: 1, 2: 1, 3: 1}, received)
+	})
+
+	t.Run("Survives loss, duplication and reordering in both directions", func(t *testing.T) {
+		network := &fakeNetwork{rng: rand.New(rand.NewPCG(7, 11)), loss: 0.2, duplicate: 0.1, reorder: 0.2}
+		a, b := connPair(t, network, config)
+
+		messages := make([][]byte, 100)
+		for i := range messages {
+			size := 20
+			if i%10 == 0 {
+				size = 3 * MaxFragmentSize
+			}
+			messages[i] = bytes.Repeat([]byte{byte(i)}, size)
+		}
+
+		var wg sync.WaitGroup
+		for _, pair := range [][2]*Conn{{a, b}, {b, a}} {
+			sender, receiver := pair[0], pair[1]
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for _, msg := range messages {
+					assert.NoError(t, sender.Send(msg))
+				}
+				assert.NoError(t, sender.Flush())
+			}()
+			go func() {
+				defer wg.Done()
+				for i := range messages {
+					msg, err := receiver.Receive()
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, messages[i], msg, "message %d", i)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Greater(t, a.Stats().Retransmissions, 0)
+		assert.Greater(t, a.Stats().Duplicates+b.Stats().Duplicates, 0)
+		assert.Equal(t, len(messages), b.Stats().Delivered)
+	})
+
+	t.Run("Limits the datagrams in flight to the window", func(t *testing.T) {
+		windowed := config
+		windowed.Window = 4
+		windowed.MaxRetries = 2
+		network := &fakeNetwork{rng: rand.New(rand.NewPCG(1, 1)), loss: 1}
+		a, _ := connPair(t, network, windowed)
+
+		for range 4 {
+			require.NoError(t, a.Send([]byte("x")))
+		}
+		err := a.Send([]byte("blocked until the peer is given up on"))
+		assert.ErrorIs(t, err, ErrPeerUnreachable)
+		assert.Equal(t, 4, a.Stats().Sent)
+		_, err = a.Receive()
+		assert.ErrorIs(t, err, ErrPeerUnreachable)
+	})
+
+	t.Run("Rejects messages over the size limit", func(t *testing.T) {
+		small := config
+		small.MaxMessageSize = 10
+		a, _ := connPair(t, &fakeNetwork{}, small)
+		assert.ErrorIs(t, a.Send(make([]byte, 11)), ErrMessageTooLarge)
+	})
+
+	t.Run("Drops incoming messages over the size limit", func(t *testing.T) {
+		small := config
+		small.MaxMessageSize = MaxFragmentSize + 10
+		pa, pb := (&fakeNetwork{}).pair()
+		a := NewConn(pa, pb.addr, config)
+		b := NewConn(pb, pa.addr, small)
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		require.NoError(t, a.Send(make([]byte, 3*MaxFragmentSize)))
+		require.NoError(t, a.Send([]byte("fits")))
+		msg, err := b.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, "fits", string(msg))
+		assert.Equal(t, 1, b.Stats().Delivered)
+	})
+
+	t.Run("Close unblocks Receive", func(t *testing.T) {
+		a, _ := connPair(t, &fakeNetwork{}, config)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			a.Close()
+		}()
+		_, err := a.Receive()
+		assert.ErrorIs(t, err, net.ErrClosed)
+	})
+}
+
+func TestListener(t *testing.T) {
+	config := DefaultConfig()
+	config.InitialRTO = 5 * time.Millisecond
+
+	t.Run("Hands out a Conn per peer", func(t *testing.T) {
+		network := &fakeNetwork{rng: rand.New(rand.NewPCG(3, 5)), loss: 0.2}
+		pa, pb := network.pair()
+		listener := Listen(pb, config)
+		t.Cleanup(func() { listener.Close() })
+
+		client := NewConn(pa, pb.addr, config)
+		t.Cleanup(func() { client.Close() })
+		require.NoError(t, client.Send([]byte("ping")))
+
+		server, err := listener.Accept()
+		require.NoError(t, err)
+		assert.Equal(t, pa.addr, server.RemoteAddr())
+		msg, err := server.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(msg))
+
+		require.NoError(t, server.Send([]byte("pong")))
+		msg, err = client.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(msg))
+
+		require.NoError(t, listener.Close())
+		_, err = server.Receive()
+		assert.ErrorIs(t, err, net.ErrClosed)
+		_, err = listener.Accept()
+		assert.ErrorIs(t, err, ErrListenerClosed)
+	})
+
+	t.Run("Forgets peers that go quiet", func(t *testing.T) {
+		idle := config
+		idle.IdleTimeout = 30 * time.Millisecond
+		pa, pb := (&fakeNetwork{}).pair()
+		listener := Listen(pb, idle)
+		t.Cleanup(func() { listener.Close() })
+
+		client := NewConn(pa, pb.addr, config)
+		require.NoError(t, client.Send([]byte("ping")))
+		require.NoError(t, client.Flush())
+		client.Close()
+
+		server, err := listener.Accept()
+		require.NoError(t, err)
+		_, err = server.Receive()
+		require.NoError(t, err)
+		_, err = server.Receive()
+		assert.ErrorIs(t, err, ErrIdleTimeout)
+		assert.Eventually(t, func() bool {
+			listener.mu.Lock()
+			defer listener.mu.Unlock()
+			return len(listener.conns) == 0
+		}, time.Second, time.Millisecond)
+	})
+}
+
+func connPair(t *testing.T, network *fakeNetwork, config Config) (*Conn, *Conn) {
+	t.Helper()
+	pa, pb := network.pair()
+	a := NewConn(pa, pb.addr, config)
+	b := NewConn(pb, pa.addr, config)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// fakeNetwork connects fakePacketConns, losing, duplicating and delaying
+// datagrams with the given probabilities.
+type fakeNetwork struct {
+	mu        sync.Mutex
+	rng       *rand.Rand
+	loss      float64
+	duplicate float64
+	reorder   float64
+}
+
+func (n *fakeNetwork) chance(p float64) bool {
+	if p == 0 {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.rng.Float64() < p
+}
+
+func (n *fakeNetwork) pair() (*fakePacketConn, *fakePacketConn) {
+	a := &fakePacketConn{addr: fakeAddr("a"), network: n, inbox: make(chan fakeDatagram, 1024), closed: make(chan struct{})}
+	b := &fakePacketConn{addr: fakeAddr("b"), network: n, inbox: make(chan fakeDatagram, 1024), closed: make(chan struct{})}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "fake" }
+func (a fakeAddr) String() string  { return string(a) }
+
+type fakeDatagram struct {
+	data []byte
+	from net.Addr
+}
+
+type fakePacketConn struct {
+	addr      fakeAddr
+	network   *fakeNetwork
+	peer      *fakePacketConn
+	inbox     chan fakeDatagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *fakePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.inbox:
+		return copy(p, d.data), d.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *fakePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	d := fakeDatagram{data: append([]byte(nil), p...), from: c.addr}
+	if c.network.chance(c.network.loss) {
+		return len(p), nil
+	}
+	copies := 1
+	if c.network.chance(c.network.duplicate) {
+		copies = 2
+	}
+	for range copies {
+		if c.network.chance(c.network.reorder) {
+			time.AfterFunc(time.Duration(1+rand.IntN(5))*time.Millisecond, func() { c.peer.deliver(d) })
+		} else {
+			c.peer.deliver(d)
+		}
+	}
+	return len(p), nil
+}
+
+func (c *fakePacketConn) deliver(d fakeDatagram) {
+	select {
+	case c.inbox <- d:
+	default:
+		// a full inbox drops the datagram like a full socket buffer would
+	}
+}
+
+func (c *fakePacketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakePacketConn) LocalAddr() net.Addr                { return c.addr }
+func (c *fakePacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakePacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakePacketConn) SetWriteDeadline(t time.Time) error { return nil }
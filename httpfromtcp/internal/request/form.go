@@ -0,0 +1,170 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxFormSize limits how large an URL-encoded form body may be.
+const DefaultMaxFormSize = 10 << 20
+
+const FormContentType = "application/x-www-form-urlencoded"
+
+// Values maps a key to every value it was given, in order of appearance.
+type Values map[string][]string
+
+// Get returns the first value of the key or "" if it is missing.
+func (v Values) Get(key string) string {
+	values := v[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (v Values) Add(key string, value string) {
+	v[key] = append(v[key], value)
+}
+
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// ParseQuery parses "key=value&other=value" pairs as used by both query
+// strings and URL-encoded form bodies. A key without "=" gets an empty value.
+func ParseQuery(query string) (Values, error) {
+	values := make(Values)
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := Unescape(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %v", rawKey, err)
+		}
+		value, err := Unescape(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for key '%s': %v", rawValue, key, err)
+		}
+		values.Add(key, value)
+	}
+	return values, nil
+}
+
+// Unescape decodes percent-encoded octets and turns '+' into a space as
+// form encoding requires.
+func Unescape(s string) (string, error) {
+	var builder strings.Builder
+	builder.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '+':
+			builder.WriteByte(' ')
+		case '%':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("truncated percent-encoding '%s'", s[i:])
+			}
+			high, okHigh := fromHex(s[i+1])
+			low, okLow := fromHex(s[i+2])
+			if !okHigh || !okLow {
+				return "", fmt.Errorf("invalid percent-encoding '%s'", s[i:i+3])
+			}
+			builder.WriteByte(high<<4 | low)
+			i += 2
+		default:
+			builder.WriteByte(s[i])
+		}
+	}
+	return builder.String(), nil
+}
+
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// Path returns the path of the request target without the query string,
+// for absolute-form targets the scheme and authority are dropped as well.
+func (r *Request) Path() string {
+	path, _, _ := strings.Cut(r.OriginForm(), "?")
+	return path
+}
+
+// RawQuery returns the undecoded query string of the request target.
+func (r *Request) RawQuery() string {
+	_, query, _ := strings.Cut(r.OriginForm(), "?")
+	return query
+}
+
+// Query parses the query string of the request target.
+func (r *Request) Query() (Values, error) {
+	values, err := ParseQuery(r.RawQuery())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %v", err)
+	}
+	return values, nil
+}
+
+// Form parses an application/x-www-form-urlencoded body. Requests with a
+// different Content-Type yield an empty set of values.
+func (r *Request) Form(maxFormSize int) (Values, error) {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), FormContentType) {
+		return make(Values), nil
+	}
+	if len(r.Body) > maxFormSize {
+		return nil, fmt.Errorf("failed to parse form: body of %d bytes exceeds limit of %d", len(r.Body), maxFormSize)
+	}
+	values, err := ParseQuery(string(r.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse form: %v", err)
+	}
+	return values, nil
+}
+
+// OriginForm returns the path and query of the request target, stripping
+// the scheme and authority of an absolute-form target.
+func (r *Request) OriginForm() string {
+	target := r.RequestLine.RequestTarget
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(target, scheme) {
+			authorityAndPath := target[len(scheme):]
+			i := strings.IndexAny(authorityAndPath, "/?")
+			if i == -1 {
+				return "/"
+			}
+			if authorityAndPath[i] == '?' {
+				return "/" + authorityAndPath[i:]
+			}
+			return authorityAndPath[i:]
+		}
+	}
+	return target
+}
+
+// Authority returns the host and port named by an absolute-form or a
+// CONNECT authority-form target, and an empty string for origin-form ones.
+func (r *Request) Authority() string {
+	target := r.RequestLine.RequestTarget
+	if r.RequestLine.Method == "CONNECT" {
+		return target
+	}
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(target, scheme) {
+			authority, _, _ := strings.Cut(target[len(scheme):], "/")
+			authority, _, _ = strings.Cut(authority, "?")
+			return authority
+		}
+	}
+	return ""
+}
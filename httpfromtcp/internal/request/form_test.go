@@ -0,0 +1,96 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	t.Run("Parses multi valued query", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("GET /search?q=hello+world&tag=a&tag=b%26c&empty HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		query, err := r.Query()
+		require.NoError(t, err)
+		assert.Equal(t, "/search", r.Path())
+		assert.Equal(t, "hello world", query.Get("q"))
+		assert.Equal(t, []string{"a", "b&c"}, query["tag"])
+		assert.True(t, query.Has("empty"))
+		assert.Equal(t, "", query.Get("empty"))
+		assert.Equal(t, "", query.Get("missing"))
+	})
+
+	t.Run("Handles absolute form targets", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("GET http://localhost:42069?name=%C3%A9t%C3%A9 HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		query, err := r.Query()
+		require.NoError(t, err)
+		assert.Equal(t, "/", r.Path())
+		assert.Equal(t, "été", query.Get("name"))
+	})
+
+	t.Run("Rejects invalid percent-encoding", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("GET /search?q=%zz HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		_, err = r.Query()
+		require.Error(t, err)
+	})
+
+	t.Run("Rejects truncated percent-encoding", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseQuery("q=abc%4")
+		require.Error(t, err)
+	})
+}
+
+func TestForm(t *testing.T) {
+	t.Run("Parses URL-encoded body", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("POST /submit HTTP/1.1\r\n" + "Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" + "Content-Length: 33\r\n" + "\r\n" + "name=Lane+Wagner&lang=go&lang=zig"))
+		require.NoError(t, err)
+		form, err := r.Form(DefaultMaxFormSize)
+		require.NoError(t, err)
+		assert.Equal(t, "Lane Wagner", form.Get("name"))
+		assert.Equal(t, []string{"go", "zig"}, form["lang"])
+	})
+
+	t.Run("Ignores other content types", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("POST /submit HTTP/1.1\r\n" + "Content-Type: application/json\r\n" + "Content-Length: 2\r\n" + "\r\n" + "{}"))
+		require.NoError(t, err)
+		form, err := r.Form(DefaultMaxFormSize)
+		require.NoError(t, err)
+		assert.Empty(t, form)
+	})
+
+	t.Run("Enforces the max form size", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("POST /submit HTTP/1.1\r\n" + "Content-Type: application/x-www-form-urlencoded\r\n" + "Content-Length: 9\r\n" + "\r\n" + "name=Lane"))
+		require.NoError(t, err)
+		_, err = r.Form(8)
+		require.Error(t, err)
+	})
+}
+
+func TestAuthority(t *testing.T) {
+	for _, tc := range []struct {
+		method    string
+		target    string
+		authority string
+		origin    string
+	}{
+		{"GET", "/items?page=2", "", "/items?page=2"},
+		{"GET", "http://example.com:8080/items?page=2", "example.com:8080", "/items?page=2"},
+		{"GET", "http://example.com?page=2", "example.com", "/?page=2"},
+		{"CONNECT", "example.com:443", "example.com:443", "example.com:443"},
+	} {
+		req := NewRequest(tc.method, tc.target, nil)
+		assert.Equal(t, tc.authority, req.Authority(), tc.target)
+		assert.Equal(t, tc.origin, req.OriginForm(), tc.target)
+	}
+}
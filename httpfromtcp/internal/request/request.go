@@ -20,55 +20,187 @@ const (
 
 const CRLFbytes = 2
 
+const (
+	ParseErrorRequestLine = "request_line"
+	ParseErrorHeaders     = "headers"
+	ParseErrorBody        = "body"
+	ParseErrorTooLarge    = "too_large"
+	ParseErrorIncomplete  = "incomplete"
+)
+
+// ParseError is returned when the bytes received do not form a valid
+// request, Kind names the part of the message that could not be parsed.
+type ParseError struct {
+	Kind string
+	// Offset is the position, counted from the first byte of the request,
+	// where the part that could not be parsed starts
+	Offset int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to process request: %v", e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var parseErrorKinds = map[int]string{
+	RequestStateReadingRequestLine: ParseErrorRequestLine,
+	RequestStateReadingHeaders:     ParseErrorHeaders,
+	RequestStateReadingBody:        ParseErrorBody,
+}
+
 type Request struct {
 	state       int
 	RequestLine requestline.RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	// RemoteAddr is the address of the client, set by the server
+	RemoteAddr string
+	// LocalAddr is the address the client connected to, set by the server
+	LocalAddr string
+	// bodyUntilEOF makes a request without Content-Length read its body
+	// until the reader is exhausted instead of treating it as empty
+	bodyUntilEOF bool
+	// streamBody is the Reader's StreamBody, asked once the headers are in
+	streamBody func(req *Request) bool
+	// streamedLength is the Content-Length of a body left on the connection
+	streamedLength int
+	// body streams a body left on the connection, Body stays empty then
+	body io.Reader
+}
+
+// BodyReader returns the body as a stream. It reads from the connection
+// when the Reader left the body there (see Reader.StreamBody) and from Body
+// otherwise. A streamed body can be read once, and only until the next
+// request is read from the connection.
+func (r *Request) BodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	return bytes.NewReader(r.Body)
 }
 
+// RequestFromReader parses a single request, a body without Content-Length
+// is read until the reader returns EOF.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := &Request{
-		state:       RequestStateReadingRequestLine,
-		RequestLine: requestline.NewRequestLine(),
-		Headers:     headers.NewHeaders(),
-		Body:        make([]byte, 0),
+	return NewReader(reader).readRequest(true)
+}
+
+// RequestFromConn parses a single request from a connection that stays open
+// while the response is written, so a request without Content-Length is
+// treated as having no body (RFC 9112 section 6.3).
+func RequestFromConn(reader io.Reader) (*Request, error) {
+	return NewReader(reader).readRequest(false)
+}
+
+// Reader parses consecutive requests from a persistent connection. Bytes
+// read past the end of one request are kept for the next one.
+type Reader struct {
+	// StreamBody is asked about every request with a non-empty
+	// Content-Length body once its headers are parsed. When it returns true
+	// the body is left on the connection for Request.BodyReader instead of
+	// being read into Body. Whatever the handler leaves unread is skipped
+	// when the next request is read.
+	StreamBody func(req *Request) bool
+
+	reader             io.Reader
+	buffer             []byte
+	validBytesInBuffer int
+	eof                bool
+	errRead            error
+	// pending is the streamed body of the last request
+	pending *bodyStream
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buffer: make([]byte, bufferSize),
 	}
-	buffer := make([]byte, bufferSize)
-	validBytesInBuffer := 0
+}
 
-	for request.state != RequestStateDone {
-		numOfBytesRead, errRead := reader.Read(buffer[validBytesInBuffer:])
+// ReadRequest parses the next request with the framing of RequestFromConn.
+// It returns io.EOF when the peer closed the connection between requests.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	return rr.readRequest(false)
+}
 
-		validBytesInBuffer += numOfBytesRead
+// Buffered returns the bytes read from the connection that are not part of
+// any request parsed so far.
+func (rr *Reader) Buffered() []byte {
+	return rr.buffer[:rr.validBytesInBuffer]
+}
 
-		if validBytesInBuffer > bufferSize-1 {
-			return &Request{}, fmt.Errorf("failed to process request: exceeded buffer size of %d", bufferSize)
+func (rr *Reader) readRequest(bodyUntilEOF bool) (*Request, error) {
+	if rr.pending != nil {
+		pending := rr.pending
+		rr.pending = nil
+		if _, err := io.Copy(io.Discard, pending); err != nil {
+			return &Request{}, fmt.Errorf("failed to skip unread body: %w", err)
 		}
+	}
 
-		isLastChunk := errRead == io.EOF
+	request := &Request{
+		state:        RequestStateReadingRequestLine,
+		RequestLine:  requestline.NewRequestLine(),
+		Headers:      headers.NewHeaders(),
+		Body:         make([]byte, 0),
+		bodyUntilEOF: bodyUntilEOF,
+		streamBody:   rr.StreamBody,
+	}
+	totalBytesParsed := 0
 
-		numOfBytesParsed, errParse := request.parse(buffer[:validBytesInBuffer], isLastChunk)
+	for {
+		// bytes left over from the previous request are parsed before
+		// reading, they may already hold a complete request
+		numOfBytesParsed, errParse := request.parse(rr.buffer[:rr.validBytesInBuffer], rr.eof)
 
 		if errParse != nil {
-			return &Request{}, fmt.Errorf("failed to process request: %v", errParse)
+			return &Request{}, &ParseError{Kind: parseErrorKinds[request.state], Offset: totalBytesParsed + numOfBytesParsed, Err: errParse}
 		}
 
-		if errRead != nil && errRead != io.EOF {
-			return &Request{}, fmt.Errorf("failed to process request: %v", errRead)
+		if numOfBytesParsed > 0 {
+			copy(rr.buffer, rr.buffer[numOfBytesParsed:rr.validBytesInBuffer])
+			rr.validBytesInBuffer -= numOfBytesParsed
+			totalBytesParsed += numOfBytesParsed
 		}
 
-		if numOfBytesParsed > 0 {
-			copy(buffer, buffer[numOfBytesParsed:validBytesInBuffer])
-			validBytesInBuffer -= numOfBytesParsed
+		if request.state == RequestStateDone {
+			if request.streamedLength > 0 {
+				rr.pending = &bodyStream{rr: rr, remaining: request.streamedLength}
+				request.body = rr.pending
+			}
+			return request, nil
 		}
-	}
 
-	if request.state != RequestStateDone {
-		return &Request{}, fmt.Errorf("incomplete HTTP request: reached EOF before request completed, request %+v", request)
-	}
+		if rr.errRead != nil {
+			return &Request{}, fmt.Errorf("failed to process request: %w", rr.errRead)
+		}
 
-	return request, nil
+		if rr.eof {
+			// the peer closed the connection without sending anything
+			if request.state == RequestStateReadingRequestLine && rr.validBytesInBuffer == 0 {
+				return &Request{}, io.EOF
+			}
+			return &Request{}, &ParseError{Kind: ParseErrorIncomplete, Offset: totalBytesParsed, Err: fmt.Errorf("incomplete HTTP request: reached EOF before request completed, request %+v", request)}
+		}
+
+		if rr.validBytesInBuffer > bufferSize-1 {
+			return &Request{}, &ParseError{Kind: ParseErrorTooLarge, Offset: totalBytesParsed, Err: fmt.Errorf("exceeded buffer size of %d", bufferSize)}
+		}
+
+		numOfBytesRead, errRead := rr.reader.Read(rr.buffer[rr.validBytesInBuffer:])
+		rr.validBytesInBuffer += numOfBytesRead
+
+		if errRead == io.EOF {
+			rr.eof = true
+		} else if errRead != nil {
+			rr.errRead = errRead
+		}
+	}
 }
 
 func (r *Request) parse(data []byte, isLastChunk bool) (int, error) {
@@ -88,7 +220,8 @@ func (r *Request) parse(data []byte, isLastChunk bool) (int, error) {
 		}
 
 		if err != nil {
-			return 0, fmt.Errorf("failed to parse data chunk, %v", err)
+			// the bytes parsed so far locate the error within the request
+			return numOfBytesParsed, fmt.Errorf("failed to parse data chunk, %v", err)
 		}
 
 		numOfBytesParsed += bytesConsumed
@@ -124,6 +257,7 @@ func (r *Request) parseHeader(data []byte) (int, error) {
 	// if length is 0 then we are reading the \r\n empty line which is the indicator of end of header
 	if len(line) == 0 {
 		r.state = RequestStateReadingBody
+		r.leaveBodyIfStreamed()
 		return lineEnd, nil
 	}
 	err := r.Headers.ParseLine(line)
@@ -133,12 +267,34 @@ func (r *Request) parseHeader(data []byte) (int, error) {
 	return lineEnd, nil
 }
 
-func (r *Request) parseBody(data []byte, isLastChunk bool) (int, error) {
-	r.Body = append(r.Body, data...)
+// leaveBodyIfStreamed ends parsing before the body when StreamBody asks
+// for it, the Reader then hands the body out as a stream. Bodies without a
+// valid Content-Length are parsed as usual, and fail there if invalid.
+func (r *Request) leaveBodyIfStreamed() {
+	if r.streamBody == nil {
+		return
+	}
+	headerContentLength, ok := r.Headers.Get("Content-Length")
+	if !ok {
+		return
+	}
+	contentLength, err := strconv.Atoi(headerContentLength)
+	if err != nil || contentLength <= 0 || !r.streamBody(r) {
+		return
+	}
+	r.streamedLength = contentLength
+	r.state = RequestStateDone
+}
 
+func (r *Request) parseBody(data []byte, isLastChunk bool) (int, error) {
 	headerContentLength, hasContentLength := r.Headers.Get("Content-Length")
 
 	if !hasContentLength {
+		if !r.bodyUntilEOF {
+			r.state = RequestStateDone
+			return 0, nil
+		}
+		r.Body = append(r.Body, data...)
 		if isLastChunk {
 			r.state = RequestStateDone
 		}
@@ -147,24 +303,61 @@ func (r *Request) parseBody(data []byte, isLastChunk bool) (int, error) {
 
 	contentLength, err := strconv.Atoi(headerContentLength)
 
-	if err != nil {
+	if err != nil || contentLength < 0 {
 		return 0, fmt.Errorf("failed to parse Conten-Length %s", headerContentLength)
 	}
 
-	if contentLength == 0 {
+	// anything past the declared length belongs to the next message
+	numOfBytesToConsume := min(len(data), contentLength-len(r.Body))
+	r.Body = append(r.Body, data[:numOfBytesToConsume]...)
+
+	if len(r.Body) == contentLength {
 		r.state = RequestStateDone
-		return len(data), nil
+		return numOfBytesToConsume, nil
 	}
 
-	if isLastChunk && len(r.Body) != contentLength {
+	if isLastChunk {
 		return 0, fmt.Errorf("received %d bytes of body when expected %d", len(r.Body), contentLength)
 	}
 
-	if len(r.Body) == contentLength {
-		r.state = RequestStateDone
-	}
+	return numOfBytesToConsume, nil
+}
 
-	return len(data), nil
+// bodyStream reads a body of known length left on the connection, starting
+// with the bytes the Reader already buffered.
+type bodyStream struct {
+	rr        *Reader
+	remaining int
+}
+
+func (b *bodyStream) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+	p = p[:min(len(p), b.remaining)]
+	rr := b.rr
+	if rr.validBytesInBuffer > 0 {
+		n := copy(p, rr.buffer[:rr.validBytesInBuffer])
+		copy(rr.buffer, rr.buffer[n:rr.validBytesInBuffer])
+		rr.validBytesInBuffer -= n
+		b.remaining -= n
+		return n, nil
+	}
+	if rr.errRead != nil {
+		return 0, rr.errRead
+	}
+	if rr.eof {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, err := rr.reader.Read(p)
+	b.remaining -= n
+	if err == io.EOF {
+		rr.eof = true
+	} else if err != nil {
+		rr.errRead = err
+	}
+	// errors surface on the next call, once the bytes read are consumed
+	return n, nil
 }
 
 func findNextCRLF(data []byte, start int) (lineEnd int, hasCompleteLine bool) {
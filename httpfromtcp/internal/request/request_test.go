@@ -180,6 +180,146 @@ func TestRequestLineParse(t *testing.T) {
 	})
 }
 
+func TestRequestFromConn(t *testing.T) {
+	t.Run("No content length means no body", func(t *testing.T) {
+		t.Parallel()
+		reader := &chunkReader{
+			data:              "GET / HTTP/1.1\r\n" + "Host: localhost:42069\r\n" + "\r\n" + "GET /next HTTP/1.1\r\n",
+			numOfBytesPerRead: 3,
+		}
+
+		r, err := RequestFromConn(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "/", r.RequestLine.RequestTarget)
+		assert.Equal(t, "", string(r.Body))
+	})
+
+	t.Run("Stops reading the body at the content length", func(t *testing.T) {
+		t.Parallel()
+		r, err := RequestFromConn(strings.NewReader("POST /submit HTTP/1.1\r\n" + "Content-Length: 5\r\n" + "\r\n" + "helloGET / HTTP/1.1\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	})
+
+	t.Run("Closed connection returns EOF", func(t *testing.T) {
+		t.Parallel()
+		_, err := RequestFromConn(strings.NewReader(""))
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Truncated request line is an error", func(t *testing.T) {
+		t.Parallel()
+		_, err := RequestFromConn(strings.NewReader("GET / HT"))
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, io.EOF)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, ParseErrorIncomplete, parseErr.Kind)
+	})
+
+	t.Run("Parse errors report the failing section", func(t *testing.T) {
+		t.Parallel()
+		_, err := RequestFromConn(strings.NewReader("GET / HTTP/1.1\r\nH@st: localhost\r\n\r\n"))
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, ParseErrorHeaders, parseErr.Kind)
+	})
+
+	t.Run("Parse errors report the offset of the failing line", func(t *testing.T) {
+		t.Parallel()
+		for _, bytesPerRead := range []int{1, 5, 4096} {
+			raw := "GET / HTTP/1.1\r\nHost: localhost\r\nBad Header: x\r\n\r\n"
+			_, err := RequestFromConn(NewChunkReader(raw, bytesPerRead))
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, strings.Index(raw, "Bad Header"), parseErr.Offset)
+		}
+	})
+}
+
+func TestReader(t *testing.T) {
+	t.Run("Parses consecutive requests keeping leftover bytes", func(t *testing.T) {
+		t.Parallel()
+		for _, bytesPerRead := range []int{1, 7, 4096} {
+			reader := NewReader(NewChunkReader("POST /one HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET /two HTTP/1.1\r\n\r\nGET /three", bytesPerRead))
+
+			first, err := reader.ReadRequest()
+			require.NoError(t, err)
+			assert.Equal(t, "/one", first.RequestLine.RequestTarget)
+			assert.Equal(t, "abc", string(first.Body))
+
+			second, err := reader.ReadRequest()
+			require.NoError(t, err)
+			assert.Equal(t, "/two", second.RequestLine.RequestTarget)
+			assert.Equal(t, "", string(second.Body))
+
+			_, err = reader.ReadRequest()
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, ParseErrorIncomplete, parseErr.Kind)
+		}
+	})
+
+	t.Run("Returns EOF between requests", func(t *testing.T) {
+		t.Parallel()
+		reader := NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+		_, err := reader.ReadRequest()
+		require.NoError(t, err)
+		_, err = reader.ReadRequest()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("Exposes buffered bytes", func(t *testing.T) {
+		t.Parallel()
+		reader := NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\nextra"))
+		_, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "extra", string(reader.Buffered()))
+	})
+
+	t.Run("Streams matching bodies and skips what is left unread", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("0123456789", 1000)
+		reader := NewReader(NewChunkReader(
+			"POST /upload HTTP/1.1\r\nContent-Length: 10000\r\n\r\n"+body+
+				"POST /upload HTTP/1.1\r\nContent-Length: 10000\r\n\r\n"+body+
+				"POST /small HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", 3))
+		reader.StreamBody = func(req *Request) bool {
+			return req.RequestLine.RequestTarget == "/upload"
+		}
+
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		streamed, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, body, string(streamed))
+
+		// the second body is never read
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/small", r.RequestLine.RequestTarget)
+		assert.Equal(t, "hello", string(r.Body))
+		read, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(read))
+	})
+
+	t.Run("Streamed body cut short is an unexpected EOF", func(t *testing.T) {
+		t.Parallel()
+		reader := NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nhalf"))
+		reader.StreamBody = func(req *Request) bool { return true }
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		_, err = io.ReadAll(r.BodyReader())
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
 type chunkReader struct {
 	data              string
 	numOfBytesPerRead int
@@ -0,0 +1,55 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/requestline"
+	"io"
+	"strconv"
+)
+
+// NewRequest builds an HTTP/1.1 request to send, setting Content-Length
+// when there is a body.
+func NewRequest(method string, target string, body []byte) *Request {
+	request := &Request{
+		state: RequestStateDone,
+		RequestLine: requestline.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: target,
+			Method:        method,
+		},
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+	if len(body) > 0 {
+		request.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return request
+}
+
+// Write serialises the request in the HTTP/1.1 wire format. Headers are
+// written as they are, so framing headers have to match the body.
+func (r *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	httpVersion := r.RequestLine.HttpVersion
+	if httpVersion == "" {
+		httpVersion = "1.1"
+	}
+	if _, err := fmt.Fprintf(bw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, httpVersion); err != nil {
+		return fmt.Errorf("failed to write request line: %w", err)
+	}
+	if err := r.Headers.Write(bw); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return fmt.Errorf("failed to write end of headers: %w", err)
+	}
+	if _, err := bw.Write(r.Body); err != nil {
+		return fmt.Errorf("failed to write body: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write request: %w", err)
+	}
+	return nil
+}
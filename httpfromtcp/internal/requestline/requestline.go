@@ -2,6 +2,7 @@ package requestline
 
 import (
 	"fmt"
+	"net"
 	"slices"
 	"strings"
 )
@@ -17,6 +18,15 @@ func NewRequestLine() RequestLine {
 }
 
 func (rl *RequestLine) ParseLine(line string) error {
+	// the HTTP/2 connection preface starts like a request line (RFC 9113
+	// section 3.4), the server hands such connections over to HTTP/2
+	if line == "PRI * HTTP/2.0" {
+		rl.HttpVersion = "2.0"
+		rl.RequestTarget = "*"
+		rl.Method = "PRI"
+		return nil
+	}
+
 	requestLineParts := strings.Split(line, " ")
 
 	if len(requestLineParts) != 3 {
@@ -37,7 +47,7 @@ func (rl *RequestLine) ParseLine(line string) error {
 		return fmt.Errorf("invalid http version: %v", err)
 	}
 
-	if err = validateRequestTarget(requestTarget); err != nil {
+	if err = validateRequestTarget(method, requestTarget); err != nil {
 		return fmt.Errorf("invalid request target: %v", err)
 	}
 
@@ -49,7 +59,7 @@ func (rl *RequestLine) ParseLine(line string) error {
 }
 
 func validateMethod(method string) error {
-	validMethods := []string{"GET", "POST", "PUT", "DELETE"}
+	validMethods := []string{"GET", "POST", "PUT", "DELETE", "CONNECT"}
 	if slices.Contains(validMethods, method) {
 		return nil
 	}
@@ -64,10 +74,18 @@ func validateHttpVersion(httpVersion string) (string, error) {
 	return "", fmt.Errorf("invalid http version, received: '%s', valid values are: %v", httpVersion, validHttpVersions)
 }
 
-func validateRequestTarget(target string) error {
+func validateRequestTarget(method string, target string) error {
 	if target == "" {
 		return fmt.Errorf("request target can not be empty")
 	}
+	// CONNECT is the only method using the authority-form, host:port
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" {
+			return fmt.Errorf("invalid request target '%s', CONNECT expects 'host:port'", target)
+		}
+		return nil
+	}
 	if !strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
 		return fmt.Errorf("invalid request target '%s', must start with '/', 'http://' or 'https://'", target)
 	}
@@ -0,0 +1,197 @@
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/chunked"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/statusline"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxLineLength bounds the status line and each header line
+const maxLineLength = 8192
+
+const (
+	ResponseStateReadingStatusLine = iota
+	ResponseStateReadingHeaders
+	ResponseStateReadingBody
+)
+
+// Response is a response received from a server. The head is parsed upfront
+// while the body is streamed from the connection through Body.
+type Response struct {
+	state      int
+	StatusLine statusline.StatusLine
+	Headers    headers.Headers
+	Body       io.Reader
+	// ContentLength is -1 when the body is chunked or delimited by the
+	// server closing the connection
+	ContentLength int64
+	// Close is set when the connection can not carry another request once
+	// the body has been read
+	Close bool
+}
+
+func (r *Response) StatusCode() StatusCode {
+	return StatusCode(r.StatusLine.StatusCode)
+}
+
+// Trailers returns the trailer fields of a chunked body, they are only
+// available once Body has been read to the end.
+func (r *Response) Trailers() headers.Headers {
+	if cr, ok := r.Body.(*chunked.Reader); ok {
+		return cr.Trailers
+	}
+	return headers.NewHeaders()
+}
+
+// ResponseFromReader parses the next response from reader. requestMethod is
+// the method of the request being answered, as responses to HEAD never have
+// a body. Interim 1xx responses other than 101 are skipped.
+func ResponseFromReader(reader *bufio.Reader, requestMethod string) (*Response, error) {
+	for {
+		response, err := readResponseHead(reader)
+		if err != nil {
+			return nil, err
+		}
+		code := response.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != int(StatusSwitchingProtocols) {
+			continue
+		}
+		if err := response.setBody(reader, requestMethod); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+}
+
+func readResponseHead(reader *bufio.Reader) (*Response, error) {
+	response := &Response{
+		state:      ResponseStateReadingStatusLine,
+		StatusLine: statusline.NewStatusLine(),
+		Headers:    headers.NewHeaders(),
+	}
+
+	for response.state != ResponseStateReadingBody {
+		line, err := readLine(reader)
+		if err != nil {
+			if err == io.EOF && response.state == ResponseStateReadingStatusLine {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+
+		switch response.state {
+		case ResponseStateReadingStatusLine:
+			if err := response.StatusLine.ParseLine(line); err != nil {
+				return nil, fmt.Errorf("failed to parse status line: %v", err)
+			}
+			response.state = ResponseStateReadingHeaders
+		case ResponseStateReadingHeaders:
+			// if length is 0 then we are reading the \r\n empty line which is the indicator of end of header
+			if len(line) == 0 {
+				response.state = ResponseStateReadingBody
+				continue
+			}
+			if err := response.Headers.ParseLine(line); err != nil {
+				return nil, fmt.Errorf("failed to parse header: %v", err)
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// setBody picks the message framing following RFC 9112 section 6.3.
+func (r *Response) setBody(reader *bufio.Reader, requestMethod string) error {
+	r.Close = r.Headers.HasToken("Connection", "close") ||
+		(r.StatusLine.HttpVersion == "1.0" && !r.Headers.HasToken("Connection", "keep-alive"))
+
+	code := r.StatusCode()
+	// a successful CONNECT turns the connection into a tunnel
+	tunnel := requestMethod == "CONNECT" && code >= 200 && code < 300
+	if requestMethod == "HEAD" || tunnel || code == StatusSwitchingProtocols || !code.AllowsBody() {
+		r.Body = strings.NewReader("")
+		r.ContentLength = 0
+		if tunnel || code == StatusSwitchingProtocols {
+			r.Close = true
+		}
+		return nil
+	}
+
+	if transferEncoding, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		codings := r.Headers.Values("Transfer-Encoding")
+		if len(codings) > 0 && strings.EqualFold(codings[len(codings)-1], "chunked") {
+			r.Body = chunked.NewReader(reader)
+			r.ContentLength = -1
+			return nil
+		}
+		// any other final coding means the body runs until the connection closes
+		if transferEncoding != "" {
+			r.Body = reader
+			r.ContentLength = -1
+			r.Close = true
+			return nil
+		}
+	}
+
+	if contentLength, ok := r.Headers.Get("Content-Length"); ok {
+		length, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid Content-Length '%s'", contentLength)
+		}
+		r.Body = &lengthReader{reader: reader, remaining: length}
+		r.ContentLength = length
+		return nil
+	}
+
+	r.Body = reader
+	r.ContentLength = -1
+	r.Close = true
+	return nil
+}
+
+// lengthReader reads exactly remaining bytes, reporting a connection that
+// closes early as io.ErrUnexpectedEOF.
+type lengthReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (lr *lengthReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.reader.Read(p)
+	lr.remaining -= int64(n)
+	if err == io.EOF && lr.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if lr.remaining == 0 && err == nil {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxLineLength {
+		return "", fmt.Errorf("line exceeds %d bytes", maxLineLength)
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(string(line), "\r\n") {
+		return "", fmt.Errorf("line '%s' is not terminated by CRLF", strings.TrimSuffix(string(line), "\n"))
+	}
+	return string(line[:len(line)-2]), nil
+}
@@ -0,0 +1,101 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	parse := func(raw string, method string) (*Response, string, error) {
+		resp, err := ResponseFromReader(bufio.NewReader(strings.NewReader(raw)), method)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := io.ReadAll(resp.Body)
+		return resp, string(body), err
+	}
+
+	t.Run("Reads a Content-Length body and leaves the rest", func(t *testing.T) {
+		reader := bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhelloHTTP/1.1 204 No Content\r\n\r\n"))
+		resp, err := ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, StatusOK, resp.StatusCode())
+		assert.Equal(t, "OK", resp.StatusLine.ReasonPhrase)
+		assert.Equal(t, int64(5), resp.ContentLength)
+		assert.False(t, resp.Close)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+
+		next, err := ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, StatusNoContent, next.StatusCode())
+	})
+
+	t.Run("Reads a chunked body with trailers", func(t *testing.T) {
+		resp, body, err := parse("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nChecksum: abc\r\n\r\n", "GET")
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", body)
+		assert.Equal(t, int64(-1), resp.ContentLength)
+		checksum, _ := resp.Trailers().Get("Checksum")
+		assert.Equal(t, "abc", checksum)
+	})
+
+	t.Run("Reads until close without framing", func(t *testing.T) {
+		resp, body, err := parse("HTTP/1.0 200 OK\r\n\r\nall of it", "GET")
+		require.NoError(t, err)
+		assert.Equal(t, "all of it", body)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("Has no body for HEAD, 204 and 304", func(t *testing.T) {
+		for _, tc := range []struct {
+			raw    string
+			method string
+		}{
+			{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "HEAD"},
+			{"HTTP/1.1 204 No Content\r\n\r\n", "GET"},
+			{"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n", "GET"},
+		} {
+			resp, body, err := parse(tc.raw, tc.method)
+			require.NoError(t, err)
+			assert.Equal(t, "", body)
+			assert.Equal(t, int64(0), resp.ContentLength)
+		}
+	})
+
+	t.Run("Skips interim responses", func(t *testing.T) {
+		resp, body, err := parse("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok", "POST")
+		require.NoError(t, err)
+		assert.Equal(t, StatusCreated, resp.StatusCode())
+		assert.Equal(t, "ok", body)
+	})
+
+	t.Run("Reports a body cut short", func(t *testing.T) {
+		_, _, err := parse("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", "GET")
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("Rejects malformed responses", func(t *testing.T) {
+		for _, raw := range []string{
+			"HTTP/2.0 200 OK\r\n\r\n",
+			"HTTP/1.1 20 OK\r\n\r\n",
+			"HTTP/1.1 200 OK\nContent-Length: 0\n\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n",
+		} {
+			_, _, err := parse(raw, "GET")
+			assert.Error(t, err, raw)
+		}
+	})
+
+	t.Run("Returns EOF on an empty stream", func(t *testing.T) {
+		_, _, err := parse("", "GET")
+		assert.Equal(t, io.EOF, err)
+	})
+}
@@ -0,0 +1,394 @@
+package response
+
+import (
+	"fmt"
+	"httpfromtcp/internal/chunked"
+	"httpfromtcp/internal/headers"
+	"io"
+	"net"
+	"strconv"
+)
+
+type StatusCode int
+
+const (
+	StatusContinue                    StatusCode = 100
+	StatusSwitchingProtocols          StatusCode = 101
+	StatusOK                          StatusCode = 200
+	StatusCreated                     StatusCode = 201
+	StatusAccepted                    StatusCode = 202
+	StatusNoContent                   StatusCode = 204
+	StatusMovedPermanently            StatusCode = 301
+	StatusFound                       StatusCode = 302
+	StatusNotModified                 StatusCode = 304
+	StatusTemporaryRedirect           StatusCode = 307
+	StatusPermanentRedirect           StatusCode = 308
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusRequestTimeout              StatusCode = 408
+	StatusLengthRequired              StatusCode = 411
+	StatusContentTooLarge             StatusCode = 413
+	StatusUnsupportedMediaType        StatusCode = 415
+	StatusUpgradeRequired             StatusCode = 426
+	StatusTooManyRequests             StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusInternalServerError         StatusCode = 500
+	StatusNotImplemented              StatusCode = 501
+	StatusBadGateway                  StatusCode = 502
+	StatusServiceUnavailable          StatusCode = 503
+	StatusGatewayTimeout              StatusCode = 504
+	StatusHTTPVersionNotSupported     StatusCode = 505
+)
+
+var reasonPhrases = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusOK:                          "OK",
+	StatusCreated:                     "Created",
+	StatusAccepted:                    "Accepted",
+	StatusNoContent:                   "No Content",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusLengthRequired:              "Length Required",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusUnsupportedMediaType:        "Unsupported Media Type",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalServerError:         "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
+func (sc StatusCode) ReasonPhrase() string {
+	return reasonPhrases[sc]
+}
+
+// AllowsBody reports whether a response with this status code may carry
+// content (RFC 9110 section 6.4.1).
+func (sc StatusCode) AllowsBody() bool {
+	return sc >= 200 && sc != StatusNoContent && sc != StatusNotModified
+}
+
+func GetDefaultHeaders(contentLen int) headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Content-Type", "text/plain")
+	return h
+}
+
+const (
+	WriterStateWritingStatusLine = iota
+	WriterStateWritingHeaders
+	WriterStateWritingBody
+	WriterStateDone
+)
+
+const CRLF = "\r\n"
+
+// Filter is called just before the headers are written. It may rewrite the
+// headers and return a writer wrapping body that everything the handler
+// writes afterwards passes through, or nil to leave the body untouched.
+type Filter func(statusCode StatusCode, h headers.Headers, body io.Writer) io.WriteCloser
+
+// Transport carries responses over a protocol other than HTTP/1.1. The
+// Writer hands it the status and the filtered headers at once, then the
+// body as it is written, unframed, and calls Close at its end.
+type Transport interface {
+	WriteHead(statusCode StatusCode, h headers.Headers) error
+	io.Writer
+	Close() error
+}
+
+type Writer struct {
+	state        int
+	writer       io.Writer
+	transport    Transport
+	statusCode   StatusCode
+	headers      headers.Headers
+	filters      []Filter
+	body         io.Writer
+	bodyClosers  []io.Closer
+	chunkWriter  *chunked.Writer
+	bytesWritten int
+	aborted      bool
+	hijacked     bool
+	// readBuffer returns the bytes read from the connection that the server
+	// has not consumed, handed over by Hijack
+	readBuffer func() []byte
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{
+		state:  WriterStateWritingStatusLine,
+		writer: writer,
+	}
+}
+
+// NewTransportWriter writes the response through transport instead of in
+// the HTTP/1.1 wire format.
+func NewTransportWriter(transport Transport) *Writer {
+	return &Writer{
+		state:     WriterStateWritingStatusLine,
+		writer:    transport,
+		transport: transport,
+	}
+}
+
+// AddFilter registers a filter, it has to be called before WriteHeaders.
+// Filters added later wrap the ones added before them.
+func (w *Writer) AddFilter(filter Filter) {
+	w.filters = append(w.filters, filter)
+}
+
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// Headers returns the headers as they were sent, after filters were applied.
+func (w *Writer) Headers() headers.Headers {
+	return w.headers
+}
+
+// BytesWritten is the number of body bytes written to the connection,
+// excluding chunk framing.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.state != WriterStateWritingStatusLine {
+		return fmt.Errorf("failed to write status line: status line has already been written")
+	}
+	if w.transport != nil {
+		// the status goes out along with the headers
+		w.statusCode = statusCode
+		w.state = WriterStateWritingHeaders
+		return nil
+	}
+	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s%s", statusCode, statusCode.ReasonPhrase(), CRLF)
+	if err != nil {
+		return fmt.Errorf("failed to write status line: %v", err)
+	}
+	w.statusCode = statusCode
+	w.state = WriterStateWritingHeaders
+	return nil
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.state != WriterStateWritingHeaders {
+		return fmt.Errorf("failed to write headers: writer is not expecting headers")
+	}
+	w.headers = headers.NewHeaders()
+	for key := range h {
+		for _, value := range h.Lines(key) {
+			w.headers.Add(key, value)
+		}
+	}
+
+	w.body = &bodyWriter{w: w}
+	for _, filter := range w.filters {
+		filtered := filter(w.statusCode, w.headers, w.body)
+		if filtered != nil {
+			w.body = filtered
+			w.bodyClosers = append(w.bodyClosers, filtered)
+		}
+	}
+
+	if w.transport != nil {
+		if err := w.transport.WriteHead(w.statusCode, w.headers); err != nil {
+			return fmt.Errorf("failed to write headers: %v", err)
+		}
+		w.state = WriterStateWritingBody
+		return nil
+	}
+
+	if w.headers.HasToken("Transfer-Encoding", "chunked") {
+		w.chunkWriter = chunked.NewWriter(w.writer)
+	}
+
+	if err := w.headers.Write(w.writer); err != nil {
+		return fmt.Errorf("failed to write headers: %v", err)
+	}
+	if _, err := io.WriteString(w.writer, CRLF); err != nil {
+		return fmt.Errorf("failed to write end of headers: %v", err)
+	}
+	w.state = WriterStateWritingBody
+	return nil
+}
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.state != WriterStateWritingBody {
+		return 0, fmt.Errorf("failed to write body: headers have not been written yet")
+	}
+	n, err := w.body.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("failed to write body: %v", err)
+	}
+	return n, nil
+}
+
+// Write makes the Writer usable as an io.Writer for the body.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// WriteText writes a complete plain text response in one go.
+func (w *Writer) WriteText(statusCode StatusCode, text string) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(GetDefaultHeaders(len(text))); err != nil {
+		return err
+	}
+	if _, err := w.WriteBody([]byte(text)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// flusher is implemented by body filters and connections buffering writes.
+type flusher interface {
+	Flush() error
+}
+
+// Flush sends everything written so far to the client, pushing it through
+// body filters that buffer, such as compressors. It is a no-op before the
+// headers are written and once the response is done.
+func (w *Writer) Flush() error {
+	if w.state != WriterStateWritingBody {
+		return nil
+	}
+	// the outermost filter goes first so its output reaches the inner ones
+	for i := len(w.bodyClosers) - 1; i >= 0; i-- {
+		if f, ok := w.bodyClosers[i].(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return fmt.Errorf("failed to flush body: %v", err)
+			}
+		}
+	}
+	if f, ok := w.writer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("failed to flush response: %v", err)
+		}
+	}
+	return nil
+}
+
+// Abort gives up on a response that can not be completed, e.g. when the
+// source of a streamed body fails. Close then leaves the body unterminated
+// so the client sees a truncated message once the connection is closed.
+func (w *Writer) Abort() {
+	w.state = WriterStateDone
+	w.aborted = true
+}
+
+// Aborted reports whether Abort was called.
+func (w *Writer) Aborted() bool {
+	return w.aborted
+}
+
+// SetReadBuffer is used by the server to let Hijack hand over the bytes it
+// read from the connection past the end of the current request.
+func (w *Writer) SetReadBuffer(readBuffer func() []byte) {
+	w.readBuffer = readBuffer
+}
+
+// Hijack hands the connection over to the caller, who becomes responsible
+// for closing it, along with the bytes the client already sent after the
+// request, which have to be processed before reading from the connection.
+// Whatever was written so far has already been sent, nothing is written on
+// Close afterwards and the server stops managing the connection. It fails
+// when the Writer is not backed by a net.Conn.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.state == WriterStateDone {
+		return nil, nil, fmt.Errorf("failed to hijack connection: response has already been completed")
+	}
+	conn, ok := w.writer.(net.Conn)
+	if !ok {
+		return nil, nil, fmt.Errorf("failed to hijack connection: writer is not a connection")
+	}
+	w.state = WriterStateDone
+	w.hijacked = true
+	buffered := []byte{}
+	if w.readBuffer != nil {
+		buffered = append(buffered, w.readBuffer()...)
+	}
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether the connection was taken over with Hijack.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// Close finishes the response: it sends an empty 200 if the handler wrote
+// nothing, flushes the body filters and terminates a chunked body or the
+// transport.
+func (w *Writer) Close() error {
+	switch w.state {
+	case WriterStateDone:
+		return nil
+	case WriterStateWritingStatusLine:
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+		fallthrough
+	case WriterStateWritingHeaders:
+		if err := w.WriteHeaders(GetDefaultHeaders(0)); err != nil {
+			return err
+		}
+	}
+	w.state = WriterStateDone
+
+	for i := len(w.bodyClosers) - 1; i >= 0; i-- {
+		if err := w.bodyClosers[i].Close(); err != nil {
+			return fmt.Errorf("failed to close body: %v", err)
+		}
+	}
+	if w.transport != nil {
+		return w.transport.Close()
+	}
+	if w.chunkWriter != nil {
+		if err := w.chunkWriter.Close(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w.writer, CRLF); err != nil {
+			return fmt.Errorf("failed to write end of chunked body: %v", err)
+		}
+	}
+	return nil
+}
+
+// bodyWriter sits at the bottom of the filter chain and applies the message
+// framing chosen by the headers.
+type bodyWriter struct {
+	w *Writer
+}
+
+func (bw *bodyWriter) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if bw.w.chunkWriter != nil {
+		n, err = bw.w.chunkWriter.Write(p)
+	} else {
+		n, err = bw.w.writer.Write(p)
+	}
+	bw.w.bytesWritten += n
+	return n, err
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"time"
+)
+
+// prefaceRequest is the part of the connection preface parsed as a request.
+const prefaceRequest = "PRI * HTTP/2.0\r\n\r\n"
+
+// upgradesToHTTP2 reports whether the server switches to h2c for req, which
+// is only done when the request body has been read completely.
+func upgradesToHTTP2(req *request.Request) bool {
+	_, hasTransferEncoding := req.Headers.Get("Transfer-Encoding")
+	return http2.IsUpgrade(req) && !hasTransferEncoding
+}
+
+// serveHTTP2 speaks cleartext HTTP/2 on conn, either with prior knowledge
+// when req is the connection preface or after upgrading req.
+func (s *Server) serveHTTP2(conn net.Conn, req *request.Request, buffered []byte) {
+	reader := io.MultiReader(bytes.NewReader(buffered), conn)
+	if http2.IsPreface(req) {
+		reader = io.MultiReader(bytes.NewReader([]byte(prefaceRequest)), reader)
+		http2.ServeConn(conn, reader, s.serveStream, nil, s.idleTimeout)
+		return
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "h2c")
+	w := response.NewWriter(conn)
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+	http2.ServeConn(conn, reader, s.serveStream, req, s.idleTimeout)
+}
+
+// serveStream answers the request of a single HTTP/2 stream.
+func (s *Server) serveStream(w *response.Writer, req *request.Request) {
+	start := time.Now()
+	s.serve(w, req)
+	w.Close()
+	s.metrics.requestServed(req, w, time.Since(start))
+	s.requestDone(w, req, start)
+}
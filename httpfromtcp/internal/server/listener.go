@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ServeListener serves connections accepted from a listener opened by the
+// caller, such as ListenUnix or SystemdListeners. Close closes the listener.
+func ServeListener(listener net.Listener, handler Handler, options ...Option) *Server {
+	server := &Server{
+		listener:    listener,
+		handler:     handler,
+		idleTimeout: DefaultIdleTimeout,
+		errChan:     make(chan error, 1),
+		quitChan:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(server)
+	}
+	go server.listen()
+
+	return server
+}
+
+// ListenUnix listens on a Unix domain socket at path whose permissions are
+// set to mode, so that e.g. only a sidecar's group may connect. A socket
+// left behind by a process that did not shut down cleanly is replaced, but
+// one another server is still accepting on is not. The socket file is
+// removed when the listener is closed.
+func ListenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on unix socket %s: %v", path, err)
+	}
+	// the socket is created with the permissions of the umask, clients
+	// racing the change only get as far as those allow
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of unix socket %s: %v", path, err)
+	}
+	return listener, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check unix socket %s: %v", path, err)
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("failed to listen on unix socket %s: file exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("failed to listen on unix socket %s: another server is listening on it", path)
+	}
+	// only a refused connection proves nobody listens, a live server may
+	// also deny access or be too busy to accept in time
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("failed to check unix socket %s: %v", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale unix socket %s: %v", path, err)
+	}
+	return nil
+}
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// SystemdListeners adopts the listening sockets systemd passes to a socket
+// activated service (sd_listen_fds(3)), in the order of the socket unit. It
+// returns none when the process was not started that way. The environment
+// variables describing them are unset so child processes do not adopt them
+// as well.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// the variables are meant for another process when the pid differs
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("failed to parse LISTEN_FDS '%s'", fds)
+	}
+	return fileListeners(listenFdsStart, count, strings.Split(names, ":"))
+}
+
+// fileListeners turns count consecutive file descriptors starting at first
+// into listeners, closing the descriptors themselves.
+func fileListeners(first int, count int, names []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, count)
+	for fd := first; fd < first+count; fd++ {
+		name := fmt.Sprintf("fd %d", fd)
+		if i := fd - first; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		// the listener works on a duplicate of the descriptor
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to adopt socket %s: %v", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"time"
+)
+
+// WithMetrics instruments the server into registry and serves the registry
+// on path, ahead of the handler.
+func WithMetrics(registry *metrics.Registry, path string) Option {
+	return func(s *Server) {
+		s.metrics = newServerMetrics(registry)
+		s.metricsPath = path
+	}
+}
+
+type serverMetrics struct {
+	registry            *metrics.Registry
+	activeConnections   *metrics.Gauge
+	acceptedConnections *metrics.Counter
+	hijackedConnections *metrics.Counter
+	requests            *metrics.Counter
+	requestSize         *metrics.Histogram
+	responseSize        *metrics.Histogram
+	parseErrors         *metrics.Counter
+	handlerDuration     *metrics.Histogram
+}
+
+func newServerMetrics(registry *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		registry:            registry,
+		activeConnections:   registry.NewGauge("http_server_active_connections", "Number of connections currently open."),
+		acceptedConnections: registry.NewCounter("http_server_connections_accepted_total", "Number of connections accepted."),
+		hijackedConnections: registry.NewCounter("http_server_connections_hijacked_total", "Number of connections taken over by handlers."),
+		requests:            registry.NewCounter("http_server_requests_total", "Number of requests served by method and status.", "method", "status"),
+		requestSize:         registry.NewHistogram("http_server_request_size_bytes", "Size of request bodies.", metrics.DefaultSizeBuckets),
+		responseSize:        registry.NewHistogram("http_server_response_size_bytes", "Size of response bodies.", metrics.DefaultSizeBuckets),
+		parseErrors:         registry.NewCounter("http_server_parse_errors_total", "Number of requests that could not be parsed by type.", "type"),
+		handlerDuration:     registry.NewHistogram("http_server_handler_duration_seconds", "Time spent handling requests.", metrics.DefaultDurationBuckets, "method"),
+	}
+}
+
+// the methods below are no-ops when the server has no metrics configured
+
+func (m *serverMetrics) connectionAccepted() {
+	if m == nil {
+		return
+	}
+	m.acceptedConnections.Inc()
+	m.activeConnections.Inc()
+}
+
+func (m *serverMetrics) connectionClosed() {
+	if m == nil {
+		return
+	}
+	m.activeConnections.Dec()
+}
+
+func (m *serverMetrics) connectionHijacked() {
+	if m == nil {
+		return
+	}
+	m.hijackedConnections.Inc()
+}
+
+func (m *serverMetrics) parseFailed(err error) {
+	if m == nil {
+		return
+	}
+	kind := "read"
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		kind = parseErr.Kind
+	}
+	m.parseErrors.Inc(kind)
+}
+
+func (m *serverMetrics) requestServed(req *request.Request, w *response.Writer, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	method := req.RequestLine.Method
+	m.requests.Inc(method, strconv.Itoa(int(w.StatusCode())))
+	m.requestSize.Observe(float64(len(req.Body)))
+	m.responseSize.Observe(float64(w.BytesWritten()))
+	m.handlerDuration.Observe(duration.Seconds(), method)
+}
@@ -1,42 +1,128 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/proxyprotocol"
+	"httpfromtcp/internal/reliable"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
 	"net"
+	"os"
+	"time"
 )
 
+type Handler func(w *response.Writer, req *request.Request)
+
+// Middleware wraps a handler with extra behaviour, see Chain.
+type Middleware func(next Handler) Handler
+
+// Chain applies the middlewares so that the first one is the outermost.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
 type Server struct {
 	listener net.Listener
-	errChan  chan error
-	quitChan chan struct{}
+	// udpListener replaces listener for servers started by ServeUDP
+	udpListener   *reliable.Listener
+	handler       Handler
+	metrics       *serverMetrics
+	requestLogger RequestLogger
+	metricsPath   string
+	idleTimeout   time.Duration
+	streamBody    func(req *request.Request) bool
+	errChan       chan error
+	quitChan      chan struct{}
+}
+
+// DefaultIdleTimeout is how long a kept alive connection may wait for its
+// next request.
+const DefaultIdleTimeout = 60 * time.Second
+
+// Option configures optional server behaviour.
+type Option func(s *Server)
+
+// WithIdleTimeout sets how long a connection is kept open waiting for the
+// next request, a negative timeout closes every connection after one request.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithStreamedBodies leaves the bodies of matching requests on the
+// connection for the handler to read through req.BodyReader, req.Body stays
+// empty for them. multipart.ParseRequest reads uploads this way without
+// holding them in memory, pass multipart.IsForm to match those.
+func WithStreamedBodies(match func(req *request.Request) bool) Option {
+	return func(s *Server) {
+		s.streamBody = match
+	}
+}
+
+// RequestLogger is called once the response to a request is complete, for
+// every request the server reads: malformed ones answered with 400 by the
+// server itself included, which come with an empty request line. For a
+// hijacked connection it is called when the handler returns, with the
+// writer reporting what was written before the hijack.
+type RequestLogger func(w *response.Writer, req *request.Request, start time.Time)
+
+// WithRequestLogger calls logger for every request served, see RequestLogger.
+func WithRequestLogger(logger RequestLogger) Option {
+	return func(s *Server) {
+		s.requestLogger = logger
+	}
 }
 
-func Serve(port int) (*Server, error) {
+// WithProxyProtocol expects a PROXY protocol header on connections from the
+// trusted proxies, whose addresses then stand in for the proxy's in
+// RemoteAddr and LocalAddr of requests. It has no effect on ServeUDP.
+func WithProxyProtocol(trusted proxyprotocol.Trusted) Option {
+	return func(s *Server) {
+		if s.listener != nil {
+			s.listener = proxyprotocol.NewListener(s.listener, trusted)
+		}
+	}
+}
+
+func Serve(port int, handler Handler, options ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp4", fmt.Sprintf(":%d", port))
 
 	if err != nil {
 		return &Server{}, fmt.Errorf("failed to create listener on port %d, reason: %v\n", port, err)
 	}
 
-	server := &Server{
-		listener: listener,
-		errChan:  make(chan error, 1),
-		quitChan: make(chan struct{}),
-	}
-	go server.listen()
-
-	return server, nil
+	return ServeListener(listener, handler, options...), nil
 }
 
 func (s *Server) Close() error {
 	close(s.quitChan)
-	err := s.listener.Close()
+	var err error
+	if s.udpListener != nil {
+		err = s.udpListener.Close()
+	} else {
+		err = s.listener.Close()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to close listener: %v", err)
 	}
 	return nil
 }
 
+func (s *Server) Addr() net.Addr {
+	if s.udpListener != nil {
+		return s.udpListener.Addr()
+	}
+	return s.listener.Addr()
+}
+
 func (s *Server) Err() <-chan error {
 	return s.errChan
 }
@@ -58,10 +144,138 @@ func (s *Server) listen() {
 			}
 		}
 
-		go func(conn net.Conn) {
-			defer conn.Close()
-			responseMsg := "HTTP/1.1 200 OK\r\n" + "Content-Type: text/plain\r\n" + "Content-Length: 14\r\n" + "\r\n" + "Hello World!\r\n"
-			conn.Write([]byte(responseMsg))
-		}(conn)
+		s.metrics.connectionAccepted()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	hijacked := false
+	defer func() {
+		// a hijacked connection belongs to the handler from then on, the
+		// server stops counting it as one of its own
+		if !hijacked {
+			conn.Close()
+		}
+		s.metrics.connectionClosed()
+	}()
+
+	reader := request.NewReader(conn)
+	reader.StreamBody = s.streamBody
+	for {
+		if s.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+		req, err := reader.ReadRequest()
+		if err != nil {
+			// the client went away or stayed idle between requests
+			if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) {
+				return
+			}
+			s.metrics.parseFailed(err)
+			start := time.Now()
+			w := response.NewWriter(conn)
+			w.AddFilter(closeConnection)
+			w.WriteText(response.StatusBadRequest, fmt.Sprintf("%v\n", err))
+			s.requestDone(w, malformedRequest(conn.RemoteAddr(), conn.LocalAddr()), start)
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.LocalAddr = conn.LocalAddr().String()
+
+		if http2.IsPreface(req) || upgradesToHTTP2(req) {
+			s.serveHTTP2(conn, req, append([]byte{}, reader.Buffered()...))
+			return
+		}
+
+		keepAlive := s.keepAlive(req)
+		w := response.NewWriter(conn)
+		w.SetReadBuffer(reader.Buffered)
+		if !keepAlive {
+			w.AddFilter(closeConnection)
+		}
+
+		start := time.Now()
+		s.serve(w, req)
+		if w.Hijacked() {
+			hijacked = true
+			s.metrics.connectionHijacked()
+			s.requestDone(w, req, start)
+			return
+		}
+		w.Close()
+		s.metrics.requestServed(req, w, time.Since(start))
+		s.requestDone(w, req, start)
+
+		if !keepAlive || w.Aborted() || !responseIsDelimited(w) {
+			return
+		}
+	}
+}
+
+// serve answers req with the metrics endpoint or the handler.
+func (s *Server) serve(w *response.Writer, req *request.Request) {
+	if s.metricsPath != "" && req.Path() == s.metricsPath {
+		s.metrics.registry.Handle(w, req)
+		return
+	}
+	s.handler(w, req)
+}
+
+// requestDone passes a completed request on to the request logger, if any.
+func (s *Server) requestDone(w *response.Writer, req *request.Request, start time.Time) {
+	if s.requestLogger != nil {
+		s.requestLogger(w, req, start)
+	}
+}
+
+// malformedRequest stands in for a request that could not be parsed.
+func malformedRequest(remoteAddr net.Addr, localAddr net.Addr) *request.Request {
+	req := request.NewRequest("", "", nil)
+	req.RequestLine.HttpVersion = ""
+	req.RemoteAddr = remoteAddr.String()
+	req.LocalAddr = localAddr.String()
+	return req
+}
+
+// keepAlive decides before the handler runs whether the connection can carry
+// another request once this one is answered.
+func (s *Server) keepAlive(req *request.Request) bool {
+	select {
+	case <-s.quitChan:
+		return false
+	default:
+	}
+	if s.idleTimeout < 0 || req.Headers.HasToken("Connection", "close") {
+		return false
+	}
+	// bodies in a transfer coding are not parsed, so the next request can
+	// not be found reliably
+	_, hasTransferEncoding := req.Headers.Get("Transfer-Encoding")
+	return !hasTransferEncoding
+}
+
+// closeConnection is a response filter announcing that the server closes the
+// connection after the response.
+func closeConnection(statusCode response.StatusCode, h headers.Headers, body io.Writer) io.WriteCloser {
+	h.Set("Connection", "close")
+	return nil
+}
+
+// responseIsDelimited reports whether the client can tell where the response
+// ends without the connection being closed.
+func responseIsDelimited(w *response.Writer) bool {
+	h := w.Headers()
+	if h.HasToken("Connection", "close") {
+		return false
+	}
+	if !w.StatusCode().AllowsBody() {
+		return true
+	}
+	if _, ok := h.Get("Content-Length"); ok {
+		return true
 	}
+	return h.HasToken("Transfer-Encoding", "chunked")
 }
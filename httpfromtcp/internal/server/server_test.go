@@ -0,0 +1,451 @@
+package server
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/proxyprotocol"
+	"httpfromtcp/internal/reliable"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "you asked for "+req.RequestLine.RequestTarget)
+	}
+
+	t.Run("Dispatches requests to the handler", func(t *testing.T) {
+		s := startServer(t, handler)
+		raw := roundTrip(t, s, "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 21\r\nContent-Type: text/plain\r\n\r\nyou asked for /coffee", raw)
+	})
+
+	t.Run("Answers malformed requests with 400", func(t *testing.T) {
+		s := startServer(t, handler)
+		raw := roundTrip(t, s, "GET /coffee HTTP/4\r\n\r\n")
+		assert.Contains(t, raw, "HTTP/1.1 400 Bad Request\r\n")
+	})
+
+	t.Run("Sends an empty 200 when the handler writes nothing", func(t *testing.T) {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {})
+		raw := roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Type: text/plain\r\n\r\n", raw)
+	})
+}
+
+func TestServeUDP(t *testing.T) {
+	s, err := ServeUDP(0, func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, req.RequestLine.RequestTarget+" "+string(req.Body))
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	packetConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.Addr().(*net.UDPAddr).Port}
+	conn := reliable.NewConn(packetConn, peer, reliable.DefaultConfig())
+	t.Cleanup(func() { conn.Close() })
+
+	exchange := func(raw string) string {
+		require.NoError(t, conn.Send([]byte(raw)))
+		msg, err := conn.Receive()
+		require.NoError(t, err)
+		return string(msg)
+	}
+
+	t.Run("Reads a body without Content-Length up to the end of the message", func(t *testing.T) {
+		raw := exchange("POST /notes HTTP/1.1\r\n\r\nhello")
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 12\r\nContent-Type: text/plain\r\n\r\n/notes hello", raw)
+	})
+
+	t.Run("Answers malformed requests with 400 and keeps the peer", func(t *testing.T) {
+		assert.Contains(t, exchange("GET / HTTP/4\r\n\r\n"), "HTTP/1.1 400 Bad Request\r\n")
+		assert.Contains(t, exchange("GET /again HTTP/1.1\r\n\r\n"), "/again")
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, req.RequestLine.RequestTarget)
+	}
+
+	t.Run("Serves pipelined requests on one connection", func(t *testing.T) {
+		s := startServer(t, handler)
+		raw := roundTrip(t, s, "GET /one HTTP/1.1\r\n\r\nPOST /two HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET /three HTTP/1.1\r\n\r\n")
+		assert.Equal(t, 3, strings.Count(raw, "HTTP/1.1 200 OK\r\n"))
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n/three"))
+	})
+
+	t.Run("Closes the connection when the client asks to", func(t *testing.T) {
+		s := startServer(t, handler)
+		raw := roundTrip(t, s, "GET /one HTTP/1.1\r\nConnection: close\r\n\r\nGET /two HTTP/1.1\r\n\r\n")
+		assert.Contains(t, raw, "Connection: close\r\n")
+		assert.True(t, strings.HasSuffix(raw, "/one"))
+	})
+
+	t.Run("Closes the connection after a response without framing", func(t *testing.T) {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(headers.NewHeaders())
+			w.WriteBody([]byte(req.RequestLine.RequestTarget))
+		})
+		raw := roundTrip(t, s, "GET /one HTTP/1.1\r\n\r\nGET /two HTTP/1.1\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n/one", raw)
+	})
+
+	t.Run("Closes idle connections", func(t *testing.T) {
+		s, err := Serve(0, handler, WithIdleTimeout(20*time.Millisecond))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "GET /one HTTP/1.1\r\n\r\n")
+		require.NoError(t, err)
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(data), "/one"))
+	})
+
+	t.Run("Streams matching bodies and skips what the handler left unread", func(t *testing.T) {
+		s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "/read" {
+				body, _ := io.ReadAll(req.BodyReader())
+				w.WriteText(response.StatusOK, fmt.Sprintf("buffered=%d streamed=%d", len(req.Body), len(body)))
+				return
+			}
+			w.WriteText(response.StatusOK, req.RequestLine.RequestTarget)
+		}, WithStreamedBodies(func(req *request.Request) bool { return true }))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+
+		body := strings.Repeat("x", 10000)
+		raw := roundTrip(t, s, "POST /read HTTP/1.1\r\nContent-Length: 10000\r\n\r\n"+body+"POST /unread HTTP/1.1\r\nContent-Length: 10000\r\n\r\n"+body+"GET /last HTTP/1.1\r\n\r\n")
+		assert.Contains(t, raw, "buffered=0 streamed=10000")
+		assert.Equal(t, 3, strings.Count(raw, "HTTP/1.1 200 OK\r\n"))
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n/last"))
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "hello")
+	}, WithMetrics(registry, "/metrics"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n")
+	roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n")
+	roundTrip(t, s, "GET / HTTP/4\r\n\r\n")
+	raw := roundTrip(t, s, "GET /metrics HTTP/1.1\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, raw, "http_server_connections_accepted_total 4\n")
+	assert.Contains(t, raw, "http_server_active_connections 1\n")
+	assert.Contains(t, raw, `http_server_requests_total{method="GET",status="200"} 2`+"\n")
+	assert.Contains(t, raw, `http_server_parse_errors_total{type="request_line"} 1`+"\n")
+	assert.Contains(t, raw, `http_server_response_size_bytes_bucket{le="64"} 2`+"\n")
+	assert.Contains(t, raw, `http_server_handler_duration_seconds_count{method="GET"} 2`+"\n")
+}
+
+func TestRequestLogger(t *testing.T) {
+	logged := make(chan string, 10)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.Path() == "/hijack" {
+			w.WriteStatusLine(response.StatusSwitchingProtocols)
+			w.WriteHeaders(headers.NewHeaders())
+			conn, _, err := w.Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteText(response.StatusOK, "hello")
+	}, WithMetrics(metrics.NewRegistry(), "/metrics"), WithRequestLogger(func(w *response.Writer, req *request.Request, start time.Time) {
+		logged <- fmt.Sprintf("%q %q %d", req.RequestLine.Method, req.RequestLine.RequestTarget, w.StatusCode())
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	next := func(t *testing.T) string {
+		t.Helper()
+		select {
+		case line := <-logged:
+			return line
+		case <-time.After(time.Second):
+			t.Fatal("request was not logged")
+			return ""
+		}
+	}
+
+	t.Run("Logs requests answered by the handler", func(t *testing.T) {
+		roundTrip(t, s, "GET /hello HTTP/1.1\r\n\r\n")
+		assert.Equal(t, `"GET" "/hello" 200`, next(t))
+	})
+
+	t.Run("Logs malformed requests with an empty request line", func(t *testing.T) {
+		roundTrip(t, s, "GET /coffee HTTP/4\r\n\r\n")
+		assert.Equal(t, `"" "" 400`, next(t))
+	})
+
+	t.Run("Logs requests to the metrics path", func(t *testing.T) {
+		roundTrip(t, s, "GET /metrics HTTP/1.1\r\n\r\n")
+		assert.Equal(t, `"GET" "/metrics" 200`, next(t))
+	})
+
+	t.Run("Logs hijacked connections", func(t *testing.T) {
+		roundTrip(t, s, "GET /hijack HTTP/1.1\r\n\r\n")
+		assert.Equal(t, `"GET" "/hijack" 101`, next(t))
+	})
+}
+
+func TestChain(t *testing.T) {
+	calls := []string{}
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name)
+				next(w, req)
+			}
+		}
+	}
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+	}, middleware("outer"), middleware("inner"))
+
+	handler(nil, nil)
+	assert.Equal(t, []string{"outer", "inner", "handler"}, calls)
+}
+
+func TestHijack(t *testing.T) {
+	// the handler runs outside the test goroutine, where require must not
+	// be used
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		early := make([]byte, len("early bytes")-len(buffered))
+		_, err = io.ReadFull(conn, early)
+		assert.NoError(t, err)
+		_, err = io.WriteString(conn, "got: "+string(buffered)+string(early))
+		assert.NoError(t, err)
+		// the connection outlives the handler once hijacked
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			io.WriteString(conn, ", still open")
+			conn.Close()
+		}()
+	}, WithMetrics(metrics.NewRegistry(), "/metrics"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	raw := roundTrip(t, s, "GET /upgrade HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: custom\r\n\r\nearly bytes")
+	assert.Equal(t, "got: early bytes, still open", raw)
+
+	// the hijacked connection is no longer the server's to count as open
+	raw = roundTrip(t, s, "GET /metrics HTTP/1.1\r\n\r\n")
+	assert.Contains(t, raw, "http_server_active_connections 1\n")
+	assert.Contains(t, raw, "http_server_connections_hijacked_total 1\n")
+}
+
+func TestHTTP2(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "you asked for "+req.RequestLine.RequestTarget+" over "+req.RequestLine.HttpVersion)
+	})
+	// an empty SETTINGS frame and a GET for / on stream 1, with the
+	// pseudo-header fields indexed in the static table
+	frames := "\x00\x00\x00\x04\x00\x00\x00\x00\x00" + "\x00\x00\x03\x01\x05\x00\x00\x00\x01\x82\x86\x84"
+
+	t.Run("Speaks HTTP/2 with prior knowledge", func(t *testing.T) {
+		raw := roundTrip(t, s, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"+frames)
+		assert.Contains(t, raw, "you asked for / over 2.0")
+	})
+
+	t.Run("Upgrades to h2c and answers the upgrade request on stream 1", func(t *testing.T) {
+		raw := roundTrip(t, s, "GET /upgrade HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\n"+
+			"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"+frames[:9])
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"), raw)
+		assert.Contains(t, raw, "you asked for /upgrade over 2.0")
+	})
+}
+
+func TestListenUnix(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "over unix")
+	}
+	get := func(t *testing.T, path string) string {
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+		require.NoError(t, err)
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("Serves on a socket with the given permissions and removes it on Close", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "http.sock")
+		listener, err := ListenUnix(path, 0o660)
+		require.NoError(t, err)
+		s := ServeListener(listener, handler)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+		assert.Contains(t, get(t, path), "over unix")
+
+		require.NoError(t, s.Close())
+		_, err = os.Stat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Replaces a stale socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "http.sock")
+		stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		require.NoError(t, err)
+		stale.SetUnlinkOnClose(false)
+		stale.Close()
+
+		listener, err := ListenUnix(path, 0o600)
+		require.NoError(t, err)
+		s := ServeListener(listener, handler)
+		defer s.Close()
+		assert.Contains(t, get(t, path), "over unix")
+	})
+
+	t.Run("Refuses a socket in use or a file that is not a socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "http.sock")
+		listener, err := ListenUnix(path, 0o600)
+		require.NoError(t, err)
+		defer listener.Close()
+		_, err = ListenUnix(path, 0o600)
+		assert.ErrorContains(t, err, "another server is listening")
+
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0o600))
+		_, err = ListenUnix(file, 0o600)
+		assert.ErrorContains(t, err, "not a socket")
+	})
+}
+
+func TestSystemdListeners(t *testing.T) {
+	t.Run("Ignores variables meant for another process", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", "1")
+		t.Setenv("LISTEN_FDS", "1")
+		listeners, err := SystemdListeners()
+		require.NoError(t, err)
+		assert.Empty(t, listeners)
+		_, ok := os.LookupEnv("LISTEN_FDS")
+		assert.False(t, ok)
+	})
+
+	t.Run("Rejects an invalid LISTEN_FDS", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "many")
+		_, err := SystemdListeners()
+		assert.Error(t, err)
+	})
+
+	t.Run("Adopts passed listening sockets", func(t *testing.T) {
+		tcp, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer tcp.Close()
+		fd := dupFd(t, tcp.(*net.TCPListener))
+
+		listeners, err := fileListeners(fd, 1, []string{"http"})
+		require.NoError(t, err)
+		require.Len(t, listeners, 1)
+		s := ServeListener(listeners[0], func(w *response.Writer, req *request.Request) {
+			w.WriteText(response.StatusOK, "activated")
+		})
+		defer s.Close()
+		assert.Equal(t, tcp.Addr().String(), s.Addr().String())
+		assert.Contains(t, roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n"), "activated")
+	})
+
+	t.Run("Fails on descriptors that are not listening sockets", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "not-a-socket")
+		require.NoError(t, err)
+		defer f.Close()
+		fd, err := syscall.Dup(int(f.Fd()))
+		require.NoError(t, err)
+		_, err = fileListeners(fd, 1, nil)
+		assert.ErrorContains(t, err, fmt.Sprintf("fd %d", fd))
+	})
+}
+
+// dupFd returns a duplicate of the descriptor of listener, owned by the
+// caller.
+func dupFd(t *testing.T, listener *net.TCPListener) int {
+	f, err := listener.File()
+	require.NoError(t, err)
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	return fd
+}
+
+func TestProxyProtocol(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, req.RemoteAddr+" -> "+req.LocalAddr)
+	}
+	serve := func(t *testing.T, trusted string) *Server {
+		parsed, err := proxyprotocol.ParseTrusted(trusted)
+		require.NoError(t, err)
+		s, err := Serve(0, handler, WithProxyProtocol(parsed))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	t.Run("Exposes the addresses sent by a trusted proxy", func(t *testing.T) {
+		s := serve(t, "127.0.0.1/32")
+		raw := roundTrip(t, s, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n192.0.2.1:56324 -> 198.51.100.1:443"), raw)
+	})
+
+	t.Run("Treats the header of an untrusted peer as a malformed request", func(t *testing.T) {
+		s := serve(t, "10.0.0.0/8")
+		raw := roundTrip(t, s, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n")
+		assert.Contains(t, raw, "HTTP/1.1 400 Bad Request\r\n")
+	})
+}
+
+func startServer(t *testing.T, handler Handler) *Server {
+	t.Helper()
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// roundTrip sends a raw request and reads until the server closes the
+// connection, which it does once it sees there is no further request
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(data)
+}
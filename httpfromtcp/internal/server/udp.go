@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/reliable"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"time"
+)
+
+// ServeUDP is an experimental transport carrying every request and every
+// response in a single message of a reliable.Conn, fragmented over as many
+// datagrams as needed. As a message is complete once delivered, request
+// bodies without Content-Length are read up to its end and responses may be
+// delimited by the end of the message.
+//
+// A peer is forgotten once it has been idle for the idle timeout, a negative
+// timeout forgets it as soon as its response is acknowledged.
+func ServeUDP(port int, handler Handler, options ...Option) (*Server, error) {
+	packetConn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", port))
+
+	if err != nil {
+		return &Server{}, fmt.Errorf("failed to create udp listener on port %d, reason: %v\n", port, err)
+	}
+
+	server := &Server{
+		udpListener: reliable.Listen(packetConn, reliable.DefaultConfig()),
+		handler:     handler,
+		idleTimeout: DefaultIdleTimeout,
+		errChan:     make(chan error, 1),
+		quitChan:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(server)
+	}
+	go server.listenUDP()
+
+	return server, nil
+}
+
+func (s *Server) listenUDP() {
+	for {
+		conn, err := s.udpListener.Accept()
+		if err != nil {
+			// the listener only fails once closed
+			return
+		}
+
+		s.metrics.connectionAccepted()
+		go s.handleUDP(conn)
+	}
+}
+
+func (s *Server) handleUDP(conn *reliable.Conn) {
+	defer conn.Close()
+	defer s.metrics.connectionClosed()
+
+	var idleTimer *time.Timer
+	if s.idleTimeout > 0 {
+		idleTimer = time.AfterFunc(s.idleTimeout, func() { conn.Close() })
+		defer idleTimer.Stop()
+	}
+
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			return
+		}
+		if idleTimer != nil {
+			idleTimer.Stop()
+		}
+
+		var buffer bytes.Buffer
+		w := response.NewWriter(&buffer)
+		req, err := request.RequestFromReader(bytes.NewReader(msg))
+		if err != nil {
+			s.metrics.parseFailed(err)
+			start := time.Now()
+			w.WriteText(response.StatusBadRequest, fmt.Sprintf("%v\n", err))
+			s.requestDone(w, malformedRequest(conn.RemoteAddr(), s.udpListener.Addr()), start)
+		} else {
+			req.RemoteAddr = conn.RemoteAddr().String()
+			req.LocalAddr = s.udpListener.Addr().String()
+			start := time.Now()
+			s.serve(w, req)
+			w.Close()
+			s.metrics.requestServed(req, w, time.Since(start))
+			s.requestDone(w, req, start)
+		}
+
+		// an aborted response is dropped as a whole rather than truncated
+		if !w.Aborted() {
+			if err := conn.Send(buffer.Bytes()); err != nil {
+				return
+			}
+		}
+		if s.idleTimeout < 0 {
+			conn.Flush()
+			return
+		}
+		if idleTimer != nil {
+			idleTimer.Reset(s.idleTimeout)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package sse
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many events a client may lag behind before it is
+// disconnected, it then catches up through Last-Event-ID when reconnecting.
+const subscriberBuffer = 64
+
+// Broker fans published events out to every connected client and keeps the
+// most recent ones so that reconnecting clients receive what they missed.
+type Broker struct {
+	Heartbeat time.Duration
+	// Retry is sent to clients on connection as their reconnection delay
+	Retry time.Duration
+
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	historySize int
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		Heartbeat:   DefaultHeartbeat,
+		nextID:      1,
+		historySize: historySize,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish numbers event, overriding its ID, and sends it to every client.
+func (b *Broker) Publish(event Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	event.ID = strconv.FormatUint(b.nextID, 10)
+	b.nextID++
+
+	if b.historySize > 0 {
+		if len(b.history) == b.historySize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, event)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Close disconnects every client.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Handle streams events to the client until it goes away, starting with the
+// ones published after its Last-Event-ID that are still in the history.
+func (b *Broker) Handle(w *response.Writer, req *request.Request) {
+	s, err := NewWriter(w, req, b.Heartbeat)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+
+	missed, ch := b.subscribe(s.LastEventID())
+	defer b.unsubscribe(ch)
+	if b.Retry > 0 {
+		if err := s.Send(Event{Retry: b.Retry}); err != nil {
+			return
+		}
+	}
+	for _, event := range missed {
+		if err := s.Send(event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := s.Send(event); err != nil {
+				return
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// subscribe registers a client and returns the events it missed, atomically
+// so that none is lost or sent twice in between.
+func (b *Broker) subscribe(lastEventID string) ([]Event, chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Event, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return nil, ch
+	}
+	b.subscribers[ch] = struct{}{}
+
+	if lastEventID == "" {
+		return nil, ch
+	}
+	last, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return nil, ch
+	}
+	missed := []Event{}
+	for _, event := range b.history {
+		if id, _ := strconv.ParseUint(event.ID, 10, 64); id > last {
+			missed = append(missed, event)
+		}
+	}
+	return missed, ch
+}
+
+func (b *Broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
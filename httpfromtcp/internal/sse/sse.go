@@ -0,0 +1,174 @@
+package sse
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeat keeps idle streams below the timeouts of common proxies.
+const DefaultHeartbeat = 15 * time.Second
+
+// Event is a single message of an event stream (HTML Living Standard section
+// 9.2), empty fields are left out.
+type Event struct {
+	ID    string
+	Event string
+	// Data may span several lines, each becomes its own data field
+	Data string
+	// Retry tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+// MarshalText formats the event as its fields followed by a blank line.
+func (e Event) MarshalText() ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("invalid event id %q", e.ID)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("invalid event type %q", e.Event)
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Data != "" {
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			fmt.Fprintf(&b, "data: %s\n", line)
+		}
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// Writer streams events to one client, flushing each as it is sent.
+type Writer struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu        sync.Mutex
+	err       error
+	doneChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWriter starts an event stream in response to req. A positive heartbeat
+// sends a comment at that interval, keeping proxies from timing the stream
+// out and noticing clients that went away. Close has to be called before
+// the handler returns.
+func NewWriter(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Writer, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	s := &Writer{
+		w:           w,
+		lastEventID: lastEventID,
+		doneChan:    make(chan struct{}),
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+// LastEventID is the id of the last event the client received before it
+// reconnected, empty on the first connection.
+func (s *Writer) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the client is gone or the Writer is closed.
+func (s *Writer) Done() <-chan struct{} {
+	return s.doneChan
+}
+
+func (s *Writer) Send(event Event) error {
+	data, err := event.MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to send event: %v", err)
+	}
+	return s.write(data)
+}
+
+// Comment sends a line the client ignores.
+func (s *Writer) Comment(text string) error {
+	return s.write([]byte(": " + strings.ReplaceAll(text, "\n", " ") + "\n\n"))
+}
+
+// Close stops the heartbeats, the response itself is finished by the server.
+func (s *Writer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop(nil)
+	return nil
+}
+
+func (s *Writer) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.doneChan:
+		if s.err != nil {
+			return s.err
+		}
+		return fmt.Errorf("failed to write event: stream is closed")
+	default:
+	}
+	if _, err := s.w.WriteBody(data); err != nil {
+		s.stop(err)
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.stop(err)
+		return err
+	}
+	return nil
+}
+
+// stop ends the stream, s.mu has to be held.
+func (s *Writer) stop(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.doneChan)
+	})
+}
+
+func (s *Writer) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		case <-s.doneChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package sse
+
+import (
+	"bufio"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvent(t *testing.T) {
+	t.Run("Formats every field", func(t *testing.T) {
+		data, err := Event{ID: "7", Event: "status", Data: "line one\nline two", Retry: 3 * time.Second}.MarshalText()
+		require.NoError(t, err)
+		assert.Equal(t, "id: 7\nevent: status\ndata: line one\ndata: line two\nretry: 3000\n\n", string(data))
+	})
+
+	t.Run("Splits data on every kind of line break", func(t *testing.T) {
+		data, err := Event{Data: "a\r\nb\rc\n"}.MarshalText()
+		require.NoError(t, err)
+		assert.Equal(t, "data: a\ndata: b\ndata: c\ndata: \n\n", string(data))
+	})
+
+	t.Run("Rejects line breaks in single line fields", func(t *testing.T) {
+		_, err := Event{ID: "1\n2"}.MarshalText()
+		assert.Error(t, err)
+		_, err = Event{Event: "a\rb"}.MarshalText()
+		assert.Error(t, err)
+	})
+}
+
+func TestWriter(t *testing.T) {
+	t.Run("Sends headers, events and heartbeats as they happen", func(t *testing.T) {
+		sent := make(chan struct{})
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			stream, err := NewWriter(w, req, 20*time.Millisecond)
+			require.NoError(t, err)
+			defer stream.Close()
+			require.NoError(t, stream.Send(Event{Event: "hello", Data: stream.LastEventID()}))
+			<-sent
+		})
+		reader := connect(t, s, "Last-Event-ID: 41\r\n")
+
+		head := readUntil(t, reader, "\r\n\r\n")
+		assert.Contains(t, head, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, head, "Content-Type: text/event-stream\r\n")
+		assert.Contains(t, head, "Cache-Control: no-cache\r\n")
+		assert.Contains(t, head, "Transfer-Encoding: chunked\r\n")
+		assert.Contains(t, readUntil(t, reader, "\n\n"), "event: hello\ndata: 41\n\n")
+		assert.Contains(t, readUntil(t, reader, "\n\n"), ": heartbeat\n\n")
+		close(sent)
+	})
+
+	t.Run("Reports a client that went away", func(t *testing.T) {
+		done := make(chan struct{})
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			stream, err := NewWriter(w, req, 5*time.Millisecond)
+			require.NoError(t, err)
+			defer stream.Close()
+			<-stream.Done()
+			close(done)
+		})
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\n\r\n")
+		require.NoError(t, err)
+		readUntil(t, bufio.NewReader(conn), "\r\n\r\n")
+		conn.Close()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler did not notice the client leaving")
+		}
+	})
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(2)
+	broker.Retry = time.Second
+	t.Cleanup(broker.Close)
+	s := startServer(t, broker.Handle)
+
+	for _, status := range []string{"starting", "degraded", "healthy"} {
+		broker.Publish(Event{Event: "status", Data: status})
+	}
+
+	t.Run("Replays the events missed since Last-Event-ID", func(t *testing.T) {
+		reader := connect(t, s, "Last-Event-ID: 1\r\n")
+		readUntil(t, reader, "\r\n\r\n")
+		assert.Contains(t, readUntil(t, reader, "\n\n"), "retry: 1000\n\n")
+		assert.Contains(t, readUntil(t, reader, "\n\n"), "id: 2\nevent: status\ndata: degraded\n\n")
+		assert.Contains(t, readUntil(t, reader, "\n\n"), "id: 3\nevent: status\ndata: healthy\n\n")
+
+		broker.Publish(Event{Data: "live"})
+		assert.Contains(t, readUntil(t, reader, "\n\n"), "id: 4\ndata: live\n\n")
+	})
+
+	t.Run("Starts new clients with live events only", func(t *testing.T) {
+		reader := connect(t, s, "")
+		readUntil(t, reader, "\r\n\r\n")
+		readUntil(t, reader, "retry: 1000\n\n")
+		// the client is subscribed by the time the retry field is sent
+		broker.Publish(Event{Data: "next"})
+		assert.Contains(t, readUntil(t, reader, "\n\n"), "id: 5\ndata: next\n\n")
+	})
+}
+
+func startServer(t *testing.T, handler server.Handler) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// connect requests an event stream with extra header lines and returns the
+// reader of the raw response, still chunked.
+func connect(t *testing.T, s *server.Server, extraHeaders string) *bufio.Reader {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\nAccept: text/event-stream\r\n"+extraHeaders+"\r\n")
+	require.NoError(t, err)
+	return bufio.NewReader(conn)
+}
+
+// readUntil reads up to and including delimiter.
+func readUntil(t *testing.T, reader *bufio.Reader, delimiter string) string {
+	t.Helper()
+	var b strings.Builder
+	for !strings.HasSuffix(b.String(), delimiter) {
+		c, err := reader.ReadByte()
+		require.NoError(t, err)
+		b.WriteByte(c)
+	}
+	return b.String()
+}
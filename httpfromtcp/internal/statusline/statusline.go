@@ -0,0 +1,67 @@
+package statusline
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   int
+	ReasonPhrase string
+}
+
+func NewStatusLine() StatusLine {
+	return StatusLine{}
+}
+
+func (sl *StatusLine) ParseLine(line string) error {
+	// the reason phrase is optional and may itself contain spaces
+	statusLineParts := strings.SplitN(line, " ", 3)
+
+	if len(statusLineParts) < 2 {
+		return fmt.Errorf("invalid status line '%s', should have a version and a status code", line)
+	}
+
+	httpVersion, err := validateHttpVersion(statusLineParts[0])
+
+	if err != nil {
+		return fmt.Errorf("invalid http version: %v", err)
+	}
+
+	statusCode, err := validateStatusCode(statusLineParts[1])
+
+	if err != nil {
+		return fmt.Errorf("invalid status code: %v", err)
+	}
+
+	sl.HttpVersion = httpVersion
+	sl.StatusCode = statusCode
+	sl.ReasonPhrase = ""
+	if len(statusLineParts) == 3 {
+		sl.ReasonPhrase = statusLineParts[2]
+	}
+
+	return nil
+}
+
+func validateHttpVersion(httpVersion string) (string, error) {
+	validHttpVersions := []string{"HTTP/1.0", "HTTP/1.1"}
+	if slices.Contains(validHttpVersions, httpVersion) {
+		return strings.Replace(httpVersion, "HTTP/", "", 1), nil
+	}
+	return "", fmt.Errorf("invalid http version, received: '%s', valid values are: %v", httpVersion, validHttpVersions)
+}
+
+func validateStatusCode(statusCode string) (int, error) {
+	if len(statusCode) != 3 {
+		return 0, fmt.Errorf("status code '%s' should be three digits", statusCode)
+	}
+	code, err := strconv.Atoi(statusCode)
+	if err != nil || code < 100 {
+		return 0, fmt.Errorf("status code '%s' should be a number between 100 and 999", statusCode)
+	}
+	return code, nil
+}
@@ -0,0 +1,433 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// frame opcodes (RFC 6455 section 5.2)
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// close status codes (RFC 6455 section 7.4.1)
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	// CloseNoStatus is reported when a close frame carries no code, it is
+	// never sent
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	// DefaultMaxMessageSize bounds a reassembled message
+	DefaultMaxMessageSize = 1 << 20
+	// maxControlPayloadSize applies to close, ping and pong frames
+	maxControlPayloadSize = 125
+	// CloseTimeout bounds how long Close waits for the peer's close frame
+	CloseTimeout = 2 * time.Second
+)
+
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// CloseError is returned by ReadMessage once the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with status %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. ReadMessage has to be called from a single
+// goroutine, writes may happen concurrently with it and with each other.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// client connections mask the frames they send and expect unmasked ones
+	client bool
+
+	// MaxMessageSize is the largest message ReadMessage accepts, a bigger
+	// one closes the connection with CloseMessageTooBig
+	MaxMessageSize int64
+	// FragmentSize splits outgoing messages into frames of at most that many
+	// bytes, zero sends every message as a single frame
+	FragmentSize int
+	// PongHandler is called with the payload of every pong received
+	PongHandler func(data []byte)
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	// closeReceived is only touched by the reading goroutine
+	closeReceived bool
+}
+
+// NewConn speaks the WebSocket protocol over conn once the handshake is done.
+// reader is read from instead of conn, so that bytes buffered during the
+// handshake are not lost.
+func NewConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	if reader == nil {
+		reader = bufio.NewReader(conn)
+	}
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		client:         client,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline bounds the wait in ReadMessage, see net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func isControl(opcode byte) bool {
+	return opcode&0x8 != 0
+}
+
+// ReadMessage returns the next data message, answering pings and
+// reassembling fragments along the way. Once the peer closes the connection
+// it returns a *CloseError, after a protocol violation the connection is
+// closed and the error describes the violation.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	message := []byte{}
+	for {
+		f, err := c.readFrame(c.MaxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, true, f.payload); err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.PongHandler != nil {
+				c.PongHandler(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "new message started before the previous one was finished")
+			}
+			messageType = MessageType(f.opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation frame without a message to continue")
+			}
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		return messageType, message, nil
+	}
+}
+
+// readFrame reads and unmasks the next frame, limit bounds the payload of a
+// data frame.
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, head); err != nil {
+		return frame{}, fmt.Errorf("failed to read frame: %w", err)
+	}
+	f := frame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	if head[0]&0x70 != 0 {
+		return frame{}, c.fail(CloseProtocolError, "reserved bits set without a negotiated extension")
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return frame{}, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		if c.client {
+			return frame{}, c.fail(CloseProtocolError, "frame from the server is masked")
+		}
+		return frame{}, c.fail(CloseProtocolError, "frame from the client is not masked")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return frame{}, fmt.Errorf("failed to read frame length: %w", err)
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return frame{}, fmt.Errorf("failed to read frame length: %w", err)
+		}
+		length = binary.BigEndian.Uint64(extended)
+		if length>>63 != 0 {
+			return frame{}, c.fail(CloseProtocolError, "frame length has the most significant bit set")
+		}
+	}
+
+	if isControl(f.opcode) {
+		if !f.fin {
+			return frame{}, c.fail(CloseProtocolError, "fragmented control frame")
+		}
+		if length > maxControlPayloadSize {
+			return frame{}, c.fail(CloseProtocolError, "control frame payload exceeds 125 bytes")
+		}
+	} else if length > uint64(max(limit, 0)) {
+		return frame{}, c.fail(CloseMessageTooBig, "message exceeds the maximum message size")
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, maskKey[:]); err != nil {
+			return frame{}, fmt.Errorf("failed to read mask key: %w", err)
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	if masked {
+		mask(f.payload, maskKey)
+	}
+	return f, nil
+}
+
+// handleClose answers the close frame of the peer, unless this side started
+// the closing handshake, and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	c.closeReceived = true
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "close frame payload of 1 byte")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, fmt.Sprintf("invalid close code %d", closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+
+	replyCode := closeErr.Code
+	if replyCode == CloseNoStatus {
+		replyCode = CloseNormal
+	}
+	c.WriteClose(replyCode, "")
+	c.conn.Close()
+	return closeErr
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail closes the connection after a protocol violation by the peer.
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	c.conn.Close()
+	return fmt.Errorf("failed to read message: %s", reason)
+}
+
+// WriteMessage sends data as one message, split into frames of FragmentSize.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("failed to write message: invalid message type %d", messageType)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	opcode := byte(messageType)
+	for {
+		chunk := data
+		if c.FragmentSize > 0 && len(chunk) > c.FragmentSize {
+			chunk = chunk[:c.FragmentSize]
+		}
+		data = data[len(chunk):]
+		fin := len(data) == 0
+		if err := c.writeFrameLocked(opcode, fin, chunk); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		opcode = opContinuation
+	}
+}
+
+// Ping sends a ping, the pong answering it is passed to PongHandler.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayloadSize {
+		return fmt.Errorf("failed to write ping: payload exceeds %d bytes", maxControlPayloadSize)
+	}
+	return c.writeFrame(opPing, true, data)
+}
+
+// WriteClose starts the closing handshake, or answers the peer's close frame.
+// Nothing can be written afterwards, ReadMessage returns a *CloseError once
+// the peer's close frame arrives. A reason too long for a control frame is
+// cut after the last whole rune that fits.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if cut := maxControlPayloadSize - 2; len(reason) > cut {
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.writeFrameLocked(opClose, true, payload); err != nil {
+		return err
+	}
+	c.closeSent = true
+	return nil
+}
+
+// Close performs the closing handshake, waiting up to CloseTimeout for the
+// peer to answer, and closes the connection. It reads from the connection
+// so it must not be called while another goroutine is in ReadMessage, which
+// should be stopped with WriteClose instead.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.WriteClose(code, reason)
+	if (err == nil || errors.Is(err, ErrCloseSent)) && !c.closeReceived {
+		c.conn.SetReadDeadline(time.Now().Add(CloseTimeout))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+	closeErr := c.conn.Close()
+	if err != nil && !errors.Is(err, ErrCloseSent) {
+		return err
+	}
+	if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+		return closeErr
+	}
+	return nil
+}
+
+func (c *Conn) writeFrame(opcode byte, fin bool, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(opcode, fin, payload)
+}
+
+// writeFrameLocked sends a single frame, c.writeMu has to be held.
+func (c *Conn) writeFrameLocked(opcode byte, fin bool, payload []byte) error {
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	head := make([]byte, 0, 14)
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	head = append(head, first)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		head = append(head, maskBit|byte(length))
+	case length <= 0xffff:
+		head = append(head, maskBit|126)
+		head = binary.BigEndian.AppendUint16(head, uint16(length))
+	default:
+		head = append(head, maskBit|127)
+		head = binary.BigEndian.AppendUint64(head, uint64(length))
+	}
+
+	data := payload
+	if c.client {
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return fmt.Errorf("failed to generate mask key: %v", err)
+		}
+		head = append(head, maskKey[:]...)
+		data = append([]byte(nil), payload...)
+		mask(data, maskKey)
+	}
+
+	if _, err := c.conn.Write(append(head, data...)); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+	return nil
+}
+
+// mask applies the masking algorithm of RFC 6455 section 5.3, which is its
+// own inverse.
+func mask(data []byte, key [4]byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
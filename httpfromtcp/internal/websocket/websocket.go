@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"time"
+)
+
+// acceptGUID is appended to the client key to derive Sec-WebSocket-Accept
+// (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// AcceptKey computes the Sec-WebSocket-Accept value answering key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsUpgrade reports whether req asks to switch to the WebSocket protocol.
+func IsUpgrade(req *request.Request) bool {
+	return req.Headers.HasToken("Connection", "upgrade") && req.Headers.HasToken("Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake of req and takes the connection
+// over from the server. When the request is not a valid handshake an error
+// response is written and an error returned.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if err := checkHandshake(req); err != nil {
+		h := response.GetDefaultHeaders(len(err.Error()) + 1)
+		statusCode := response.StatusBadRequest
+		if version, _ := req.Headers.Get("Sec-WebSocket-Version"); version != "13" && IsUpgrade(req) {
+			statusCode = response.StatusUpgradeRequired
+			h.Set("Sec-WebSocket-Version", "13")
+		}
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(err.Error() + "\n"))
+		return nil, err
+	}
+
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// frames the client sent right behind the handshake were read already
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+	return NewConn(conn, reader, false), nil
+}
+
+func checkHandshake(req *request.Request) error {
+	if req.RequestLine.Method != "GET" {
+		return fmt.Errorf("websocket handshake has to use GET, not %s", req.RequestLine.Method)
+	}
+	if !IsUpgrade(req) {
+		return fmt.Errorf("missing 'Connection: Upgrade' and 'Upgrade: websocket' headers")
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); version != "13" {
+		return fmt.Errorf("unsupported websocket version '%s'", version)
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fmt.Errorf("invalid Sec-WebSocket-Key '%s'", key)
+	}
+	return nil
+}
+
+// Dial opens a client connection to the WebSocket at path on address.
+func Dial(address string, path string, timeout time.Duration) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := request.NewRequest("GET", path, nil)
+	req.Headers.Set("Host", address)
+	req.Headers.Set("Upgrade", "websocket")
+	req.Headers.Set("Connection", "Upgrade")
+	req.Headers.Set("Sec-WebSocket-Key", key)
+	req.Headers.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := response.ResponseFromReader(reader, "GET")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read handshake response: %w", err)
+	}
+	if resp.StatusCode() != response.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("server refused the upgrade with %d %s", resp.StatusCode(), resp.StatusLine.ReasonPhrase)
+	}
+	if accept, _ := resp.Headers.Get("Sec-WebSocket-Accept"); accept != AcceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("invalid Sec-WebSocket-Accept '%s'", accept)
+	}
+	conn.SetDeadline(time.Time{})
+	return NewConn(conn, reader, true), nil
+}
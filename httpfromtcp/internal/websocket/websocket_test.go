@@ -0,0 +1,262 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgrade(t *testing.T) {
+	address, _ := startEchoServer(t, DefaultMaxMessageSize)
+
+	t.Run("Answers a plain request with 400", func(t *testing.T) {
+		raw := rawRequest(t, address, "GET /ws HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 400 Bad Request\r\n"), raw)
+	})
+
+	t.Run("Asks for version 13 with 426", func(t *testing.T) {
+		raw := rawRequest(t, address, "GET /ws HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 8\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+		assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 426 Upgrade Required\r\n"), raw)
+		assert.Contains(t, raw, "Sec-Websocket-Version: 13\r\n")
+	})
+
+	t.Run("Switches protocols with the accept key", func(t *testing.T) {
+		conn, _ := handshake(t, address)
+		conn.Close()
+	})
+}
+
+func TestConn(t *testing.T) {
+	address, closes := startEchoServer(t, 64)
+
+	t.Run("Echoes text and binary messages", func(t *testing.T) {
+		c := dial(t, address)
+		require.NoError(t, c.WriteMessage(TextMessage, []byte("héllo")))
+		require.NoError(t, c.WriteMessage(BinaryMessage, []byte{0, 1, 2, 255}))
+		require.NoError(t, c.WriteMessage(TextMessage, make([]byte, 0)))
+
+		for _, expected := range []struct {
+			messageType MessageType
+			data        []byte
+		}{{TextMessage, []byte("héllo")}, {BinaryMessage, []byte{0, 1, 2, 255}}, {TextMessage, []byte{}}} {
+			messageType, data, err := c.ReadMessage()
+			require.NoError(t, err)
+			assert.Equal(t, expected.messageType, messageType)
+			assert.Equal(t, expected.data, data)
+		}
+	})
+
+	t.Run("Reassembles fragmented messages", func(t *testing.T) {
+		c := dial(t, address)
+		c.FragmentSize = 3
+		require.NoError(t, c.WriteMessage(TextMessage, []byte("fragmented message")))
+		_, data, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "fragmented message", string(data))
+	})
+
+	t.Run("Answers pings interleaved with fragments", func(t *testing.T) {
+		conn, reader := handshake(t, address)
+		defer conn.Close()
+		writeClientFrame(t, conn, opText, false, []byte("Hel"))
+		writeClientFrame(t, conn, opPing, true, []byte("are you there"))
+		writeClientFrame(t, conn, opContinuation, true, []byte("lo"))
+
+		assert.Equal(t, frame{fin: true, opcode: opPong, payload: []byte("are you there")}, readServerFrame(t, reader))
+		assert.Equal(t, frame{fin: true, opcode: opText, payload: []byte("Hello")}, readServerFrame(t, reader))
+	})
+
+	t.Run("Reads frames sent right behind the handshake", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		var early bytes.Buffer
+		writeClientFrame(t, &bufferConn{Conn: conn, Writer: &early}, opText, true, []byte("eager"))
+		_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"+early.String())
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := response.ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusSwitchingProtocols, resp.StatusCode())
+		assert.Equal(t, frame{fin: true, opcode: opText, payload: []byte("eager")}, readServerFrame(t, reader))
+	})
+
+	t.Run("Passes pongs to the pong handler", func(t *testing.T) {
+		c := dial(t, address)
+		pongs := make(chan string, 1)
+		c.PongHandler = func(data []byte) { pongs <- string(data) }
+		require.NoError(t, c.Ping([]byte("ping")))
+		require.NoError(t, c.WriteMessage(TextMessage, []byte("after ping")))
+		_, data, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "after ping", string(data))
+		assert.Equal(t, "ping", <-pongs)
+	})
+
+	t.Run("Completes the closing handshake", func(t *testing.T) {
+		c := dial(t, address)
+		require.NoError(t, c.Close(CloseGoingAway, "bye"))
+		assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, <-closes)
+		assert.ErrorIs(t, c.WriteMessage(TextMessage, []byte("late")), ErrCloseSent)
+	})
+
+	t.Run("Cuts long close reasons at a rune boundary", func(t *testing.T) {
+		c := dial(t, address)
+		// 123 bytes fit next to the code, the 62nd two byte rune does not
+		require.NoError(t, c.Close(CloseGoingAway, strings.Repeat("é", 100)))
+		assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: strings.Repeat("é", 61)}, <-closes)
+	})
+
+	t.Run("Closes with 1009 on messages over the size limit", func(t *testing.T) {
+		c := dial(t, address)
+		require.NoError(t, c.WriteMessage(BinaryMessage, make([]byte, 65)))
+		_, _, err := c.ReadMessage()
+		var closeErr *CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+	})
+
+	t.Run("Closes with 1007 on invalid UTF-8 text", func(t *testing.T) {
+		c := dial(t, address)
+		require.NoError(t, c.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+		_, _, err := c.ReadMessage()
+		var closeErr *CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, CloseInvalidPayload, closeErr.Code)
+	})
+
+	t.Run("Closes with 1002 on protocol violations", func(t *testing.T) {
+		for name, data := range map[string][]byte{
+			"unmasked frame":     {0x81, 0x02, 'h', 'i'},
+			"reserved bits":      {0xc1, 0x80, 0, 0, 0, 0},
+			"unknown opcode":     {0x83, 0x80, 0, 0, 0, 0},
+			"fragmented ping":    {0x09, 0x80, 0, 0, 0, 0},
+			"lone continuation":  {0x80, 0x80, 0, 0, 0, 0},
+			"one byte close":     {0x88, 0x81, 0, 0, 0, 0, 1},
+			"invalid close code": {0x88, 0x82, 0, 0, 0, 0, 0x03, 0xec},
+		} {
+			conn, reader := handshake(t, address)
+			_, err := conn.Write(data)
+			require.NoError(t, err)
+			f := readServerFrame(t, reader)
+			assert.Equal(t, byte(opClose), f.opcode, name)
+			assert.Equal(t, CloseProtocolError, int(binary.BigEndian.Uint16(f.payload)), name)
+			conn.Close()
+		}
+	})
+}
+
+// startEchoServer serves a WebSocket echoing every message, the close error
+// of each connection is sent on the returned channel.
+func startEchoServer(t *testing.T, maxMessageSize int64) (string, <-chan error) {
+	t.Helper()
+	closes := make(chan error, 16)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		c.MaxMessageSize = maxMessageSize
+		for {
+			messageType, data, err := c.ReadMessage()
+			if err != nil {
+				if closeErr, ok := err.(*CloseError); ok {
+					closes <- closeErr
+				}
+				return
+			}
+			if err := c.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String(), closes
+}
+
+func dial(t *testing.T, address string) *Conn {
+	t.Helper()
+	c, err := Dial(address, "/ws", time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.conn.Close() })
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func rawRequest(t *testing.T, address string, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	conn.(*net.TCPConn).CloseWrite()
+	response, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(response)
+}
+
+// handshake upgrades a raw connection, leaving the framing to the test.
+func handshake(t *testing.T, address string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	head := ""
+	for !strings.HasSuffix(head, "\r\n\r\n") {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		head += line
+	}
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"), head)
+	assert.Contains(t, head, "Upgrade: websocket\r\n")
+	assert.Contains(t, head, "Sec-Websocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	return conn, reader
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, fin bool, payload []byte) {
+	t.Helper()
+	c := &Conn{conn: conn, client: true}
+	require.NoError(t, c.writeFrame(opcode, fin, payload))
+}
+
+func readServerFrame(t *testing.T, reader *bufio.Reader) frame {
+	t.Helper()
+	c := &Conn{reader: reader, client: true}
+	f, err := c.readFrame(DefaultMaxMessageSize)
+	require.NoError(t, err)
+	return f
+}
+
+// bufferConn captures writes instead of sending them.
+type bufferConn struct {
+	net.Conn
+	io.Writer
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	return c.Writer.Write(p)
+}